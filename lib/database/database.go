@@ -2,29 +2,121 @@ package database
 
 import (
 	"io"
-	"github.com/satori/go.uuid"
+	"time"
+
+	"github.com/riking/go-keepass2/lib"
 	"github.com/riking/go-keepass2/lib/keys"
 	"github.com/riking/go-keepass2/lib/kpstruct"
-	"time"
+	"github.com/satori/go.uuid"
 )
 
 type Database struct {
-	Source              DBConnection
+	Source DBConnection
+
+	Name                   string
+	NameChanged            time.Time
+	Description            string
+	DescriptionChanged     time.Time
+	DefaultUserName        string
+	DefaultUserNameChanged time.Time
+	// MaintenanceHistoryDays is the age in days after which deleted
+	// objects and history entries may be removed by maintenance.
+	MaintenanceHistoryDays uint32
+	// Color is the HTML color of the database, such as "#FF0000", or empty.
+	Color string
+
+	MasterKeyChanged time.Time
+	// MasterKeyChangeRec and MasterKeyChangeForce are the number of days
+	// after which changing the master key is recommended or forced, or -1.
+	MasterKeyChangeRec   int64
+	MasterKeyChangeForce int64
 
-	Name string
-	NameChanged time.Time
-	Description string
-	DescriptionChanged time.Time
+	MemoryProtection MemoryProtectionConfig
+	CustomIcons      []CustomIcon
 
+	RecycleBinEnabled          bool
+	RecycleBinUUID             uuid.UUID
+	RecycleBinChanged          time.Time
+	EntryTemplatesGroup        uuid.UUID
+	EntryTemplatesGroupChanged time.Time
 
-	CipherID            uuid.UUID
-	Compression         CompressionAlgorithmID
-	KeyEncryptionRounds uint64
-	InnerRandomStream   CipherRandomStreamID
+	// HistoryMaxItems is the number of history entries kept per entry, or -1
+	// for no limit.
+	HistoryMaxItems int32
+	// HistoryMaxSize is the total size in bytes of the history of an entry,
+	// or -1 for no limit.
+	HistoryMaxSize int64
 
-	MasterKey           keys.Composite
+	LastSelectedGroup   uuid.UUID
+	LastTopVisibleGroup uuid.UUID
+
+	// CustomData holds settings of plugins and other applications.
+	CustomData map[string]string
+
+	// FormatVersion selects the file format written by WriteOut,
+	// FileVersion31 or FileVersion40.
+	FormatVersion uint32
+	CipherID      uuid.UUID
+	Compression   CompressionAlgorithmID
+	// KdfParameters selects the key derivation function and its settings,
+	// see keys.KDF.
+	KdfParameters     *lib.VariantDictionary
+	InnerRandomStream CipherRandomStreamID
+	// PublicCustomData is stored unencrypted in the KDBX 4 header.
+	PublicCustomData *lib.VariantDictionary
+
+	MasterKey keys.Composite
+
+	Root *kpstruct.PasswordGroup
+	// DeletedObjects lists the entries and groups that have been removed
+	// from the database.
+	DeletedObjects []kpstruct.DeletedObject
+}
+
+// MemoryProtectionConfig selects the standard entry fields that are
+// protected in memory and in the file.
+//
+// File: KeePassLib/PwDatabase.cs
+// MemoryProtectionConfig
+type MemoryProtectionConfig struct {
+	ProtectTitle    bool
+	ProtectUserName bool
+	ProtectPassword bool
+	ProtectURL      bool
+	ProtectNotes    bool
+}
 
-	Root                kpstruct.PasswordGroup
+// GetProtection reports whether the standard field with the given name must
+// be protected. It returns false for custom fields.
+//
+// File: KeePassLib/PwDatabase.cs
+// MemoryProtectionConfig.GetProtection()
+func (mp *MemoryProtectionConfig) GetProtection(field string) bool {
+	switch field {
+	case kpstruct.TitleField:
+		return mp.ProtectTitle
+	case kpstruct.UserNameField:
+		return mp.ProtectUserName
+	case kpstruct.PasswordField:
+		return mp.ProtectPassword
+	case kpstruct.URLField:
+		return mp.ProtectURL
+	case kpstruct.NotesField:
+		return mp.ProtectNotes
+	}
+	return false
+}
+
+// CustomIcon is an icon image stored in the database, referenced by the
+// CustomIconUUID of entries and groups.
+//
+// File: KeePassLib/PwCustomIcon.cs
+type CustomIcon struct {
+	UUID uuid.UUID
+	// Data is the image, usually in PNG format.
+	Data                 []byte
+	Name                 string
+	LastModificationTime time.Time
 }
 
 type DBConnection interface {
@@ -34,7 +126,96 @@ type DBConnection interface {
 	io.WriterAt
 }
 
+// New creates an empty database with the KeePass default settings.
+//
+// File: KeePassLib/PwDatabase.cs
+// constructor, Clear()
 func New() *Database {
-	// TODO
-	return nil
-}
\ No newline at end of file
+	now := kpstruct.TimeNow()
+	return &Database{
+		NameChanged:                now,
+		DescriptionChanged:         now,
+		DefaultUserNameChanged:     now,
+		MaintenanceHistoryDays:     365,
+		MasterKeyChanged:           now,
+		MasterKeyChangeRec:         -1,
+		MasterKeyChangeForce:       -1,
+		MemoryProtection:           MemoryProtectionConfig{ProtectPassword: true},
+		RecycleBinEnabled:          true,
+		RecycleBinChanged:          now,
+		EntryTemplatesGroupChanged: now,
+		HistoryMaxItems:            10,
+		HistoryMaxSize:             6 * 1024 * 1024,
+		CustomData:                 make(map[string]string),
+
+		FormatVersion:     FileVersion40,
+		CipherID:          CipherUUIDAesParsed,
+		Compression:       CompressionGzip,
+		KdfParameters:     keys.AesKDF{}.DefaultParameters(),
+		InnerRandomStream: StreamCipherChaCha20,
+		PublicCustomData:  lib.NewVariantDictionary(),
+
+		Root: kpstruct.NewPasswordGroup("Root"),
+	}
+}
+
+// RemoveEntry removes pe from its group, and records it in DeletedObjects.
+// It reports whether pe was in a group.
+//
+// File: KeePassLib/PwDatabase.cs
+// DeleteEntry, as done by KeePass when the recycle bin is not used
+func (db *Database) RemoveEntry(pe *kpstruct.PasswordEntry) bool {
+	parent := pe.ParentGroup()
+	if parent == nil || !parent.RemoveEntry(pe) {
+		return false
+	}
+	db.DeletedObjects = append(db.DeletedObjects, kpstruct.NewDeletedObject(pe.UUID))
+	return true
+}
+
+// RemoveGroup removes pg from its parent group, and records it and all of
+// its subgroups and entries in DeletedObjects. It reports whether pg had a
+// parent; the root group cannot be removed.
+//
+// File: KeePassLib/PwDatabase.cs
+// DeleteGroup, as done by KeePass when the recycle bin is not used
+func (db *Database) RemoveGroup(pg *kpstruct.PasswordGroup) bool {
+	parent := pg.Parent()
+	if parent == nil || !parent.RemoveGroup(pg) {
+		return false
+	}
+	pg.TraverseTree(kpstruct.TraversalMethodPreOrder, func(g *kpstruct.PasswordGroup) kpstruct.TraverseAction {
+		db.DeletedObjects = append(db.DeletedObjects, kpstruct.NewDeletedObject(g.UUID))
+		return kpstruct.TraverseContinue
+	}, func(pe *kpstruct.PasswordEntry) kpstruct.TraverseAction {
+		db.DeletedObjects = append(db.DeletedObjects, kpstruct.NewDeletedObject(pe.UUID))
+		return kpstruct.TraverseContinue
+	})
+	db.DeletedObjects = append(db.DeletedObjects, kpstruct.NewDeletedObject(pg.UUID))
+	return true
+}
+
+// EditEntry saves the current state of pe in its history, calls fn to
+// change it, and trims its history to the HistoryMaxItems and
+// HistoryMaxSize limits.
+func (db *Database) EditEntry(pe *kpstruct.PasswordEntry, fn func(pe *kpstruct.PasswordEntry)) {
+	pe.Edit(fn)
+	pe.MaintainBackups(int(db.HistoryMaxItems), db.HistoryMaxSize)
+}
+
+// MaintainBackups trims the history of every entry to the HistoryMaxItems
+// and HistoryMaxSize limits. It reports whether any history entry was
+// removed.
+//
+// File: KeePassLib/PwDatabase.cs
+// MaintainBackups()
+func (db *Database) MaintainBackups() bool {
+	removed := false
+	db.Root.TraverseTree(kpstruct.TraversalMethodPreOrder, nil, func(pe *kpstruct.PasswordEntry) kpstruct.TraverseAction {
+		if pe.MaintainBackups(int(db.HistoryMaxItems), db.HistoryMaxSize) {
+			removed = true
+		}
+		return kpstruct.TraverseContinue
+	})
+	return removed
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+	"github.com/riking/go-keepass2/lib/kpstruct"
+)
+
+func TestEditEntryLimitsHistory(t *testing.T) {
+	db := New()
+	db.HistoryMaxItems = 2
+	pe := kpstruct.NewPasswordEntry()
+	db.Root.AddEntry(pe)
+
+	for _, password := range []string{"a", "b", "c", "d"} {
+		db.EditEntry(pe, func(pe *kpstruct.PasswordEntry) {
+			pe.Strings.Set(kpstruct.PasswordField, kpcrypto.NewProtectedString(true, password))
+		})
+	}
+	if len(pe.History) != 2 {
+		t.Fatalf("%d history entries, want 2", len(pe.History))
+	}
+	if got := pe.History[1].Strings.GetSafe(kpstruct.PasswordField); got != "c" {
+		t.Errorf("newest history entry has password %q, want %q", got, "c")
+	}
+}
+
+func TestDatabaseMaintainBackups(t *testing.T) {
+	db := New()
+	group := kpstruct.NewPasswordGroup("Group")
+	db.Root.AddGroup(group)
+	pe := kpstruct.NewPasswordEntry()
+	group.AddEntry(pe)
+	for i := 0; i < 3; i++ {
+		pe.CreateBackup()
+	}
+
+	db.HistoryMaxItems = -1
+	db.HistoryMaxSize = -1
+	if db.MaintainBackups() {
+		t.Errorf("MaintainBackups without limits removed history entries")
+	}
+	db.HistoryMaxSize = int64(pe.History[0].Size())
+	if !db.MaintainBackups() || len(pe.History) != 1 {
+		t.Errorf("size limit: %d history entries, want 1", len(pe.History))
+	}
+	db.HistoryMaxItems = 0
+	if !db.MaintainBackups() || len(pe.History) != 0 {
+		t.Errorf("item limit: %d history entries, want 0", len(pe.History))
+	}
+}
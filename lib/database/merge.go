@@ -0,0 +1,352 @@
+package database
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/riking/go-keepass2/lib/kpstruct"
+	"github.com/satori/go.uuid"
+)
+
+// MergeMethod selects how MergeIn combines two databases.
+//
+// File: KeePassLib/PwEnums.cs
+// PwMergeMethod
+type MergeMethod int
+
+const (
+	// MergeMethodOverwriteExisting replaces the objects of the database with
+	// the objects of the other database.
+	MergeMethodOverwriteExisting MergeMethod = iota
+	// MergeMethodKeepExisting only adds the objects that the database does
+	// not have.
+	MergeMethodKeepExisting
+	// MergeMethodOverwriteIfNewer replaces the objects of the database with
+	// the objects of the other database that were modified later.
+	MergeMethodOverwriteIfNewer
+	// MergeMethodCreateNewUuids adds all objects of the other database as
+	// new objects.
+	MergeMethodCreateNewUuids
+	// MergeMethodSynchronize keeps the newest version of each object, merges
+	// entry histories, and applies the deletions and moves made in either
+	// database.
+	MergeMethodSynchronize
+)
+
+// ErrUnknownMergeMethod is returned by MergeIn for an invalid MergeMethod.
+var ErrUnknownMergeMethod = errors.New("keepass: unknown merge method")
+
+// merger holds the state of a MergeIn call.
+type merger struct {
+	db     *Database
+	other  *Database
+	method MergeMethod
+
+	// groups maps the groups of other to the matching groups of db.
+	groups map[*kpstruct.PasswordGroup]*kpstruct.PasswordGroup
+	// localGroups and localEntries index the objects of db by UUID.
+	localGroups  map[uuid.UUID]*kpstruct.PasswordGroup
+	localEntries map[uuid.UUID]*kpstruct.PasswordEntry
+}
+
+// MergeIn merges the groups, entries and settings of other into db. other
+// is not modified, and db does not share any groups or entries with it
+// afterwards.
+//
+// File: KeePassLib/PwDatabase.cs
+// MergeIn()
+func (db *Database) MergeIn(other *Database, method MergeMethod) error {
+	if method < MergeMethodOverwriteExisting || method > MergeMethodSynchronize {
+		return ErrUnknownMergeMethod
+	}
+
+	m := &merger{
+		db:           db,
+		other:        other,
+		method:       method,
+		groups:       map[*kpstruct.PasswordGroup]*kpstruct.PasswordGroup{other.Root: db.Root},
+		localGroups:  map[uuid.UUID]*kpstruct.PasswordGroup{db.Root.UUID: db.Root},
+		localEntries: make(map[uuid.UUID]*kpstruct.PasswordEntry),
+	}
+	db.Root.TraverseTree(kpstruct.TraversalMethodPreOrder, func(pg *kpstruct.PasswordGroup) kpstruct.TraverseAction {
+		m.localGroups[pg.UUID] = pg
+		return kpstruct.TraverseContinue
+	}, func(pe *kpstruct.PasswordEntry) kpstruct.TraverseAction {
+		m.localEntries[pe.UUID] = pe
+		return kpstruct.TraverseContinue
+	})
+
+	if uuid.Equal(db.Root.UUID, other.Root.UUID) {
+		m.mergeGroupProperties(db.Root, other.Root)
+	}
+	other.Root.TraverseTree(kpstruct.TraversalMethodPreOrder, m.mergeGroup, m.mergeEntry)
+
+	if method == MergeMethodSynchronize {
+		m.relocateObjects()
+		m.applyDeletions()
+	}
+
+	m.mergeCustomIcons()
+	m.mergeDbProperties()
+	if method == MergeMethodSynchronize {
+		db.MaintainBackups()
+	}
+	return nil
+}
+
+// mergeGroup merges a group of the other database into its match, or adds
+// it to the database.
+func (m *merger) mergeGroup(src *kpstruct.PasswordGroup) kpstruct.TraverseAction {
+	var local *kpstruct.PasswordGroup
+	if m.method != MergeMethodCreateNewUuids {
+		local = m.localGroups[src.UUID]
+	}
+
+	if local == nil {
+		local = &kpstruct.PasswordGroup{UUID: src.UUID}
+		if m.method == MergeMethodCreateNewUuids {
+			local.UUID = uuid.NewV4()
+		}
+		local.AssignProperties(src, false, true)
+		// The parent is merged before its subgroups, and a new group
+		// cannot be its own ancestor
+		m.groups[src.Parent()].AddGroup(local)
+		m.localGroups[local.UUID] = local
+	} else {
+		m.mergeGroupProperties(local, src)
+	}
+
+	m.groups[src] = local
+	return kpstruct.TraverseContinue
+}
+
+func (m *merger) mergeGroupProperties(local, src *kpstruct.PasswordGroup) {
+	switch m.method {
+	case MergeMethodOverwriteExisting:
+		local.AssignProperties(src, false, false)
+	case MergeMethodOverwriteIfNewer, MergeMethodSynchronize:
+		local.AssignProperties(src, true, false)
+	}
+}
+
+// mergeEntry merges an entry of the other database into its match, or adds
+// a copy of it to the database.
+//
+// File: KeePassLib/PwDatabase.cs
+// MergeIn(), EntryHandler
+func (m *merger) mergeEntry(src *kpstruct.PasswordEntry) kpstruct.TraverseAction {
+	var local *kpstruct.PasswordEntry
+	if m.method != MergeMethodCreateNewUuids {
+		local = m.localEntries[src.UUID]
+	}
+
+	if local == nil {
+		pe := src.CloneDeep()
+		if m.method == MergeMethodCreateNewUuids {
+			pe.UUID = uuid.NewV4()
+			for _, h := range pe.History {
+				h.UUID = pe.UUID
+			}
+		}
+		m.groups[src.ParentGroup()].AddEntry(pe)
+		m.localEntries[pe.UUID] = pe
+		return kpstruct.TraverseContinue
+	}
+
+	sameData := local.HasSameData(src)
+	srcNewer := src.Times.LastModificationTime.After(local.Times.LastModificationTime)
+	switch m.method {
+	case MergeMethodOverwriteExisting:
+		if !sameData {
+			addBackup(local, local)
+		}
+		local.AssignProperties(src, false, false, false)
+	case MergeMethodOverwriteIfNewer:
+		if srcNewer {
+			if !sameData {
+				addBackup(local, local)
+			}
+			local.AssignProperties(src, false, false, false)
+		}
+	case MergeMethodSynchronize:
+		if !sameData {
+			if srcNewer {
+				addBackup(local, local)
+				local.AssignProperties(src, false, false, false)
+			} else if local.Times.LastModificationTime.After(src.Times.LastModificationTime) {
+				addBackup(local, src)
+			}
+		}
+		mergeEntryHistory(local, src)
+	}
+	return kpstruct.TraverseContinue
+}
+
+// addBackup adds a copy of version, without its history, to the history of
+// pe, unless the history already has a version modified at the same time.
+func addBackup(pe, version *kpstruct.PasswordEntry) {
+	for _, h := range pe.History {
+		if h.Times.LastModificationTime.Equal(version.Times.LastModificationTime) {
+			return
+		}
+	}
+	backup := version.CloneDeep()
+	backup.History = nil
+	pe.History = append(pe.History, backup)
+}
+
+// mergeEntryHistory adds the history entries of src that local does not
+// have, and sorts the history by modification time.
+//
+// File: KeePassLib/PwDatabase.cs
+// MergeEntryHistory()
+func mergeEntryHistory(local, src *kpstruct.PasswordEntry) {
+	for _, h := range src.History {
+		addBackup(local, h)
+	}
+	sort.SliceStable(local.History, func(i, j int) bool {
+		return local.History[i].Times.LastModificationTime.Before(local.History[j].Times.LastModificationTime)
+	})
+}
+
+// relocateObjects moves the groups and entries of the database that were
+// moved in the other database later than locally.
+//
+// File: KeePassLib/PwDatabase.cs
+// RelocateGroups(), RelocateEntries()
+func (m *merger) relocateObjects() {
+	m.other.Root.TraverseTree(kpstruct.TraversalMethodPreOrder, func(src *kpstruct.PasswordGroup) kpstruct.TraverseAction {
+		local := m.groups[src]
+		parent := m.groups[src.Parent()]
+		if local.Parent() != nil && local.Parent() != parent &&
+			src.Times.LocationChanged.After(local.Times.LocationChanged) {
+			// A move that would create a cycle is skipped
+			if parent.AddGroup(local) == nil {
+				local.Times.LocationChanged = src.Times.LocationChanged
+			}
+		}
+		return kpstruct.TraverseContinue
+	}, func(src *kpstruct.PasswordEntry) kpstruct.TraverseAction {
+		local := m.localEntries[src.UUID]
+		parent := m.groups[src.ParentGroup()]
+		if local.ParentGroup() != parent && src.Times.LocationChanged.After(local.Times.LocationChanged) {
+			parent.AddEntry(local)
+			local.Times.LocationChanged = src.Times.LocationChanged
+		}
+		return kpstruct.TraverseContinue
+	})
+}
+
+// applyDeletions merges the deleted object lists, and removes the objects
+// that were deleted after they were last modified. Groups are only removed
+// once they are empty.
+//
+// File: KeePassLib/PwDatabase.cs
+// ApplyDeletions()
+func (m *merger) applyDeletions() {
+	db := m.db
+	index := make(map[uuid.UUID]int, len(db.DeletedObjects))
+	for i, obj := range db.DeletedObjects {
+		index[obj.UUID] = i
+	}
+	for _, obj := range m.other.DeletedObjects {
+		if i, ok := index[obj.UUID]; ok {
+			if obj.DeletionTime.After(db.DeletedObjects[i].DeletionTime) {
+				db.DeletedObjects[i].DeletionTime = obj.DeletionTime
+			}
+			continue
+		}
+		index[obj.UUID] = len(db.DeletedObjects)
+		db.DeletedObjects = append(db.DeletedObjects, obj)
+	}
+
+	for _, obj := range db.DeletedObjects {
+		pe := m.localEntries[obj.UUID]
+		if pe != nil && pe.ParentGroup() != nil && pe.Times.LastModificationTime.Before(obj.DeletionTime) {
+			pe.ParentGroup().RemoveEntry(pe)
+		}
+	}
+
+	db.Root.TraverseTree(kpstruct.TraversalMethodPostOrder, func(pg *kpstruct.PasswordGroup) kpstruct.TraverseAction {
+		i, ok := index[pg.UUID]
+		if ok && pg.Times.LastModificationTime.Before(db.DeletedObjects[i].DeletionTime) &&
+			len(pg.Groups()) == 0 && len(pg.Entries()) == 0 {
+			pg.Parent().RemoveGroup(pg)
+		}
+		return kpstruct.TraverseContinue
+	}, nil)
+}
+
+// mergeCustomIcons adds the custom icons of the other database that the
+// database does not have, and replaces icons according to the merge method.
+//
+// File: KeePassLib/PwDatabase.cs
+// MergeInCustomIcons()
+func (m *merger) mergeCustomIcons() {
+	db := m.db
+	for _, icon := range m.other.CustomIcons {
+		found := false
+		for i := range db.CustomIcons {
+			local := &db.CustomIcons[i]
+			if !uuid.Equal(local.UUID, icon.UUID) {
+				continue
+			}
+			found = true
+			if m.method == MergeMethodOverwriteExisting ||
+				(m.method == MergeMethodOverwriteIfNewer || m.method == MergeMethodSynchronize) &&
+					icon.LastModificationTime.After(local.LastModificationTime) {
+				*local = icon
+				local.Data = append([]byte(nil), icon.Data...)
+			}
+			break
+		}
+		if !found {
+			icon.Data = append([]byte(nil), icon.Data...)
+			db.CustomIcons = append(db.CustomIcons, icon)
+		}
+	}
+}
+
+// mergeDbProperties merges the database settings that have a changed time,
+// and the custom data.
+//
+// File: KeePassLib/PwDatabase.cs
+// MergeInDbProperties()
+func (m *merger) mergeDbProperties() {
+	if m.method == MergeMethodKeepExisting || m.method == MergeMethodCreateNewUuids {
+		return
+	}
+	db, other := m.db, m.other
+	force := m.method == MergeMethodOverwriteExisting
+
+	if force || other.NameChanged.After(db.NameChanged) {
+		db.Name = other.Name
+		db.NameChanged = other.NameChanged
+	}
+	if force || other.DescriptionChanged.After(db.DescriptionChanged) {
+		db.Description = other.Description
+		db.DescriptionChanged = other.DescriptionChanged
+	}
+	if force || other.DefaultUserNameChanged.After(db.DefaultUserNameChanged) {
+		db.DefaultUserName = other.DefaultUserName
+		db.DefaultUserNameChanged = other.DefaultUserNameChanged
+	}
+	if force || other.RecycleBinChanged.After(db.RecycleBinChanged) {
+		db.RecycleBinEnabled = other.RecycleBinEnabled
+		db.RecycleBinUUID = other.RecycleBinUUID
+		db.RecycleBinChanged = other.RecycleBinChanged
+	}
+	if force || other.EntryTemplatesGroupChanged.After(db.EntryTemplatesGroupChanged) {
+		db.EntryTemplatesGroup = other.EntryTemplatesGroup
+		db.EntryTemplatesGroupChanged = other.EntryTemplatesGroupChanged
+	}
+
+	if db.CustomData == nil {
+		db.CustomData = make(map[string]string)
+	}
+	for k, v := range other.CustomData {
+		if _, ok := db.CustomData[k]; force || !ok {
+			db.CustomData[k] = v
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package database
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+	"github.com/riking/go-keepass2/lib/kpstruct"
+	"github.com/satori/go.uuid"
+)
+
+// cloneDatabase returns a copy of db that shares no groups or entries with
+// it, as if it had been saved and opened on another computer.
+func cloneDatabase(t *testing.T, db *Database) *Database {
+	var buf bytes.Buffer
+	if _, err := db.WriteOut(&buf, WriteFormatPlain); err != nil {
+		t.Fatal(err)
+	}
+	out := New()
+	if err := out.ReadIn(&buf, WriteFormatPlain); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func newTitledEntry(title string, modified time.Time) *kpstruct.PasswordEntry {
+	pe := kpstruct.NewPasswordEntry()
+	pe.Strings.Set(kpstruct.TitleField, kpcrypto.NewProtectedString(false, title))
+	pe.Times = kpstruct.Times{
+		CreationTime:         testTime,
+		LastModificationTime: modified,
+		LastAccessTime:       modified,
+		ExpiryTime:           testTime,
+		LocationChanged:      testTime,
+	}
+	return pe
+}
+
+func setTitle(pe *kpstruct.PasswordEntry, title string, modified time.Time) {
+	pe.Strings.Set(kpstruct.TitleField, kpcrypto.NewProtectedString(false, title))
+	pe.Times.LastModificationTime = modified
+}
+
+func title(pe *kpstruct.PasswordEntry) string {
+	return pe.Strings.GetSafe(kpstruct.TitleField)
+}
+
+func TestMergeMethods(t *testing.T) {
+	tests := []struct {
+		method       MergeMethod
+		titleE       string
+		titleO       string
+		historyE     int
+		historyO     int
+		sameUUIDs    bool
+		totalEntries int
+	}{
+		{MergeMethodOverwriteExisting, "E remote", "O remote", 1, 1, true, 3},
+		{MergeMethodKeepExisting, "E local", "O local", 0, 0, true, 3},
+		{MergeMethodOverwriteIfNewer, "E remote", "O local", 1, 0, true, 3},
+		{MergeMethodCreateNewUuids, "E local", "O local", 0, 0, false, 5},
+		{MergeMethodSynchronize, "E remote", "O local", 1, 1, true, 3},
+	}
+
+	for _, tt := range tests {
+		db := New()
+		e := newTitledEntry("E local", testTime.Add(time.Hour))
+		o := newTitledEntry("O local", testTime.Add(time.Hour))
+		db.Root.AddEntry(e)
+		db.Root.AddEntry(o)
+
+		// In the other database, E was changed later and O earlier than in
+		// the database, and N was added.
+		other := cloneDatabase(t, db)
+		setTitle(other.Root.FindEntry(e.UUID, true), "E remote", testTime.Add(2*time.Hour))
+		setTitle(other.Root.FindEntry(o.UUID, true), "O remote", testTime)
+		n := newTitledEntry("N", testTime)
+		other.Root.AddEntry(n)
+
+		if err := db.MergeIn(other, tt.method); err != nil {
+			t.Errorf("method %d: %v", tt.method, err)
+			continue
+		}
+
+		if got := title(e); got != tt.titleE {
+			t.Errorf("method %d: E title %q, want %q", tt.method, got, tt.titleE)
+		}
+		if got := title(o); got != tt.titleO {
+			t.Errorf("method %d: O title %q, want %q", tt.method, got, tt.titleO)
+		}
+		if len(e.History) != tt.historyE {
+			t.Errorf("method %d: E has %d history entries, want %d", tt.method, len(e.History), tt.historyE)
+		}
+		if len(o.History) != tt.historyO {
+			t.Errorf("method %d: O has %d history entries, want %d", tt.method, len(o.History), tt.historyO)
+		}
+		if _, entries := db.Root.GetCounts(true); int(entries) != tt.totalEntries {
+			t.Errorf("method %d: %d entries, want %d", tt.method, entries, tt.totalEntries)
+		}
+
+		merged := db.Root.FindEntry(n.UUID, true)
+		if tt.sameUUIDs {
+			if merged == nil {
+				t.Errorf("method %d: N was not added", tt.method)
+			} else if merged == n {
+				t.Errorf("method %d: N is shared with the other database", tt.method)
+			}
+		} else {
+			if merged != nil {
+				t.Errorf("method %d: N kept its UUID", tt.method)
+			}
+			seen := make(map[uuid.UUID]bool)
+			db.Root.TraverseTree(kpstruct.TraversalMethodPreOrder, nil, func(pe *kpstruct.PasswordEntry) kpstruct.TraverseAction {
+				if seen[pe.UUID] {
+					t.Errorf("method %d: duplicate UUID %v", tt.method, pe.UUID)
+				}
+				seen[pe.UUID] = true
+				return kpstruct.TraverseContinue
+			})
+		}
+	}
+
+	if err := New().MergeIn(New(), MergeMethodSynchronize+1); err != ErrUnknownMergeMethod {
+		t.Errorf("bad method: got %v, want ErrUnknownMergeMethod", err)
+	}
+}
+
+func TestSynchronizeEdits(t *testing.T) {
+	db := New()
+	newer := newTitledEntry("local", testTime.Add(2*time.Hour))
+	older := newTitledEntry("local", testTime.Add(time.Hour))
+	db.Root.AddEntry(newer)
+	db.Root.AddEntry(older)
+
+	other := cloneDatabase(t, db)
+	setTitle(other.Root.FindEntry(newer.UUID, true), "remote", testTime.Add(time.Hour))
+	setTitle(other.Root.FindEntry(older.UUID, true), "remote", testTime.Add(2*time.Hour))
+
+	if err := db.MergeIn(other, MergeMethodSynchronize); err != nil {
+		t.Fatal(err)
+	}
+
+	// The newer version wins, and the other one goes into the history
+	if title(newer) != "local" || len(newer.History) != 1 || title(newer.History[0]) != "remote" {
+		t.Errorf("locally newer entry: title %q, history %d", title(newer), len(newer.History))
+	}
+	if title(older) != "remote" || len(older.History) != 1 || title(older.History[0]) != "local" {
+		t.Errorf("remotely newer entry: title %q, history %d", title(older), len(older.History))
+	}
+}
+
+func TestSynchronizeHistory(t *testing.T) {
+	db := New()
+	pe := newTitledEntry("v4", testTime.Add(4*time.Hour))
+	db.Root.AddEntry(pe)
+
+	other := cloneDatabase(t, db)
+	otherPe := other.Root.FindEntry(pe.UUID, true)
+	// Local history has versions 1 and 3, the other history 2 and 3
+	pe.History = []*kpstruct.PasswordEntry{
+		newTitledEntry("v3", testTime.Add(3*time.Hour)),
+		newTitledEntry("v1", testTime.Add(1*time.Hour)),
+	}
+	otherPe.History = []*kpstruct.PasswordEntry{
+		newTitledEntry("v2", testTime.Add(2*time.Hour)),
+		newTitledEntry("v3", testTime.Add(3*time.Hour)),
+	}
+	for _, h := range append(pe.History, otherPe.History...) {
+		h.UUID = pe.UUID
+	}
+
+	if err := db.MergeIn(other, MergeMethodSynchronize); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, h := range pe.History {
+		got = append(got, title(h))
+	}
+	want := []string{"v1", "v2", "v3"}
+	if len(got) != len(want) {
+		t.Fatalf("history %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("history %q, want %q", got, want)
+		}
+	}
+	if pe.History[1] == otherPe.History[0] {
+		t.Errorf("history entry is shared with the other database")
+	}
+}
+
+func TestSynchronizeDeletions(t *testing.T) {
+	db := New()
+	emptied := kpstruct.NewPasswordGroup("Emptied")
+	kept := kpstruct.NewPasswordGroup("Kept")
+	db.Root.AddGroup(emptied)
+	db.Root.AddGroup(kept)
+	deleted := newTitledEntry("deleted", testTime)
+	emptied.AddEntry(deleted)
+	// Modified after the deletion in the other database
+	edited := newTitledEntry("edited", time.Now().Add(time.Hour))
+	kept.AddEntry(edited)
+	for _, pg := range []*kpstruct.PasswordGroup{emptied, kept} {
+		pg.Times.LastModificationTime = testTime
+	}
+
+	oldTombstone := kpstruct.DeletedObject{UUID: uuid.NewV4(), DeletionTime: testTime}
+	db.DeletedObjects = []kpstruct.DeletedObject{oldTombstone}
+
+	other := cloneDatabase(t, db)
+	other.DeletedObjects = nil
+	other.RemoveGroup(other.Root.FindGroup(emptied.UUID, true))
+	other.RemoveGroup(other.Root.FindGroup(kept.UUID, true))
+	newTombstone := kpstruct.DeletedObject{UUID: oldTombstone.UUID, DeletionTime: testTime.Add(time.Hour)}
+	other.DeletedObjects = append(other.DeletedObjects, newTombstone)
+
+	if err := db.MergeIn(other, MergeMethodSynchronize); err != nil {
+		t.Fatal(err)
+	}
+
+	if db.Root.FindEntry(deleted.UUID, true) != nil {
+		t.Errorf("deleted entry was not removed")
+	}
+	if db.Root.FindGroup(emptied.UUID, true) != nil {
+		t.Errorf("emptied group was not removed")
+	}
+	if db.Root.FindEntry(edited.UUID, true) != edited {
+		t.Errorf("entry edited after the deletion was removed")
+	}
+	if db.Root.FindGroup(kept.UUID, true) != kept {
+		t.Errorf("group with a remaining entry was removed")
+	}
+
+	times := make(map[uuid.UUID]time.Time)
+	for _, obj := range db.DeletedObjects {
+		if _, ok := times[obj.UUID]; ok {
+			t.Errorf("duplicate deleted object %v", obj.UUID)
+		}
+		times[obj.UUID] = obj.DeletionTime
+	}
+	for _, id := range []uuid.UUID{emptied.UUID, kept.UUID, deleted.UUID, edited.UUID} {
+		if _, ok := times[id]; !ok {
+			t.Errorf("deleted object %v missing", id)
+		}
+	}
+	if !times[oldTombstone.UUID].Equal(newTombstone.DeletionTime) {
+		t.Errorf("deletion time %v, want the later %v", times[oldTombstone.UUID], newTombstone.DeletionTime)
+	}
+}
+
+func TestSynchronizeRelocation(t *testing.T) {
+	db := New()
+	target := kpstruct.NewPasswordGroup("Target")
+	local := kpstruct.NewPasswordGroup("Local")
+	moved := kpstruct.NewPasswordGroup("Moved")
+	db.Root.AddGroup(target)
+	db.Root.AddGroup(local)
+	db.Root.AddGroup(moved)
+	remoteMove := newTitledEntry("remote move", testTime)
+	localMove := newTitledEntry("local move", testTime)
+	db.Root.AddEntry(remoteMove)
+	db.Root.AddEntry(localMove)
+	moved.Times.LocationChanged = testTime
+
+	other := cloneDatabase(t, db)
+	otherTarget := other.Root.FindGroup(target.UUID, true)
+	// Moves in the other database, now
+	otherTarget.AddEntry(other.Root.FindEntry(remoteMove.UUID, true))
+	otherTarget.AddGroup(other.Root.FindGroup(moved.UUID, true))
+	// An earlier move in the other database than in this one
+	otherLocalMove := other.Root.FindEntry(localMove.UUID, true)
+	otherTarget.AddEntry(otherLocalMove)
+	otherLocalMove.Times.LocationChanged = testTime.Add(time.Hour)
+	local.AddEntry(localMove)
+
+	if err := db.MergeIn(other, MergeMethodSynchronize); err != nil {
+		t.Fatal(err)
+	}
+
+	if remoteMove.ParentGroup() != target {
+		t.Errorf("entry moved remotely is in %q", remoteMove.ParentGroup().Name)
+	}
+	if moved.Parent() != target {
+		t.Errorf("group moved remotely is in %q", moved.Parent().Name)
+	}
+	if localMove.ParentGroup() != local {
+		t.Errorf("entry moved locally later is in %q", localMove.ParentGroup().Name)
+	}
+}
+
+func TestMergeDbProperties(t *testing.T) {
+	newDb := func() *Database {
+		db := New()
+		db.Name, db.NameChanged = "local", testTime.Add(time.Hour)
+		db.Description, db.DescriptionChanged = "local", testTime.Add(time.Hour)
+		db.DefaultUserName, db.DefaultUserNameChanged = "local", testTime.Add(time.Hour)
+		db.RecycleBinEnabled, db.RecycleBinChanged = true, testTime.Add(time.Hour)
+		db.EntryTemplatesGroupChanged = testTime.Add(time.Hour)
+		db.CustomData = map[string]string{"shared": "local", "local": "local"}
+		return db
+	}
+	other := newDb()
+	other.Name, other.NameChanged = "remote", testTime.Add(2*time.Hour)
+	other.Description, other.DescriptionChanged = "remote", testTime
+	other.DefaultUserName = "remote"
+	other.RecycleBinEnabled, other.RecycleBinChanged = false, testTime.Add(2*time.Hour)
+	other.EntryTemplatesGroup, other.EntryTemplatesGroupChanged = uuid.NewV4(), testTime.Add(2*time.Hour)
+	other.CustomData = map[string]string{"shared": "remote", "remote": "remote"}
+
+	tests := []struct {
+		method      MergeMethod
+		name        string
+		description string
+		userName    string
+		binEnabled  bool
+		templates   uuid.UUID
+		shared      string
+		remoteData  bool
+	}{
+		{MergeMethodOverwriteExisting, "remote", "remote", "remote", false, other.EntryTemplatesGroup, "remote", true},
+		{MergeMethodKeepExisting, "local", "local", "local", true, uuid.Nil, "local", false},
+		{MergeMethodOverwriteIfNewer, "remote", "local", "local", false, other.EntryTemplatesGroup, "local", true},
+		{MergeMethodCreateNewUuids, "local", "local", "local", true, uuid.Nil, "local", false},
+		{MergeMethodSynchronize, "remote", "local", "local", false, other.EntryTemplatesGroup, "local", true},
+	}
+	for _, tt := range tests {
+		db := newDb()
+		if err := db.MergeIn(other, tt.method); err != nil {
+			t.Fatal(err)
+		}
+		if db.Name != tt.name || db.Description != tt.description || db.DefaultUserName != tt.userName {
+			t.Errorf("method %d: name %q, description %q, user name %q", tt.method, db.Name, db.Description, db.DefaultUserName)
+		}
+		if db.RecycleBinEnabled != tt.binEnabled || db.EntryTemplatesGroup != tt.templates {
+			t.Errorf("method %d: recycle bin %v, templates %v", tt.method, db.RecycleBinEnabled, db.EntryTemplatesGroup)
+		}
+		if tt.name == "remote" && !db.NameChanged.Equal(other.NameChanged) {
+			t.Errorf("method %d: NameChanged not copied", tt.method)
+		}
+		_, haveRemote := db.CustomData["remote"]
+		if db.CustomData["shared"] != tt.shared || haveRemote != tt.remoteData || db.CustomData["local"] != "local" {
+			t.Errorf("method %d: custom data %v", tt.method, db.CustomData)
+		}
+	}
+}
+
+func TestSynchronizeMaintainsBackups(t *testing.T) {
+	db := New()
+	db.HistoryMaxItems = 2
+	pe := newTitledEntry("v4", testTime.Add(4*time.Hour))
+	db.Root.AddEntry(pe)
+	other := cloneDatabase(t, db)
+	otherPe := other.Root.FindEntry(pe.UUID, true)
+	for i := 1; i <= 3; i++ {
+		h := newTitledEntry("old", testTime.Add(time.Duration(i)*time.Hour))
+		h.UUID = pe.UUID
+		otherPe.History = append(otherPe.History, h)
+	}
+
+	if err := db.MergeIn(other, MergeMethodSynchronize); err != nil {
+		t.Fatal(err)
+	}
+	if len(pe.History) != 2 {
+		t.Fatalf("%d history entries, want 2", len(pe.History))
+	}
+	if !pe.History[0].Times.LastModificationTime.Equal(testTime.Add(2 * time.Hour)) {
+		t.Errorf("oldest history entry was not removed")
+	}
+}
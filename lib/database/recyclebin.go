@@ -0,0 +1,109 @@
+package database
+
+import (
+	"github.com/riking/go-keepass2/lib/kpstruct"
+	"github.com/satori/go.uuid"
+)
+
+// RecycleBinName is the name of a newly created recycle bin group.
+const RecycleBinName = "Recycle Bin"
+
+// RecycleBin returns the recycle bin group, or nil if it does not exist.
+//
+// File: KeePassLib/PwDatabase.cs
+// RecycleBinUuid
+func (db *Database) RecycleBin() *kpstruct.PasswordGroup {
+	if uuid.Equal(db.RecycleBinUUID, uuid.Nil) {
+		return nil
+	}
+	return db.Root.FindGroup(db.RecycleBinUUID, true)
+}
+
+// ensureRecycleBin returns the recycle bin group, creating it if it does
+// not exist.
+//
+// File: KeePass/Forms/MainForm_Functions.cs
+// EnsureRecycleBin()
+func (db *Database) ensureRecycleBin() *kpstruct.PasswordGroup {
+	bin := db.RecycleBin()
+	if bin != nil {
+		return bin
+	}
+	bin = kpstruct.NewPasswordGroup(RecycleBinName)
+	bin.IconID = kpstruct.IconTrashBin
+	bin.IsExpanded = false
+	bin.EnableAutoType = kpstruct.TriStateFalse
+	bin.EnableSearching = kpstruct.TriStateFalse
+	db.Root.AddGroup(bin)
+
+	db.RecycleBinUUID = bin.UUID
+	db.RecycleBinChanged = kpstruct.TimeNow()
+	return bin
+}
+
+// isInRecycleBin reports whether pg is the recycle bin or one of its
+// subgroups.
+func isInRecycleBin(pg, bin *kpstruct.PasswordGroup) bool {
+	return bin != nil && (pg == bin || pg.IsContainedIn(bin))
+}
+
+// DeleteEntry deletes pe. If the recycle bin is enabled and pe is not in it,
+// pe is moved to the recycle bin, which is created if needed. Otherwise pe
+// is removed permanently and recorded in DeletedObjects. It reports whether
+// pe was in a group.
+//
+// File: KeePass/Forms/MainForm_Functions.cs
+// DeleteSelectedEntries()
+func (db *Database) DeleteEntry(pe *kpstruct.PasswordEntry) bool {
+	parent := pe.ParentGroup()
+	if parent == nil {
+		return false
+	}
+	if !db.RecycleBinEnabled || isInRecycleBin(parent, db.RecycleBin()) {
+		return db.RemoveEntry(pe)
+	}
+	db.ensureRecycleBin().AddEntry(pe)
+	return true
+}
+
+// DeleteGroup deletes pg. If the recycle bin is enabled and pg is not in it,
+// pg is moved to the recycle bin, which is created if needed. Otherwise pg
+// is removed permanently, and it and its contents are recorded in
+// DeletedObjects. Deleting the recycle bin, or a group containing it, is
+// always permanent. It reports whether pg had a parent; the root group
+// cannot be deleted.
+//
+// File: KeePass/Forms/MainForm_Functions.cs
+// DeleteSelectedGroup()
+func (db *Database) DeleteGroup(pg *kpstruct.PasswordGroup) bool {
+	parent := pg.Parent()
+	if parent == nil {
+		return false
+	}
+	bin := db.RecycleBin()
+	if !db.RecycleBinEnabled || isInRecycleBin(pg, bin) || (bin != nil && bin.IsContainedIn(pg)) {
+		return db.RemoveGroup(pg)
+	}
+	// pg cannot contain a newly created recycle bin
+	db.ensureRecycleBin().AddGroup(pg)
+	return true
+}
+
+// EmptyRecycleBin permanently removes the contents of the recycle bin, and
+// records them in DeletedObjects.
+//
+// File: KeePass/Forms/MainForm_Functions.cs
+// EmptyRecycleBin()
+func (db *Database) EmptyRecycleBin() {
+	bin := db.RecycleBin()
+	if bin == nil {
+		return
+	}
+	// Removing changes the lists, so remove from the end
+	for entries := bin.Entries(); len(entries) > 0; entries = bin.Entries() {
+		db.RemoveEntry(entries[len(entries)-1])
+	}
+	for groups := bin.Groups(); len(groups) > 0; groups = bin.Groups() {
+		db.RemoveGroup(groups[len(groups)-1])
+	}
+}
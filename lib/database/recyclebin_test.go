@@ -0,0 +1,172 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/riking/go-keepass2/lib/kpstruct"
+	"github.com/satori/go.uuid"
+)
+
+// deletedUUIDs returns the UUIDs recorded in the deleted objects of db.
+func deletedUUIDs(db *Database) map[uuid.UUID]bool {
+	out := make(map[uuid.UUID]bool)
+	for _, obj := range db.DeletedObjects {
+		out[obj.UUID] = true
+	}
+	return out
+}
+
+func TestDeleteEntry(t *testing.T) {
+	db := New()
+	pe := kpstruct.NewPasswordEntry()
+	db.Root.AddEntry(pe)
+
+	if !db.DeleteEntry(pe) {
+		t.Fatal("DeleteEntry returned false")
+	}
+	bin := db.RecycleBin()
+	if bin == nil {
+		t.Fatal("recycle bin was not created")
+	}
+	if bin.Parent() != db.Root || bin.Name != RecycleBinName || bin.IconID != kpstruct.IconTrashBin ||
+		bin.EnableSearching != kpstruct.TriStateFalse || bin.EnableAutoType != kpstruct.TriStateFalse {
+		t.Errorf("recycle bin %+v", bin)
+	}
+	if pe.ParentGroup() != bin {
+		t.Errorf("entry was not moved to the recycle bin")
+	}
+	if len(db.DeletedObjects) != 0 {
+		t.Errorf("moving to the recycle bin recorded %d deleted objects", len(db.DeletedObjects))
+	}
+
+	// Deleting from the recycle bin is permanent
+	if !db.DeleteEntry(pe) {
+		t.Fatal("DeleteEntry in the recycle bin returned false")
+	}
+	if pe.ParentGroup() != nil || !deletedUUIDs(db)[pe.UUID] {
+		t.Errorf("entry in the recycle bin was not removed permanently")
+	}
+	if db.DeleteEntry(pe) {
+		t.Errorf("DeleteEntry of a removed entry returned true")
+	}
+	if len(db.Root.Groups()) != 1 {
+		t.Errorf("a second recycle bin was created")
+	}
+
+	// Without the recycle bin, entries are removed directly
+	db = New()
+	db.RecycleBinEnabled = false
+	pe = kpstruct.NewPasswordEntry()
+	db.Root.AddEntry(pe)
+	if !db.DeleteEntry(pe) {
+		t.Fatal("DeleteEntry returned false")
+	}
+	if db.RecycleBin() != nil || !uuid.Equal(db.RecycleBinUUID, uuid.Nil) {
+		t.Errorf("recycle bin was created while disabled")
+	}
+	if pe.ParentGroup() != nil || !deletedUUIDs(db)[pe.UUID] {
+		t.Errorf("entry was not removed permanently")
+	}
+}
+
+func TestDeleteGroup(t *testing.T) {
+	db := New()
+	group := kpstruct.NewPasswordGroup("Group")
+	sub := kpstruct.NewPasswordGroup("Sub")
+	pe := kpstruct.NewPasswordEntry()
+	db.Root.AddGroup(group)
+	group.AddGroup(sub)
+	sub.AddEntry(pe)
+
+	if db.DeleteGroup(db.Root) {
+		t.Errorf("DeleteGroup of the root group returned true")
+	}
+	if !db.DeleteGroup(group) {
+		t.Fatal("DeleteGroup returned false")
+	}
+	bin := db.RecycleBin()
+	if bin == nil || group.Parent() != bin || pe.ParentGroup() != sub {
+		t.Fatalf("group was not moved to the recycle bin with its contents")
+	}
+	if len(db.DeletedObjects) != 0 {
+		t.Errorf("moving to the recycle bin recorded %d deleted objects", len(db.DeletedObjects))
+	}
+
+	// A group in the recycle bin is removed with its contents
+	if !db.DeleteGroup(sub) {
+		t.Fatal("DeleteGroup in the recycle bin returned false")
+	}
+	deleted := deletedUUIDs(db)
+	if sub.Parent() != nil || !deleted[sub.UUID] || !deleted[pe.UUID] || len(deleted) != 2 {
+		t.Errorf("deleted objects %v, want the group and its entry", deleted)
+	}
+
+	// Deleting the recycle bin itself is permanent
+	if !db.DeleteGroup(bin) {
+		t.Fatal("DeleteGroup of the recycle bin returned false")
+	}
+	deleted = deletedUUIDs(db)
+	if db.RecycleBin() != nil || !deleted[bin.UUID] || !deleted[group.UUID] {
+		t.Errorf("recycle bin was not removed permanently")
+	}
+
+	// So is deleting a group that contains the recycle bin
+	db = New()
+	outer := kpstruct.NewPasswordGroup("Outer")
+	db.Root.AddGroup(outer)
+	bin = db.ensureRecycleBin()
+	outer.AddGroup(bin)
+	if !db.DeleteGroup(outer) {
+		t.Fatal("DeleteGroup returned false")
+	}
+	deleted = deletedUUIDs(db)
+	if outer.Parent() != nil || !deleted[outer.UUID] || !deleted[bin.UUID] {
+		t.Errorf("group containing the recycle bin was not removed permanently")
+	}
+
+	// Without the recycle bin, groups are removed directly
+	db = New()
+	db.RecycleBinEnabled = false
+	group = kpstruct.NewPasswordGroup("Group")
+	db.Root.AddGroup(group)
+	if !db.DeleteGroup(group) {
+		t.Fatal("DeleteGroup returned false")
+	}
+	if db.RecycleBin() != nil || group.Parent() != nil || !deletedUUIDs(db)[group.UUID] {
+		t.Errorf("group was not removed permanently")
+	}
+}
+
+func TestEmptyRecycleBin(t *testing.T) {
+	db := New()
+	db.EmptyRecycleBin() // no recycle bin yet
+
+	entries := []*kpstruct.PasswordEntry{kpstruct.NewPasswordEntry(), kpstruct.NewPasswordEntry()}
+	group := kpstruct.NewPasswordGroup("Group")
+	inGroup := kpstruct.NewPasswordEntry()
+	group.AddEntry(inGroup)
+	db.Root.AddGroup(group)
+	for _, pe := range entries {
+		db.Root.AddEntry(pe)
+		db.DeleteEntry(pe)
+	}
+	db.DeleteGroup(group)
+	bin := db.RecycleBin()
+
+	db.EmptyRecycleBin()
+	if db.RecycleBin() != bin {
+		t.Errorf("the recycle bin itself was removed")
+	}
+	if len(bin.Entries()) != 0 || len(bin.Groups()) != 0 {
+		t.Errorf("recycle bin still has %d entries and %d groups", len(bin.Entries()), len(bin.Groups()))
+	}
+	deleted := deletedUUIDs(db)
+	for _, id := range []uuid.UUID{entries[0].UUID, entries[1].UUID, group.UUID, inGroup.UUID} {
+		if !deleted[id] {
+			t.Errorf("no deleted object for %v", id)
+		}
+	}
+	if len(db.DeletedObjects) != 4 || deleted[bin.UUID] {
+		t.Errorf("deleted objects %v, want the 4 objects in the recycle bin", deleted)
+	}
+}
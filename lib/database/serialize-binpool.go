@@ -0,0 +1,87 @@
+package database
+
+import (
+	"crypto/sha256"
+
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+	"github.com/riking/go-keepass2/lib/kpstruct"
+)
+
+// binaryPool holds the entry binaries of a database while it is written.
+// Binaries with the same contents and protection are stored once, and
+// entries refer to them by index.
+//
+// File: KeePassLib/Security/ProtectedBinarySet.cs
+type binaryPool struct {
+	binaries []*kpcrypto.ProtectedBinary
+	// byContent maps the SHA-256 of the contents, followed by the
+	// protection flag, to an index into binaries.
+	byContent map[[sha256.Size + 1]byte]int
+	refs      map[*kpcrypto.ProtectedBinary]int
+}
+
+func newBinaryPool() *binaryPool {
+	return &binaryPool{
+		byContent: make(map[[sha256.Size + 1]byte]int),
+		refs:      make(map[*kpcrypto.ProtectedBinary]int),
+	}
+}
+
+// add adds pb to the pool, unless a binary with the same contents is already
+// there, and returns its index.
+//
+// File: KeePassLib/Security/ProtectedBinarySet.cs
+// Add()
+func (p *binaryPool) add(pb *kpcrypto.ProtectedBinary) int {
+	if i, ok := p.refs[pb]; ok {
+		return i
+	}
+
+	var key [sha256.Size + 1]byte
+	h := sha256.New()
+	pb.WriteTo(h)
+	copy(key[:], h.Sum(nil))
+	if pb.IsProtected() {
+		key[sha256.Size] = 1
+	}
+
+	i, ok := p.byContent[key]
+	if !ok {
+		i = len(p.binaries)
+		p.binaries = append(p.binaries, pb)
+		p.byContent[key] = i
+	}
+	p.refs[pb] = i
+	return i
+}
+
+// ref returns the index of pb, which must have been added to the pool.
+func (p *binaryPool) ref(pb *kpcrypto.ProtectedBinary) (int, bool) {
+	i, ok := p.refs[pb]
+	return i, ok
+}
+
+// buildBinPool collects the binaries of all entries below root, including
+// their history.
+//
+// File: KeePassLib/Serialization/KdbxFile.cs
+// BinPoolBuild()
+func buildBinPool(root *kpstruct.PasswordGroup) *binaryPool {
+	pool := newBinaryPool()
+	addEntry := func(pe *kpstruct.PasswordEntry) {
+		for _, name := range pe.Binaries.Keys() {
+			pool.add(pe.Binaries.Get(name))
+		}
+	}
+	if root == nil {
+		return pool
+	}
+	root.TraverseTree(kpstruct.TraversalMethodPreOrder, nil, func(pe *kpstruct.PasswordEntry) kpstruct.TraverseAction {
+		addEntry(pe)
+		for _, h := range pe.History {
+			addEntry(h)
+		}
+		return kpstruct.TraverseContinue
+	})
+	return pool
+}
@@ -1,22 +1,34 @@
 package database
 
-import "github.com/satori/go.uuid"
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+)
 
 // File: KeePassLib/Serialization/KdbxFile.cs
 // constants
 const (
-	KP2Signature1 = 0x9AA2D903
-	KP2Signature2 = 0xB54BFB67
-	KP1Signature1 = 0x9AA2D903
-	KP1Signature2 = 0xB54BFB65
+	KP2Signature1      = 0x9AA2D903
+	KP2Signature2      = 0xB54BFB67
+	KP1Signature1      = 0x9AA2D903
+	KP1Signature2      = 0xB54BFB65
 	KP2AlphaSignature1 = 0x9AA2D903
 	KP2AlphaSignature2 = 0xB54BFB66
 
-// FileVersion is the current kdbx format version.
-// High two bytes are critical, low two bytes are informational.
-// See KeePass source for more info...
-	FileVersion = 0x00030001
+	// FileVersion is the newest kdbx format version that can be read.
+	// High two bytes are critical, low two bytes are informational.
+	// See KeePass source for more info...
+	FileVersion             = FileVersion40
 	FileVersionCriticalMask = 0xFFFF0000
+
+	// FileVersion31 and FileVersion40 are the kdbx format versions that can be
+	// written, see Database.FormatVersion.
+	FileVersion31 = 0x00030001
+	FileVersion40 = 0x00040000
 )
 
 // kdbxHeaderFieldID identifies the type of the header field.
@@ -37,8 +49,29 @@ const (
 	HeaderProtectedStreamKey
 	HeaderStreamStartBytes
 	HeaderInnerRandomStreamID
+	HeaderKdfParameters
+	HeaderPublicCustomData
+)
+
+// innerHeaderFieldID identifies the type of a KDBX 4 inner header field.
+//
+// File: KeePassLib/Serialization/KdbxFile.cs
+// enum KdbxInnerHeaderFieldID
+type innerHeaderFieldID byte
+
+const (
+	InnerHeaderEndOfHeader innerHeaderFieldID = iota
+	InnerHeaderInnerRandomStreamID
+	InnerHeaderInnerRandomStreamKey
+	InnerHeaderBinary
 )
 
+// innerBinaryFlagProtected marks a binary in the inner header as protected.
+//
+// File: KeePassLib/Serialization/KdbxFile.cs
+// KdbxBinaryFlags.Protected
+const innerBinaryFlagProtected = 0x01
+
 // CompressionAlgorithmID identifies the database compression.
 //
 // File: KeePassLib/PwEnums.cs
@@ -61,18 +94,44 @@ const (
 	StreamCipherNone CipherRandomStreamID = iota
 	StreamCipherArcFourVariant
 	StreamCipherSalsa20
+	StreamCipherChaCha20
 	StreamCipherInvalid
 )
 
+// NewRandomStream creates the inner random stream with the given ID.
+//
+// File: KeePassLib/Cryptography/CryptoRandomStream.cs
+// constructor
+func NewRandomStream(id CipherRandomStreamID, key []byte) (kpcrypto.RandomStream, error) {
+	switch id {
+	case StreamCipherNone:
+		return kpcrypto.NullRandomStream{}, nil
+	case StreamCipherSalsa20:
+		if len(key) != 32 {
+			return nil, ErrFileCorrupted
+		}
+		var salsaKey [32]byte
+		copy(salsaKey[:], key)
+		return kpcrypto.NewSalsaRandomStream(&salsaKey), nil
+	case StreamCipherChaCha20:
+		if len(key) == 0 {
+			return nil, ErrFileCorrupted
+		}
+		return kpcrypto.NewChaChaRandomStream(key), nil
+	}
+	return nil, ErrUnknownStream
+}
+
 // CipherUUIDAES is the identifier for standard AES.
+// See kpcrypto.LookupCipher for the other ciphers.
 //
 // file: KeePassLib/Cryptography/Cipher/StandardAesEngine.cs
 // AesUuid
-const (
-	CipherUUIDAES = []byte{0x31, 0xC1, 0xF2, 0xE6, 0xBF, 0x71, 0x43, 0x50, 0xBE, 0x58, 0x05, 0x21, 0x6A, 0xFC, 0x5A, 0xFF}
+var (
+	CipherUUIDAES = kpcrypto.CipherUUIDAes.Bytes()
 )
 
-var CipherUUIDAesParsed = uuid.FromBytesOrNil(CipherUUIDAES)
+var CipherUUIDAesParsed = kpcrypto.CipherUUIDAes
 
 // XML element names.
 //
@@ -80,31 +139,154 @@ var CipherUUIDAesParsed = uuid.FromBytesOrNil(CipherUUIDAES)
 // ElemDocNode and friends
 const (
 	xmlElemDocNode = "KeePassFile"
-	xmlElemMeta = "Meta"
-	xmlElemRoot = "Root"
-	xmlElemGroup = "Group"
-	xmlElemEntry = "Entry"
-
-	xmlElemGenerator = "Generator"
-	xmlElemHeaderHash = "HeaderHash"
-	xmlElemDbName = "DatabaseName"
-	xmlElemDbNameChanged = "DatabaseNameChanged"
-	xmlElemDbDesc = "DatabaseDescription"
-	xmlElemDbDescChanged = "DatabaseDescriptionChanged"
-	xmlElemDbDefaultUser = "DefaultUserName"
-	xmlElemDbDefaultUserChanged = "DefaultUserNameChanged"
-	xmlElemDbMntncHistoryDays = "MaintenanceHistoryDays"
-	xmlElemDbColor = "Color"
-	xmlElemDbKeyChanged = "MasterKeyChanged"
-	xmlElemDbKeyChangeRec = "MasterKeyChangeRec"
-	xmlElemDbKeyChangeForce = "MasterKeyChangeForce"
-	xmlElemRecycleBinEnabled = "RecycleBinEnabled"
-	xmlElemRecycleBinUuid = "RecycleBinUUID"
-	xmlElemRecycleBinChanged = "RecycleBinChanged"
-	xmlElemEntryTemplatesGroup = "EntryTemplatesGroup"
+	xmlElemMeta    = "Meta"
+	xmlElemRoot    = "Root"
+	xmlElemGroup   = "Group"
+	xmlElemEntry   = "Entry"
+
+	xmlElemGenerator                  = "Generator"
+	xmlElemHeaderHash                 = "HeaderHash"
+	xmlElemDbName                     = "DatabaseName"
+	xmlElemDbNameChanged              = "DatabaseNameChanged"
+	xmlElemDbDesc                     = "DatabaseDescription"
+	xmlElemDbDescChanged              = "DatabaseDescriptionChanged"
+	xmlElemDbDefaultUser              = "DefaultUserName"
+	xmlElemDbDefaultUserChanged       = "DefaultUserNameChanged"
+	xmlElemDbMntncHistoryDays         = "MaintenanceHistoryDays"
+	xmlElemDbColor                    = "Color"
+	xmlElemDbKeyChanged               = "MasterKeyChanged"
+	xmlElemDbKeyChangeRec             = "MasterKeyChangeRec"
+	xmlElemDbKeyChangeForce           = "MasterKeyChangeForce"
+	xmlElemRecycleBinEnabled          = "RecycleBinEnabled"
+	xmlElemRecycleBinUuid             = "RecycleBinUUID"
+	xmlElemRecycleBinChanged          = "RecycleBinChanged"
+	xmlElemEntryTemplatesGroup        = "EntryTemplatesGroup"
 	xmlElemEntryTemplatesGroupChanged = "EntryTemplatesGroupChanged"
-	xmlElemHistoryMaxItems = "HistoryMaxItems"
-	xmlElemHistoryMaxSize = "HistoryMaxSize"
-	xmlElemLastSelectedGroup = "LastSelectedGroup"
-	xmlElemLastTopVisibleGroup = "LastTopVisibleGroup"
+	xmlElemHistoryMaxItems            = "HistoryMaxItems"
+	xmlElemHistoryMaxSize             = "HistoryMaxSize"
+	xmlElemLastSelectedGroup          = "LastSelectedGroup"
+	xmlElemLastTopVisibleGroup        = "LastTopVisibleGroup"
+
+	xmlElemMemoryProt   = "MemoryProtection"
+	xmlElemProtTitle    = "ProtectTitle"
+	xmlElemProtUserName = "ProtectUserName"
+	xmlElemProtPassword = "ProtectPassword"
+	xmlElemProtUrl      = "ProtectURL"
+	xmlElemProtNotes    = "ProtectNotes"
+
+	xmlElemCustomIcons        = "CustomIcons"
+	xmlElemCustomIconItem     = "Icon"
+	xmlElemCustomIconItemData = "Data"
+
+	xmlElemBinaries = "Binaries"
+
+	xmlElemCustomData       = "CustomData"
+	xmlElemStringDictExItem = "Item"
+
+	xmlElemUuid                    = "UUID"
+	xmlElemIcon                    = "IconID"
+	xmlElemCustomIconID            = "CustomIconUUID"
+	xmlElemFgColor                 = "ForegroundColor"
+	xmlElemBgColor                 = "BackgroundColor"
+	xmlElemOverrideUrl             = "OverrideURL"
+	xmlElemTags                    = "Tags"
+	xmlElemName                    = "Name"
+	xmlElemNotes                   = "Notes"
+	xmlElemIsExpanded              = "IsExpanded"
+	xmlElemGroupDefaultAutoTypeSeq = "DefaultAutoTypeSequence"
+	xmlElemEnableAutoType          = "EnableAutoType"
+	xmlElemEnableSearching         = "EnableSearching"
+	xmlElemLastTopVisibleEntry     = "LastTopVisibleEntry"
+
+	xmlElemTimes           = "Times"
+	xmlElemCreationTime    = "CreationTime"
+	xmlElemLastModTime     = "LastModificationTime"
+	xmlElemLastAccessTime  = "LastAccessTime"
+	xmlElemExpiryTime      = "ExpiryTime"
+	xmlElemExpires         = "Expires"
+	xmlElemUsageCount      = "UsageCount"
+	xmlElemLocationChanged = "LocationChanged"
+
+	xmlElemString = "String"
+	xmlElemBinary = "Binary"
+	xmlElemKey    = "Key"
+	xmlElemValue  = "Value"
+
+	xmlElemAutoType            = "AutoType"
+	xmlElemAutoTypeEnabled     = "Enabled"
+	xmlElemAutoTypeObfuscation = "DataTransferObfuscation"
+	xmlElemAutoTypeDefaultSeq  = "DefaultSequence"
+	xmlElemAutoTypeItem        = "Association"
+	xmlElemWindow              = "Window"
+	xmlElemKeystrokeSequence   = "KeystrokeSequence"
+
+	xmlElemHistory = "History"
+
+	xmlElemDeletedObjects = "DeletedObjects"
+	xmlElemDeletedObject  = "DeletedObject"
+	xmlElemDeletionTime   = "DeletionTime"
+
+	xmlAttrProtected              = "Protected"
+	xmlAttrProtectedInMemPlainXml = "ProtectInMemory"
+	xmlAttrCompressed             = "Compressed"
+	xmlAttrId                     = "ID"
+	xmlAttrRef                    = "Ref"
+
+	xmlValTrue  = "True"
+	xmlValFalse = "False"
+	xmlValNull  = "null"
 )
+
+// Errors returned when a database cannot be read or written.
+var (
+	ErrOldFormat          = errors.New("keepass: KeePass 1.x databases are not supported")
+	ErrBadSignature       = errors.New("keepass: not a KeePass 2.x database")
+	ErrUnsupportedVersion = errors.New("keepass: the file version is unsupported, a newer version is required")
+	ErrUnknownCipher      = errors.New("keepass: unknown data cipher")
+	ErrUnknownCompression = errors.New("keepass: unknown compression algorithm")
+	ErrUnknownStream      = errors.New("keepass: unknown inner random stream")
+	ErrKdbx4Required      = errors.New("keepass: the database settings require the KDBX 4 format")
+	ErrHeaderEndEarly     = errors.New("keepass: the file header is corrupted")
+	ErrInvalidKey         = errors.New("keepass: the composite key is invalid, or the file is corrupted")
+	ErrFileCorrupted      = errors.New("keepass: the file is corrupted")
+)
+
+// xmlTimeFormat is the format of timestamps in KDBX 3.1 XML documents.
+const xmlTimeFormat = "2006-01-02T15:04:05Z"
+
+// kdbx4TimeUnixOffset is the number of seconds between 0001-01-01 00:00 UTC
+// and the Unix epoch. KDBX 4 XML documents store timestamps as the base64 of
+// a 64-bit count of seconds since 0001-01-01.
+const kdbx4TimeUnixOffset = 62135596800
+
+// computeKeys derives the key for the data cipher and, for KDBX 4 files, the
+// base key for the HMAC block stream.
+//
+// File: KeePassLib/Serialization/KdbxFile.cs
+// ComputeKeys()
+func (db *Database) computeKeys(masterSeed []byte) (cipherKey [32]byte, hmacKey [64]byte, err error) {
+	pbInKey, err := db.MasterKey.GenerateKey32(db.KdfParameters)
+	if err != nil {
+		return
+	}
+	buf := bytes.Buffer{}
+	buf.Write(masterSeed)
+	pbInKey.WriteTo(&buf)
+	pbInKey.Clear()
+
+	cipherKey = sha256.Sum256(buf.Bytes())
+	buf.WriteByte(1)
+	hmacKey = sha512.Sum512(buf.Bytes())
+
+	// Sanitize buffer
+	scratch := buf.Bytes()
+	for i, _ := range scratch {
+		scratch[i] = 0
+	}
+	return
+}
+
+// isKdbx4 reports whether the database is read or written in the KDBX 4 format.
+func (db *Database) isKdbx4() bool {
+	return db.FormatVersion >= FileVersion40
+}
@@ -0,0 +1,786 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+	"github.com/riking/go-keepass2/lib/kpstruct"
+	"github.com/satori/go.uuid"
+)
+
+// xmlReader decodes the XML document of a database.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+type xmlReader struct {
+	db           *Database
+	dec          *xml.Decoder
+	hashOfHeader []byte
+	// randomStream decrypts protected values. It is nil when reading plain XML.
+	randomStream kpcrypto.RandomStream
+	// binPool holds the binaries that entries refer to, by index. It is
+	// read from the KDBX 4 inner header or the Meta element.
+	binPool map[int]*kpcrypto.ProtectedBinary
+	// binaryTimes is set when times may be in the binary form of encrypted
+	// KDBX 4 files.
+	binaryTimes bool
+}
+
+func newXMLReader(db *Database, r io.Reader, hashOfHeader []byte, randomStream kpcrypto.RandomStream) *xmlReader {
+	return &xmlReader{
+		db:           db,
+		dec:          xml.NewDecoder(r),
+		hashOfHeader: hashOfHeader,
+		randomStream: randomStream,
+		binPool:      make(map[int]*kpcrypto.ProtectedBinary),
+	}
+}
+
+// readDocument reads the KeePassFile document element.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadDocumentStreamed()
+func (xr *xmlReader) readDocument() error {
+	for {
+		tok, err := xr.dec.Token()
+		if err == io.EOF {
+			return ErrFileCorrupted
+		} else if err != nil {
+			return err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local != xmlElemDocNode {
+			err = xr.dec.Skip()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		return xr.readChildren(func(start xml.StartElement) error {
+			switch start.Name.Local {
+			case xmlElemMeta:
+				return xr.readMeta()
+			case xmlElemRoot:
+				return xr.readRoot()
+			default:
+				return xr.dec.Skip()
+			}
+		})
+	}
+}
+
+// readMeta reads the Meta element.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadXmlElement(), case KdbContext.Meta
+func (xr *xmlReader) readMeta() error {
+	db := xr.db
+	return xr.readChildren(func(start xml.StartElement) (err error) {
+		switch start.Name.Local {
+		case xmlElemGenerator:
+			return xr.dec.Skip()
+		case xmlElemHeaderHash:
+			hash, err := xr.readBase64(start)
+			if err != nil {
+				return err
+			}
+			if len(hash) > 0 && xr.hashOfHeader != nil && !bytes.Equal(hash, xr.hashOfHeader) {
+				return ErrFileCorrupted
+			}
+		case xmlElemDbName:
+			db.Name, err = xr.readString(start)
+		case xmlElemDbNameChanged:
+			db.NameChanged, err = xr.readTime(start)
+		case xmlElemDbDesc:
+			db.Description, err = xr.readString(start)
+		case xmlElemDbDescChanged:
+			db.DescriptionChanged, err = xr.readTime(start)
+		case xmlElemDbDefaultUser:
+			db.DefaultUserName, err = xr.readString(start)
+		case xmlElemDbDefaultUserChanged:
+			db.DefaultUserNameChanged, err = xr.readTime(start)
+		case xmlElemDbMntncHistoryDays:
+			var days uint64
+			days, err = xr.readUint64(start, 365)
+			db.MaintenanceHistoryDays = uint32(days)
+		case xmlElemDbColor:
+			db.Color, err = xr.readString(start)
+		case xmlElemDbKeyChanged:
+			db.MasterKeyChanged, err = xr.readTime(start)
+		case xmlElemDbKeyChangeRec:
+			db.MasterKeyChangeRec, err = xr.readInt64(start, -1)
+		case xmlElemDbKeyChangeForce:
+			db.MasterKeyChangeForce, err = xr.readInt64(start, -1)
+		case xmlElemMemoryProt:
+			err = xr.readMemoryProtection(&db.MemoryProtection)
+		case xmlElemCustomIcons:
+			db.CustomIcons = nil
+			err = xr.readChildren(func(start xml.StartElement) error {
+				if start.Name.Local != xmlElemCustomIconItem {
+					return xr.dec.Skip()
+				}
+				icon, err := xr.readCustomIcon()
+				if err != nil {
+					return err
+				}
+				db.CustomIcons = append(db.CustomIcons, icon)
+				return nil
+			})
+		case xmlElemRecycleBinEnabled:
+			db.RecycleBinEnabled, err = xr.readBool(start, true)
+		case xmlElemRecycleBinUuid:
+			db.RecycleBinUUID, err = xr.readUUID(start)
+		case xmlElemRecycleBinChanged:
+			db.RecycleBinChanged, err = xr.readTime(start)
+		case xmlElemEntryTemplatesGroup:
+			db.EntryTemplatesGroup, err = xr.readUUID(start)
+		case xmlElemEntryTemplatesGroupChanged:
+			db.EntryTemplatesGroupChanged, err = xr.readTime(start)
+		case xmlElemHistoryMaxItems:
+			var items int64
+			items, err = xr.readInt64(start, -1)
+			db.HistoryMaxItems = int32(items)
+		case xmlElemHistoryMaxSize:
+			db.HistoryMaxSize, err = xr.readInt64(start, -1)
+		case xmlElemLastSelectedGroup:
+			db.LastSelectedGroup, err = xr.readUUID(start)
+		case xmlElemLastTopVisibleGroup:
+			db.LastTopVisibleGroup, err = xr.readUUID(start)
+		case xmlElemBinaries:
+			err = xr.readBinPool()
+		case xmlElemCustomData:
+			db.CustomData = make(map[string]string)
+			err = xr.readCustomData(db.CustomData)
+		default:
+			return xr.dec.Skip()
+		}
+		return err
+	})
+}
+
+// readBinPool reads the Binaries element of the Meta element.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadXmlElement(), case KdbContext.Binaries
+func (xr *xmlReader) readBinPool() error {
+	return xr.readChildren(func(start xml.StartElement) error {
+		if start.Name.Local != xmlElemBinary {
+			return xr.dec.Skip()
+		}
+		id, err := strconv.Atoi(xmlAttrValue(start, xmlAttrId))
+		if err != nil {
+			return ErrFileCorrupted
+		}
+		pb, err := xr.readProtectedBinary(start)
+		if err != nil {
+			return err
+		}
+		xr.binPool[id] = pb
+		return nil
+	})
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadXmlElement(), case KdbContext.MemoryProtection
+func (xr *xmlReader) readMemoryProtection(mp *MemoryProtectionConfig) error {
+	return xr.readChildren(func(start xml.StartElement) (err error) {
+		switch start.Name.Local {
+		case xmlElemProtTitle:
+			mp.ProtectTitle, err = xr.readBool(start, false)
+		case xmlElemProtUserName:
+			mp.ProtectUserName, err = xr.readBool(start, false)
+		case xmlElemProtPassword:
+			mp.ProtectPassword, err = xr.readBool(start, true)
+		case xmlElemProtUrl:
+			mp.ProtectURL, err = xr.readBool(start, false)
+		case xmlElemProtNotes:
+			mp.ProtectNotes, err = xr.readBool(start, false)
+		default:
+			return xr.dec.Skip()
+		}
+		return err
+	})
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadXmlElement(), case KdbContext.CustomIcon
+func (xr *xmlReader) readCustomIcon() (icon CustomIcon, err error) {
+	err = xr.readChildren(func(start xml.StartElement) (err error) {
+		switch start.Name.Local {
+		case xmlElemUuid:
+			icon.UUID, err = xr.readUUID(start)
+		case xmlElemCustomIconItemData:
+			icon.Data, err = xr.readBase64(start)
+		case xmlElemName:
+			icon.Name, err = xr.readString(start)
+		case xmlElemLastModTime:
+			icon.LastModificationTime, err = xr.readTime(start)
+		default:
+			return xr.dec.Skip()
+		}
+		return err
+	})
+	return icon, err
+}
+
+// readCustomData reads the Item elements of a CustomData element into d.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadXmlElement(), case KdbContext.CustomDataItem
+func (xr *xmlReader) readCustomData(d map[string]string) error {
+	return xr.readChildren(func(start xml.StartElement) error {
+		if start.Name.Local != xmlElemStringDictExItem {
+			return xr.dec.Skip()
+		}
+		var key, value string
+		var haveKey bool
+		err := xr.readChildren(func(start xml.StartElement) (err error) {
+			switch start.Name.Local {
+			case xmlElemKey:
+				key, err = xr.readString(start)
+				haveKey = true
+			case xmlElemValue:
+				value, err = xr.readString(start)
+			default:
+				return xr.dec.Skip()
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if haveKey {
+			d[key] = value
+		}
+		return nil
+	})
+}
+
+// readRoot reads the Root element.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadXmlElement(), case KdbContext.Root
+func (xr *xmlReader) readRoot() error {
+	var root *kpstruct.PasswordGroup
+	err := xr.readChildren(func(start xml.StartElement) error {
+		switch start.Name.Local {
+		case xmlElemGroup:
+			if root != nil {
+				return ErrFileCorrupted
+			}
+			root = &kpstruct.PasswordGroup{}
+			return xr.readGroup(root)
+		case xmlElemDeletedObjects:
+			xr.db.DeletedObjects = nil
+			return xr.readChildren(func(start xml.StartElement) error {
+				if start.Name.Local != xmlElemDeletedObject {
+					return xr.dec.Skip()
+				}
+				obj, err := xr.readDeletedObject()
+				if err != nil {
+					return err
+				}
+				xr.db.DeletedObjects = append(xr.db.DeletedObjects, obj)
+				return nil
+			})
+		default:
+			return xr.dec.Skip()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if root != nil {
+		xr.db.Root = root
+	}
+	return nil
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadXmlElement(), case KdbContext.DeletedObject
+func (xr *xmlReader) readDeletedObject() (obj kpstruct.DeletedObject, err error) {
+	err = xr.readChildren(func(start xml.StartElement) (err error) {
+		switch start.Name.Local {
+		case xmlElemUuid:
+			obj.UUID, err = xr.readUUID(start)
+		case xmlElemDeletionTime:
+			obj.DeletionTime, err = xr.readTime(start)
+		default:
+			return xr.dec.Skip()
+		}
+		return err
+	})
+	return obj, err
+}
+
+// readGroup reads the contents of a Group element into pg.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadXmlElement(), case KdbContext.Group
+func (xr *xmlReader) readGroup(pg *kpstruct.PasswordGroup) error {
+	return xr.readChildren(func(start xml.StartElement) (err error) {
+		switch start.Name.Local {
+		case xmlElemUuid:
+			pg.UUID, err = xr.readUUID(start)
+		case xmlElemName:
+			pg.Name, err = xr.readString(start)
+		case xmlElemNotes:
+			pg.Notes, err = xr.readString(start)
+		case xmlElemIcon:
+			var icon uint64
+			icon, err = xr.readUint64(start, uint64(kpstruct.IconFolder))
+			pg.IconID = kpstruct.IconID(icon)
+		case xmlElemCustomIconID:
+			pg.CustomIconUUID, err = xr.readUUID(start)
+		case xmlElemTimes:
+			err = xr.readTimes(&pg.Times)
+		case xmlElemIsExpanded:
+			pg.IsExpanded, err = xr.readBool(start, true)
+		case xmlElemGroupDefaultAutoTypeSeq:
+			pg.DefaultAutoTypeSequence, err = xr.readString(start)
+		case xmlElemEnableAutoType:
+			pg.EnableAutoType, err = xr.readTriState(start)
+		case xmlElemEnableSearching:
+			pg.EnableSearching, err = xr.readTriState(start)
+		case xmlElemLastTopVisibleEntry:
+			pg.LastTopVisibleEntry, err = xr.readUUID(start)
+		case xmlElemEntry:
+			pe := &kpstruct.PasswordEntry{}
+			pg.AddEntry(pe)
+			err = xr.readEntry(pe, false)
+		case xmlElemGroup:
+			child := &kpstruct.PasswordGroup{}
+			err = pg.AddGroup(child)
+			if err == nil {
+				err = xr.readGroup(child)
+			}
+		default:
+			return xr.dec.Skip()
+		}
+		return err
+	})
+}
+
+// readEntry reads the contents of an Entry element into pe. History
+// entries are read into pe.History, unless isHistory is set.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadXmlElement(), case KdbContext.Entry
+func (xr *xmlReader) readEntry(pe *kpstruct.PasswordEntry, isHistory bool) error {
+	return xr.readChildren(func(start xml.StartElement) (err error) {
+		switch start.Name.Local {
+		case xmlElemUuid:
+			pe.UUID, err = xr.readUUID(start)
+		case xmlElemIcon:
+			var icon uint64
+			icon, err = xr.readUint64(start, uint64(kpstruct.IconKey))
+			pe.IconID = kpstruct.IconID(icon)
+		case xmlElemCustomIconID:
+			pe.CustomIconUUID, err = xr.readUUID(start)
+		case xmlElemFgColor:
+			pe.ForegroundColor, err = xr.readString(start)
+		case xmlElemBgColor:
+			pe.BackgroundColor, err = xr.readString(start)
+		case xmlElemOverrideUrl:
+			pe.OverrideURL, err = xr.readString(start)
+		case xmlElemTags:
+			var tags string
+			tags, err = xr.readString(start)
+			pe.SetTagsString(tags)
+		case xmlElemTimes:
+			err = xr.readTimes(&pe.Times)
+		case xmlElemString:
+			name, value, err := xr.readProtectedString()
+			if err != nil {
+				return err
+			}
+			if !value.IsProtected() && xr.db.MemoryProtection.GetProtection(name) {
+				value = value.WithProtection(true)
+			}
+			pe.Strings.Set(name, value)
+		case xmlElemBinary:
+			name, value, err := xr.readBinary()
+			if err != nil {
+				return err
+			}
+			pe.Binaries.Set(name, value)
+		case xmlElemAutoType:
+			err = xr.readAutoType(&pe.AutoType)
+		case xmlElemHistory:
+			if isHistory {
+				return ErrFileCorrupted
+			}
+			err = xr.readChildren(func(start xml.StartElement) error {
+				if start.Name.Local != xmlElemEntry {
+					return xr.dec.Skip()
+				}
+				h := &kpstruct.PasswordEntry{}
+				pe.History = append(pe.History, h)
+				return xr.readEntry(h, true)
+			})
+		default:
+			return xr.dec.Skip()
+		}
+		return err
+	})
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadXmlElement(), case KdbContext.GroupTimes / KdbContext.EntryTimes
+func (xr *xmlReader) readTimes(t *kpstruct.Times) error {
+	return xr.readChildren(func(start xml.StartElement) (err error) {
+		switch start.Name.Local {
+		case xmlElemCreationTime:
+			t.CreationTime, err = xr.readTime(start)
+		case xmlElemLastModTime:
+			t.LastModificationTime, err = xr.readTime(start)
+		case xmlElemLastAccessTime:
+			t.LastAccessTime, err = xr.readTime(start)
+		case xmlElemExpiryTime:
+			t.ExpiryTime, err = xr.readTime(start)
+		case xmlElemExpires:
+			t.Expires, err = xr.readBool(start, false)
+		case xmlElemUsageCount:
+			t.UsageCount, err = xr.readUint64(start, 0)
+		case xmlElemLocationChanged:
+			t.LocationChanged, err = xr.readTime(start)
+		default:
+			return xr.dec.Skip()
+		}
+		return err
+	})
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadXmlElement(), case KdbContext.EntryAutoType
+func (xr *xmlReader) readAutoType(c *kpstruct.AutoTypeConfig) error {
+	return xr.readChildren(func(start xml.StartElement) (err error) {
+		switch start.Name.Local {
+		case xmlElemAutoTypeEnabled:
+			c.Enabled, err = xr.readBool(start, true)
+		case xmlElemAutoTypeObfuscation:
+			var obf uint64
+			obf, err = xr.readUint64(start, 0)
+			c.Obfuscation = kpstruct.AutoTypeObfuscation(obf)
+		case xmlElemAutoTypeDefaultSeq:
+			c.DefaultSequence, err = xr.readString(start)
+		case xmlElemAutoTypeItem:
+			var a kpstruct.AutoTypeAssociation
+			err = xr.readChildren(func(start xml.StartElement) (err error) {
+				switch start.Name.Local {
+				case xmlElemWindow:
+					a.Window, err = xr.readString(start)
+				case xmlElemKeystrokeSequence:
+					a.Sequence, err = xr.readString(start)
+				default:
+					return xr.dec.Skip()
+				}
+				return err
+			})
+			c.Associations = append(c.Associations, a)
+		default:
+			return xr.dec.Skip()
+		}
+		return err
+	})
+}
+
+// readProtectedString reads the contents of a String element.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadXmlElement(), case KdbContext.EntryString; ReadProtectedString()
+func (xr *xmlReader) readProtectedString() (name string, value *kpcrypto.ProtectedString, err error) {
+	err = xr.readChildren(func(start xml.StartElement) (err error) {
+		switch start.Name.Local {
+		case xmlElemKey:
+			name, err = xr.readString(start)
+		case xmlElemValue:
+			value, err = xr.readProtectedValue(start)
+		default:
+			return xr.dec.Skip()
+		}
+		return err
+	})
+	if value == nil {
+		value = kpcrypto.NewProtectedString(false, "")
+	}
+	return name, value, err
+}
+
+// readProtectedValue reads a value that may be encrypted with the inner
+// random stream.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadProtectedString()
+func (xr *xmlReader) readProtectedValue(start xml.StartElement) (*kpcrypto.ProtectedString, error) {
+	if xmlAttrValue(start, xmlAttrProtected) == xmlValTrue {
+		data, err := xr.readBase64(start)
+		if err != nil {
+			return nil, err
+		}
+		if xr.randomStream != nil {
+			xr.randomStream.Cipher(data, true)
+		}
+		value := kpcrypto.NewProtectedStringBytes(true, data)
+		for i := range data {
+			data[i] = 0
+		}
+		return value, nil
+	}
+
+	s, err := xr.readString(start)
+	if err != nil {
+		return nil, err
+	}
+	protected := xmlAttrValue(start, xmlAttrProtectedInMemPlainXml) == xmlValTrue
+	return kpcrypto.NewProtectedString(protected, s), nil
+}
+
+// readBinary reads the contents of a Binary element of an entry.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadXmlElement(), case KdbContext.EntryBinary
+func (xr *xmlReader) readBinary() (name string, value *kpcrypto.ProtectedBinary, err error) {
+	err = xr.readChildren(func(start xml.StartElement) (err error) {
+		switch start.Name.Local {
+		case xmlElemKey:
+			name, err = xr.readString(start)
+		case xmlElemValue:
+			value, err = xr.readProtectedBinary(start)
+		default:
+			return xr.dec.Skip()
+		}
+		return err
+	})
+	if value == nil {
+		value = kpcrypto.NewProtectedBinary(false, nil)
+	}
+	return name, value, err
+}
+
+// readProtectedBinary reads binary data that refers to the binary pool, or is
+// stored inline and may be encrypted with the inner random stream or
+// compressed.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadProtectedBinary()
+func (xr *xmlReader) readProtectedBinary(start xml.StartElement) (*kpcrypto.ProtectedBinary, error) {
+	if ref := xmlAttrValue(start, xmlAttrRef); ref != "" {
+		err := xr.dec.Skip()
+		if err != nil {
+			return nil, err
+		}
+		id, err := strconv.Atoi(ref)
+		if err != nil {
+			return nil, ErrFileCorrupted
+		}
+		pb, ok := xr.binPool[id]
+		if !ok {
+			return nil, ErrFileCorrupted
+		}
+		return pb, nil
+	}
+
+	data, err := xr.readBase64(start)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for i := range data {
+			data[i] = 0
+		}
+	}()
+
+	if xmlAttrValue(start, xmlAttrProtected) == xmlValTrue {
+		if xr.randomStream != nil {
+			xr.randomStream.Cipher(data, true)
+		}
+		return kpcrypto.NewProtectedBinary(true, data), nil
+	}
+
+	if xmlAttrValue(start, xmlAttrCompressed) == xmlValTrue {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrFileCorrupted
+		}
+		plain, err := ioutil.ReadAll(gz)
+		if err != nil {
+			return nil, ErrFileCorrupted
+		}
+		for i := range data {
+			data[i] = 0
+		}
+		data = plain
+	}
+	protected := xmlAttrValue(start, xmlAttrProtectedInMemPlainXml) == xmlValTrue
+	return kpcrypto.NewProtectedBinary(protected, data), nil
+}
+
+// xmlAttrValue returns the value of the named attribute, or "".
+func xmlAttrValue(start xml.StartElement, name string) string {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// readChildren calls fn for each child element of the current element, until
+// the end of the current element is reached. fn must consume the entire child
+// element.
+func (xr *xmlReader) readChildren(fn func(start xml.StartElement) error) error {
+	for {
+		tok, err := xr.dec.Token()
+		if err == io.EOF {
+			return ErrFileCorrupted
+		} else if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			err = fn(t)
+			if err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadString()
+func (xr *xmlReader) readString(start xml.StartElement) (string, error) {
+	var s string
+	err := xr.dec.DecodeElement(&s, &start)
+	return s, err
+}
+
+func (xr *xmlReader) readBase64(start xml.StartElement) ([]byte, error) {
+	s, err := xr.readString(start)
+	if err != nil {
+		return nil, err
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrFileCorrupted
+	}
+	return b, nil
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadBool()
+func (xr *xmlReader) readBool(start xml.StartElement, def bool) (bool, error) {
+	s, err := xr.readString(start)
+	if err != nil {
+		return def, err
+	}
+	switch strings.TrimSpace(s) {
+	case xmlValTrue, "true":
+		return true, nil
+	case xmlValFalse, "false":
+		return false, nil
+	default:
+		return def, nil
+	}
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadBool(), for bool?
+func (xr *xmlReader) readTriState(start xml.StartElement) (kpstruct.TriState, error) {
+	s, err := xr.readString(start)
+	if err != nil {
+		return kpstruct.TriStateInherit, err
+	}
+	switch strings.TrimSpace(s) {
+	case xmlValTrue, "true":
+		return kpstruct.TriStateTrue, nil
+	case xmlValFalse, "false":
+		return kpstruct.TriStateFalse, nil
+	default:
+		return kpstruct.TriStateInherit, nil
+	}
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadLong()
+func (xr *xmlReader) readInt64(start xml.StartElement, def int64) (int64, error) {
+	s, err := xr.readString(start)
+	if err != nil {
+		return def, err
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return def, nil
+	}
+	return n, nil
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadULong()
+func (xr *xmlReader) readUint64(start xml.StartElement, def uint64) (uint64, error) {
+	s, err := xr.readString(start)
+	if err != nil {
+		return def, err
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return def, nil
+	}
+	return n, nil
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadUuid()
+func (xr *xmlReader) readUUID(start xml.StartElement) (uuid.UUID, error) {
+	b, err := xr.readBase64(start)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if len(b) == 0 {
+		return uuid.Nil, nil
+	}
+	u, err := uuid.FromBytes(b)
+	if err != nil {
+		return uuid.Nil, ErrFileCorrupted
+	}
+	return u, nil
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Read.Streamed.cs
+// ReadTime()
+func (xr *xmlReader) readTime(start xml.StartElement) (time.Time, error) {
+	s, err := xr.readString(start)
+	if err != nil {
+		return time.Time{}, err
+	}
+	s = strings.TrimSpace(s)
+	if xr.binaryTimes {
+		// KDBX 4 stores the number of seconds since 0001-01-01 00:00 UTC
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err == nil && len(b) == 8 {
+			seconds := int64(binary.LittleEndian.Uint64(b))
+			return time.Unix(seconds-kdbx4TimeUnixOffset, 0).UTC(), nil
+		}
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, ErrFileCorrupted
+	}
+	return t, nil
+}
@@ -0,0 +1,420 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/riking/go-keepass2/lib"
+	"github.com/riking/go-keepass2/lib/keys"
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+	"github.com/satori/go.uuid"
+)
+
+// kdbxHeader holds the values read from the file header that are only needed
+// while the rest of the file is being read.
+//
+// File: KeePassLib/Serialization/KdbxFile.cs
+// m_pbMasterSeed and friends
+type kdbxHeader struct {
+	masterSeed         []byte
+	encryptionIV       []byte
+	protectedStreamKey []byte
+	streamStartBytes   []byte
+
+	headerBytes  []byte
+	hashOfHeader [sha256.Size]byte
+
+	// binaries is the binary pool read from the KDBX 4 inner header.
+	binaries []*kpcrypto.ProtectedBinary
+}
+
+// Open reads an encrypted database from the given reader stream, using key to
+// decrypt it.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.cs
+// Load()
+func Open(r io.Reader, key *keys.Composite) (*Database, error) {
+	db := New()
+	db.MasterKey = *key
+	err := db.ReadIn(r, WriteFormatEncrypted)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// ReadIn reads the database from the given reader stream. When format is
+// WriteFormatEncrypted, db.MasterKey must already be set.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.cs
+// Load()
+func (db *Database) ReadIn(r io.Reader, format WriteFormat) error {
+	var readerStream io.Reader
+	var hashOfHeader []byte
+	var randomStream kpcrypto.RandomStream
+	var binaries []*kpcrypto.ProtectedBinary
+
+	if format == WriteFormatEncrypted {
+		header, err := db.readHeader(r)
+		if err != nil {
+			return err
+		}
+		isKdbx4 := db.isKdbx4()
+		if !isKdbx4 {
+			hashOfHeader = header.hashOfHeader[:]
+		}
+
+		if len(header.masterSeed) != 32 {
+			return ErrFileCorrupted
+		}
+
+		cipherKey, hmacKey, err := db.computeKeys(header.masterSeed)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			for i, _ := range hmacKey {
+				hmacKey[i] = 0
+			}
+		}()
+
+		var cipherStream io.Reader = r
+		if isKdbx4 {
+			var stored [sha256.Size * 2]byte
+			_, err = io.ReadFull(r, stored[:])
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return ErrHeaderEndEarly
+			} else if err != nil {
+				return err
+			}
+			if !bytes.Equal(stored[0:sha256.Size], header.hashOfHeader[:]) {
+				return ErrFileCorrupted
+			}
+			mac := hmac.New(sha256.New, lib.HMACBlockKey(hmacKey[:], math.MaxUint64))
+			mac.Write(header.headerBytes)
+			if !hmac.Equal(stored[sha256.Size:], mac.Sum(nil)) {
+				return ErrInvalidKey
+			}
+
+			cipherStream = lib.NewHMACBlockReader(r, hmacKey[:])
+		}
+
+		decrypted, err := db.attachStreamDecryptor(cipherStream, header, &cipherKey)
+		if err != nil {
+			return err
+		}
+		readerStream = decrypted
+
+		if !isKdbx4 {
+			if len(header.streamStartBytes) != 32 {
+				return ErrFileCorrupted
+			}
+			var storedStartBytes [32]byte
+			_, err = io.ReadFull(decrypted, storedStartBytes[:])
+			if err == io.EOF || err == io.ErrUnexpectedEOF || err == kpcrypto.ErrBadPadding {
+				return ErrInvalidKey
+			} else if err != nil {
+				return err
+			}
+			if !bytes.Equal(storedStartBytes[:], header.streamStartBytes) {
+				return ErrInvalidKey
+			}
+
+			readerStream = lib.NewHashedBlockReader(decrypted)
+		}
+
+		if db.Compression == CompressionGzip {
+			gzipStream, err := gzip.NewReader(readerStream)
+			if err != nil {
+				return err
+			}
+			defer gzipStream.Close()
+			readerStream = gzipStream
+		}
+
+		if isKdbx4 {
+			err = db.readInnerHeader(readerStream, header)
+			if err != nil {
+				return err
+			}
+			binaries = header.binaries
+		}
+
+		randomStream, err = NewRandomStream(db.InnerRandomStream, header.protectedStreamKey)
+		if err != nil {
+			return err
+		}
+	} else if format == WriteFormatPlain {
+		readerStream = r
+	} else {
+		panic("bad WriteFormat in keepass.Database.ReadIn")
+	}
+
+	xr := newXMLReader(db, readerStream, hashOfHeader, randomStream)
+	xr.binaryTimes = db.isKdbx4() && format == WriteFormatEncrypted
+	for i, pb := range binaries {
+		xr.binPool[i] = pb
+	}
+	return xr.readDocument()
+}
+
+// readHeader reads the unencrypted file header.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.cs
+// ReadHeader()
+func (db *Database) readHeader(r io.Reader) (*kdbxHeader, error) {
+	header := new(kdbxHeader)
+	headerBuf := bytes.Buffer{}
+	r = io.TeeReader(r, &headerBuf)
+
+	var scratch [12]byte
+	_, err := io.ReadFull(r, scratch[0:12])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ErrBadSignature
+	} else if err != nil {
+		return nil, err
+	}
+
+	sig1 := binary.LittleEndian.Uint32(scratch[0:4])
+	sig2 := binary.LittleEndian.Uint32(scratch[4:8])
+	if sig1 == KP1Signature1 && sig2 == KP1Signature2 {
+		return nil, ErrOldFormat
+	}
+	if sig1 == KP2Signature1 && sig2 == KP2Signature2 {
+	} else if sig1 == KP2AlphaSignature1 && sig2 == KP2AlphaSignature2 {
+	} else {
+		return nil, ErrBadSignature
+	}
+
+	version := binary.LittleEndian.Uint32(scratch[8:12])
+	if (version & FileVersionCriticalMask) > (FileVersion & FileVersionCriticalMask) {
+		return nil, ErrUnsupportedVersion
+	}
+	if (version & FileVersionCriticalMask) >= (FileVersion40 & FileVersionCriticalMask) {
+		db.FormatVersion = FileVersion40
+	} else {
+		db.FormatVersion = FileVersion31
+	}
+
+	for {
+		more, err := db.readHeaderField(r, header)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+	}
+
+	header.headerBytes = headerBuf.Bytes()
+	header.hashOfHeader = sha256.Sum256(header.headerBytes)
+	return header, nil
+}
+
+// readHeaderField reads one header field. It returns false after reading the
+// end of header marker.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.cs
+// ReadHeaderField()
+func (db *Database) readHeaderField(r io.Reader, header *kdbxHeader) (bool, error) {
+	var head [5]byte
+	var size uint32
+	headLen := 3
+	if db.isKdbx4() {
+		headLen = 5
+	}
+	_, err := io.ReadFull(r, head[:headLen])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, ErrHeaderEndEarly
+	} else if err != nil {
+		return false, err
+	}
+	if db.isKdbx4() {
+		size = binary.LittleEndian.Uint32(head[1:5])
+	} else {
+		size = uint32(binary.LittleEndian.Uint16(head[1:3]))
+	}
+	if size > math.MaxInt32 {
+		return false, ErrHeaderEndEarly
+	}
+
+	id := kdbxHeaderFieldID(head[0])
+	// Copy through a buffer so that a corrupt size cannot make us allocate
+	// more memory than the file actually contains
+	dataBuf := bytes.Buffer{}
+	_, err = io.CopyN(&dataBuf, r, int64(size))
+	data := dataBuf.Bytes()
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, ErrHeaderEndEarly
+	} else if err != nil {
+		return false, err
+	}
+
+	switch id {
+	case HeaderEndOfHeader:
+		return false, nil
+	case HeaderCipherID:
+		if len(data) != 16 {
+			return false, ErrUnknownCipher
+		}
+		db.CipherID = uuid.FromBytesOrNil(data)
+	case HeaderCompressionFlags:
+		if len(data) < 4 {
+			return false, ErrHeaderEndEarly
+		}
+		id := CompressionAlgorithmID(binary.LittleEndian.Uint32(data))
+		if id >= CompressionInvalid {
+			return false, ErrUnknownCompression
+		}
+		db.Compression = id
+	case HeaderMasterSeed:
+		header.masterSeed = data
+	case HeaderTransformSeed:
+		db.aesKdfParameters().Set(keys.AesKdfParamSeed, data)
+	case HeaderTransformRounds:
+		if len(data) < 8 {
+			return false, ErrHeaderEndEarly
+		}
+		db.aesKdfParameters().Set(keys.AesKdfParamRounds, binary.LittleEndian.Uint64(data))
+	case HeaderEncryptionIV:
+		header.encryptionIV = data
+	case HeaderProtectedStreamKey:
+		header.protectedStreamKey = data
+	case HeaderStreamStartBytes:
+		header.streamStartBytes = data
+	case HeaderInnerRandomStreamID:
+		if len(data) < 4 {
+			return false, ErrHeaderEndEarly
+		}
+		id := CipherRandomStreamID(binary.LittleEndian.Uint32(data))
+		if id >= StreamCipherInvalid {
+			return false, ErrUnknownStream
+		}
+		db.InnerRandomStream = id
+	case HeaderKdfParameters:
+		kdfParams := lib.NewVariantDictionary()
+		err = kdfParams.UnmarshalBinary(data)
+		if err != nil {
+			return false, err
+		}
+		db.KdfParameters = kdfParams
+	case HeaderPublicCustomData:
+		db.PublicCustomData = lib.NewVariantDictionary()
+		err = db.PublicCustomData.UnmarshalBinary(data)
+		if err != nil {
+			return false, err
+		}
+	default:
+		// Unknown header fields are ignored, like KeePass does.
+	}
+	return true, nil
+}
+
+// aesKdfParameters returns db.KdfParameters, first replacing them with the
+// AES-KDF defaults if they are for another KDF. KDBX 3.1 files store the
+// AES-KDF parameters as separate header fields.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.cs
+// ReadHeaderField(), case KdbxHeaderFieldID.TransformSeed
+func (db *Database) aesKdfParameters() *lib.VariantDictionary {
+	if keys.KdfUUID(db.KdfParameters) != keys.KdfUUIDAes {
+		db.KdfParameters = keys.AesKDF{}.DefaultParameters()
+	}
+	return db.KdfParameters
+}
+
+// readInnerHeader reads the KDBX 4 inner header at the start of the
+// decrypted stream.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.cs
+// ReadInnerHeader(), ReadInnerHeaderField()
+func (db *Database) readInnerHeader(r io.Reader, header *kdbxHeader) error {
+	for {
+		var head [5]byte
+		_, err := io.ReadFull(r, head[:])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrFileCorrupted
+		} else if err != nil {
+			return err
+		}
+		size := binary.LittleEndian.Uint32(head[1:5])
+		if size > math.MaxInt32 {
+			return ErrFileCorrupted
+		}
+
+		if innerHeaderFieldID(head[0]) == InnerHeaderBinary {
+			// Binaries are streamed into protected memory, as they can be large
+			if size < 1 {
+				return ErrFileCorrupted
+			}
+			var flags [1]byte
+			_, err = io.ReadFull(r, flags[:])
+			if err != nil {
+				return ErrFileCorrupted
+			}
+			lr := &io.LimitedReader{R: r, N: int64(size) - 1}
+			protected := flags[0]&innerBinaryFlagProtected != 0
+			pb, err := kpcrypto.ReadProtectedBinary(protected, lr)
+			if err != nil {
+				return err
+			}
+			if lr.N != 0 {
+				return ErrFileCorrupted
+			}
+			header.binaries = append(header.binaries, pb)
+			continue
+		}
+
+		dataBuf := bytes.Buffer{}
+		_, err = io.CopyN(&dataBuf, r, int64(size))
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrFileCorrupted
+		} else if err != nil {
+			return err
+		}
+		data := dataBuf.Bytes()
+
+		switch innerHeaderFieldID(head[0]) {
+		case InnerHeaderEndOfHeader:
+			return nil
+		case InnerHeaderInnerRandomStreamID:
+			if len(data) < 4 {
+				return ErrFileCorrupted
+			}
+			id := CipherRandomStreamID(binary.LittleEndian.Uint32(data))
+			if id >= StreamCipherInvalid {
+				return ErrUnknownStream
+			}
+			db.InnerRandomStream = id
+		case InnerHeaderInnerRandomStreamKey:
+			header.protectedStreamKey = data
+		default:
+			// Unknown inner header fields are ignored.
+		}
+	}
+}
+
+// attachStreamDecryptor sets up decryption of the rest of the file.
+//
+// File: KeePassLib/Serialization/KdbxFile.Read.cs
+// AttachStreamDecryptor()
+func (db *Database) attachStreamDecryptor(r io.Reader, header *kdbxHeader, cipherKey *[32]byte) (io.Reader, error) {
+	cipherEngine := kpcrypto.LookupCipher(db.CipherID)
+	if cipherEngine == nil {
+		return nil, ErrUnknownCipher
+	}
+	if len(header.encryptionIV) != cipherEngine.IVLength() {
+		return nil, ErrFileCorrupted
+	}
+
+	decrypted, err := cipherEngine.DecryptStream(r, cipherKey[:], header.encryptionIV)
+	for i, _ := range cipherKey {
+		cipherKey[i] = 0
+	}
+	return decrypted, err
+}
@@ -0,0 +1,461 @@
+package database
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+	"github.com/riking/go-keepass2/lib/kpstruct"
+	"github.com/satori/go.uuid"
+)
+
+// xmlWriter encodes the XML document of a database.
+//
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+type xmlWriter struct {
+	db  *Database
+	enc *xml.Encoder
+	// randomStream encrypts protected values. It is nil when writing plain
+	// XML, in which case protected values are written in plain text.
+	randomStream kpcrypto.RandomStream
+	// binPool holds the entry binaries, which entries refer to by index.
+	binPool *binaryPool
+	// binPoolInMeta is set when the binary pool is written in the Meta
+	// element, rather than the KDBX 4 inner header.
+	binPoolInMeta bool
+	// binaryTimes is set when times are written in the binary form of
+	// encrypted KDBX 4 files, rather than as text.
+	binaryTimes bool
+	// err is the first error from writing the contents of a binary. Errors
+	// from writing the other elements are returned when enc is flushed.
+	err error
+}
+
+func newXMLWriter(db *Database, w io.Writer, randomStream kpcrypto.RandomStream, binPool *binaryPool) *xmlWriter {
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	return &xmlWriter{
+		db:           db,
+		enc:          enc,
+		randomStream: randomStream,
+		binPool:      binPool,
+	}
+}
+
+// writeMeta writes the Meta element. hashOfHeader is written for KDBX 3.1
+// files, and is nil otherwise.
+//
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+// WriteMeta()
+func (xw *xmlWriter) writeMeta(hashOfHeader []byte) {
+	db := xw.db
+	xw.enc.EncodeToken(startElem(xmlElemMeta))
+
+	xw.writeString(xmlElemGenerator, ProductName)
+	if hashOfHeader != nil {
+		xw.writeString(xmlElemHeaderHash, base64.StdEncoding.EncodeToString(hashOfHeader))
+	}
+
+	xw.writeString(xmlElemDbName, db.Name)
+	xw.writeTime(xmlElemDbNameChanged, db.NameChanged)
+	xw.writeString(xmlElemDbDesc, db.Description)
+	xw.writeTime(xmlElemDbDescChanged, db.DescriptionChanged)
+	xw.writeString(xmlElemDbDefaultUser, db.DefaultUserName)
+	xw.writeTime(xmlElemDbDefaultUserChanged, db.DefaultUserNameChanged)
+	xw.writeUint64(xmlElemDbMntncHistoryDays, uint64(db.MaintenanceHistoryDays))
+	xw.writeString(xmlElemDbColor, db.Color)
+	xw.writeTime(xmlElemDbKeyChanged, db.MasterKeyChanged)
+	xw.writeInt64(xmlElemDbKeyChangeRec, db.MasterKeyChangeRec)
+	xw.writeInt64(xmlElemDbKeyChangeForce, db.MasterKeyChangeForce)
+
+	xw.writeMemoryProtection(&db.MemoryProtection)
+	xw.writeCustomIcons(db.CustomIcons)
+
+	xw.writeBool(xmlElemRecycleBinEnabled, db.RecycleBinEnabled)
+	xw.writeUUID(xmlElemRecycleBinUuid, db.RecycleBinUUID)
+	xw.writeTime(xmlElemRecycleBinChanged, db.RecycleBinChanged)
+	xw.writeUUID(xmlElemEntryTemplatesGroup, db.EntryTemplatesGroup)
+	xw.writeTime(xmlElemEntryTemplatesGroupChanged, db.EntryTemplatesGroupChanged)
+	xw.writeInt64(xmlElemHistoryMaxItems, int64(db.HistoryMaxItems))
+	xw.writeInt64(xmlElemHistoryMaxSize, db.HistoryMaxSize)
+	xw.writeUUID(xmlElemLastSelectedGroup, db.LastSelectedGroup)
+	xw.writeUUID(xmlElemLastTopVisibleGroup, db.LastTopVisibleGroup)
+
+	if xw.binPoolInMeta {
+		xw.writeBinPool()
+	}
+	xw.writeCustomData(db.CustomData)
+
+	xw.enc.EncodeToken(endElem(xmlElemMeta))
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+// WriteList(MemoryProtectionConfig)
+func (xw *xmlWriter) writeMemoryProtection(mp *MemoryProtectionConfig) {
+	xw.enc.EncodeToken(startElem(xmlElemMemoryProt))
+	xw.writeBool(xmlElemProtTitle, mp.ProtectTitle)
+	xw.writeBool(xmlElemProtUserName, mp.ProtectUserName)
+	xw.writeBool(xmlElemProtPassword, mp.ProtectPassword)
+	xw.writeBool(xmlElemProtUrl, mp.ProtectURL)
+	xw.writeBool(xmlElemProtNotes, mp.ProtectNotes)
+	xw.enc.EncodeToken(endElem(xmlElemMemoryProt))
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+// WriteCustomIconList()
+func (xw *xmlWriter) writeCustomIcons(icons []CustomIcon) {
+	if len(icons) == 0 {
+		return
+	}
+	xw.enc.EncodeToken(startElem(xmlElemCustomIcons))
+	for _, icon := range icons {
+		xw.enc.EncodeToken(startElem(xmlElemCustomIconItem))
+		xw.writeUUID(xmlElemUuid, icon.UUID)
+		xw.writeString(xmlElemCustomIconItemData, base64.StdEncoding.EncodeToString(icon.Data))
+		if xw.db.isKdbx4() {
+			if icon.Name != "" {
+				xw.writeString(xmlElemName, icon.Name)
+			}
+			if !icon.LastModificationTime.IsZero() {
+				xw.writeTime(xmlElemLastModTime, icon.LastModificationTime)
+			}
+		}
+		xw.enc.EncodeToken(endElem(xmlElemCustomIconItem))
+	}
+	xw.enc.EncodeToken(endElem(xmlElemCustomIcons))
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+// WriteList(StringDictionaryEx)
+func (xw *xmlWriter) writeCustomData(d map[string]string) {
+	if len(d) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(d))
+	for k := range d {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	xw.enc.EncodeToken(startElem(xmlElemCustomData))
+	for _, k := range keys {
+		xw.enc.EncodeToken(startElem(xmlElemStringDictExItem))
+		xw.writeString(xmlElemKey, k)
+		xw.writeString(xmlElemValue, d[k])
+		xw.enc.EncodeToken(endElem(xmlElemStringDictExItem))
+	}
+	xw.enc.EncodeToken(endElem(xmlElemCustomData))
+}
+
+// writeGroup writes a Group element, with the entries and subgroups of pg.
+//
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+// WriteGroup(), EndGroup()
+func (xw *xmlWriter) writeGroup(pg *kpstruct.PasswordGroup) {
+	xw.enc.EncodeToken(startElem(xmlElemGroup))
+
+	xw.writeUUID(xmlElemUuid, pg.UUID)
+	xw.writeString(xmlElemName, pg.Name)
+	xw.writeString(xmlElemNotes, pg.Notes)
+	xw.writeUint64(xmlElemIcon, uint64(pg.IconID))
+	if !uuid.Equal(pg.CustomIconUUID, uuid.Nil) {
+		xw.writeUUID(xmlElemCustomIconID, pg.CustomIconUUID)
+	}
+	xw.writeTimes(&pg.Times)
+	xw.writeBool(xmlElemIsExpanded, pg.IsExpanded)
+	xw.writeString(xmlElemGroupDefaultAutoTypeSeq, pg.DefaultAutoTypeSequence)
+	xw.writeTriState(xmlElemEnableAutoType, pg.EnableAutoType)
+	xw.writeTriState(xmlElemEnableSearching, pg.EnableSearching)
+	xw.writeUUID(xmlElemLastTopVisibleEntry, pg.LastTopVisibleEntry)
+
+	for _, pe := range pg.Entries() {
+		xw.writeEntry(pe, false)
+	}
+	for _, child := range pg.Groups() {
+		xw.writeGroup(child)
+	}
+
+	xw.enc.EncodeToken(endElem(xmlElemGroup))
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+// WriteList(PwObjectList<PwDeletedObject>)
+func (xw *xmlWriter) writeDeletedObjects(list []kpstruct.DeletedObject) {
+	xw.enc.EncodeToken(startElem(xmlElemDeletedObjects))
+	for _, obj := range list {
+		xw.enc.EncodeToken(startElem(xmlElemDeletedObject))
+		xw.writeUUID(xmlElemUuid, obj.UUID)
+		xw.writeTime(xmlElemDeletionTime, obj.DeletionTime)
+		xw.enc.EncodeToken(endElem(xmlElemDeletedObject))
+	}
+	xw.enc.EncodeToken(endElem(xmlElemDeletedObjects))
+}
+
+// writeEntry writes an Entry element. History entries do not have a
+// History element of their own.
+//
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+// WriteEntry()
+func (xw *xmlWriter) writeEntry(pe *kpstruct.PasswordEntry, isHistory bool) {
+	xw.enc.EncodeToken(startElem(xmlElemEntry))
+
+	xw.writeUUID(xmlElemUuid, pe.UUID)
+	xw.writeUint64(xmlElemIcon, uint64(pe.IconID))
+	if !uuid.Equal(pe.CustomIconUUID, uuid.Nil) {
+		xw.writeUUID(xmlElemCustomIconID, pe.CustomIconUUID)
+	}
+	xw.writeString(xmlElemFgColor, pe.ForegroundColor)
+	xw.writeString(xmlElemBgColor, pe.BackgroundColor)
+	xw.writeString(xmlElemOverrideUrl, pe.OverrideURL)
+	xw.writeString(xmlElemTags, pe.TagsString())
+
+	xw.writeTimes(&pe.Times)
+	xw.writeStrings(&pe.Strings)
+	xw.writeBinaries(&pe.Binaries)
+	xw.writeAutoType(&pe.AutoType)
+
+	if !isHistory {
+		xw.enc.EncodeToken(startElem(xmlElemHistory))
+		for _, h := range pe.History {
+			xw.writeEntry(h, true)
+		}
+		xw.enc.EncodeToken(endElem(xmlElemHistory))
+	}
+
+	xw.enc.EncodeToken(endElem(xmlElemEntry))
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+// WriteList(ITimeLogger)
+func (xw *xmlWriter) writeTimes(t *kpstruct.Times) {
+	xw.enc.EncodeToken(startElem(xmlElemTimes))
+	xw.writeTime(xmlElemCreationTime, t.CreationTime)
+	xw.writeTime(xmlElemLastModTime, t.LastModificationTime)
+	xw.writeTime(xmlElemLastAccessTime, t.LastAccessTime)
+	xw.writeTime(xmlElemExpiryTime, t.ExpiryTime)
+	xw.writeBool(xmlElemExpires, t.Expires)
+	xw.writeUint64(xmlElemUsageCount, t.UsageCount)
+	xw.writeTime(xmlElemLocationChanged, t.LocationChanged)
+	xw.enc.EncodeToken(endElem(xmlElemTimes))
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+// WriteList(AutoTypeConfig)
+func (xw *xmlWriter) writeAutoType(c *kpstruct.AutoTypeConfig) {
+	xw.enc.EncodeToken(startElem(xmlElemAutoType))
+	xw.writeBool(xmlElemAutoTypeEnabled, c.Enabled)
+	xw.writeUint64(xmlElemAutoTypeObfuscation, uint64(c.Obfuscation))
+	if c.DefaultSequence != "" {
+		xw.writeString(xmlElemAutoTypeDefaultSeq, c.DefaultSequence)
+	}
+	for _, a := range c.Associations {
+		xw.enc.EncodeToken(startElem(xmlElemAutoTypeItem))
+		xw.writeString(xmlElemWindow, a.Window)
+		xw.writeString(xmlElemKeystrokeSequence, a.Sequence)
+		xw.enc.EncodeToken(endElem(xmlElemAutoTypeItem))
+	}
+	xw.enc.EncodeToken(endElem(xmlElemAutoType))
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+// WriteList(ProtectedStringDictionary)
+func (xw *xmlWriter) writeStrings(d *kpstruct.ProtectedStringDictionary) {
+	for _, name := range d.Keys() {
+		xw.writeProtectedString(name, d.Get(name))
+	}
+}
+
+// writeProtectedString writes a String element. Protected values, and
+// standard fields selected by the memory protection settings, are encrypted
+// with the inner random stream, so they must be written in document order.
+//
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+// WriteObject(string, ProtectedString, bool)
+func (xw *xmlWriter) writeProtectedString(name string, value *kpcrypto.ProtectedString) {
+	xw.enc.EncodeToken(startElem(xmlElemString))
+	xw.enc.EncodeElement(name, startElem(xmlElemKey))
+
+	if !value.IsProtected() && xw.db.MemoryProtection.GetProtection(name) {
+		value = value.WithProtection(true)
+	}
+
+	valueElem := startElem(xmlElemValue)
+	if value.IsProtected() && xw.randomStream != nil {
+		data := value.RevealBytes()
+		xw.randomStream.Cipher(data, true)
+		valueElem.Attr = append(valueElem.Attr, xml.Attr{Name: xml.Name{Local: xmlAttrProtected}, Value: xmlValTrue})
+		xw.enc.EncodeElement(base64.StdEncoding.EncodeToString(data), valueElem)
+	} else if value.IsProtected() {
+		valueElem.Attr = append(valueElem.Attr, xml.Attr{Name: xml.Name{Local: xmlAttrProtectedInMemPlainXml}, Value: xmlValTrue})
+		xw.enc.EncodeElement(value.Reveal(), valueElem)
+	} else {
+		xw.enc.EncodeElement(value.Reveal(), valueElem)
+	}
+
+	xw.enc.EncodeToken(endElem(xmlElemString))
+}
+
+// writeBinaries writes the Binary elements of an entry. Binaries in the
+// pool are written as references; any others are written inline.
+//
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+// WriteList(ProtectedBinaryDictionary)
+func (xw *xmlWriter) writeBinaries(d *kpstruct.ProtectedBinaryDictionary) {
+	for _, name := range d.Keys() {
+		xw.enc.EncodeToken(startElem(xmlElemBinary))
+		xw.enc.EncodeElement(name, startElem(xmlElemKey))
+
+		value := d.Get(name)
+		valueElem := startElem(xmlElemValue)
+		if ref, ok := xw.binPool.ref(value); ok {
+			valueElem.Attr = append(valueElem.Attr, xml.Attr{Name: xml.Name{Local: xmlAttrRef}, Value: strconv.Itoa(ref)})
+			xw.enc.EncodeElement("", valueElem)
+		} else {
+			xw.setErr(xw.writeProtectedBinary(valueElem, value, false))
+		}
+
+		xw.enc.EncodeToken(endElem(xmlElemBinary))
+	}
+}
+
+// writeBinPool writes the Binaries element of the Meta element.
+//
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+// WriteBinPool()
+func (xw *xmlWriter) writeBinPool() {
+	xw.enc.EncodeToken(startElem(xmlElemBinaries))
+	for i, pb := range xw.binPool.binaries {
+		elem := startElem(xmlElemBinary)
+		elem.Attr = append(elem.Attr, xml.Attr{Name: xml.Name{Local: xmlAttrId}, Value: strconv.Itoa(i)})
+		xw.setErr(xw.writeProtectedBinary(elem, pb, xw.db.Compression == CompressionGzip))
+	}
+	xw.enc.EncodeToken(endElem(xmlElemBinaries))
+}
+
+// writeProtectedBinary writes the contents of a binary as the element elem.
+// Protected binaries are encrypted with the inner random stream, and others
+// are compressed if compress is set. The data is streamed through the
+// encoders, so it is never held in plaintext as a whole.
+//
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+// WriteObject(string, ProtectedBinary, bool)
+func (xw *xmlWriter) writeProtectedBinary(elem xml.StartElement, value *kpcrypto.ProtectedBinary, compress bool) error {
+	b64 := base64.NewEncoder(base64.StdEncoding, charDataWriter{xw.enc})
+	var w io.Writer = b64
+	var gz *gzip.Writer
+
+	if value.IsProtected() && xw.randomStream != nil {
+		elem.Attr = append(elem.Attr, xml.Attr{Name: xml.Name{Local: xmlAttrProtected}, Value: xmlValTrue})
+		w = &randomStreamWriter{randomStream: xw.randomStream, w: b64}
+	} else if value.IsProtected() {
+		elem.Attr = append(elem.Attr, xml.Attr{Name: xml.Name{Local: xmlAttrProtectedInMemPlainXml}, Value: xmlValTrue})
+	} else if compress {
+		elem.Attr = append(elem.Attr, xml.Attr{Name: xml.Name{Local: xmlAttrCompressed}, Value: xmlValTrue})
+		gz = gzip.NewWriter(b64)
+		w = gz
+	}
+
+	xw.enc.EncodeToken(elem)
+	_, err := value.WriteTo(w)
+	if gz != nil {
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := b64.Close(); err == nil {
+		err = closeErr
+	}
+	xw.enc.EncodeToken(elem.End())
+	return err
+}
+
+// setErr records err, if it is the first error.
+func (xw *xmlWriter) setErr(err error) {
+	if xw.err == nil {
+		xw.err = err
+	}
+}
+
+// charDataWriter writes to an xml.Encoder as character data.
+type charDataWriter struct {
+	enc *xml.Encoder
+}
+
+func (w charDataWriter) Write(p []byte) (int, error) {
+	err := w.enc.EncodeToken(xml.CharData(p))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// randomStreamWriter encrypts data with the inner random stream before
+// writing it to w.
+type randomStreamWriter struct {
+	randomStream kpcrypto.RandomStream
+	w            io.Writer
+	buf          []byte
+}
+
+func (w *randomStreamWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf[:0], p...)
+	w.randomStream.Cipher(w.buf, true)
+	n, err := w.w.Write(w.buf)
+	for i := range w.buf {
+		w.buf[i] = 0
+	}
+	return n, err
+}
+
+func (xw *xmlWriter) writeString(name, value string) {
+	xw.enc.EncodeElement(value, startElem(name))
+}
+
+func (xw *xmlWriter) writeBool(name string, value bool) {
+	xw.writeString(name, encodeBool(value))
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+// WriteObject(string, bool?)
+func (xw *xmlWriter) writeTriState(name string, value kpstruct.TriState) {
+	switch value {
+	case kpstruct.TriStateTrue:
+		xw.writeString(name, xmlValTrue)
+	case kpstruct.TriStateFalse:
+		xw.writeString(name, xmlValFalse)
+	default:
+		xw.writeString(name, xmlValNull)
+	}
+}
+
+func (xw *xmlWriter) writeInt64(name string, value int64) {
+	xw.writeString(name, strconv.FormatInt(value, 10))
+}
+
+func (xw *xmlWriter) writeUint64(name string, value uint64) {
+	xw.writeString(name, strconv.FormatUint(value, 10))
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+// WriteObject(string, PwUuid)
+func (xw *xmlWriter) writeUUID(name string, value uuid.UUID) {
+	xw.writeString(name, base64.StdEncoding.EncodeToString(value.Bytes()))
+}
+
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+// WriteObject(string, DateTime)
+func (xw *xmlWriter) writeTime(name string, value time.Time) {
+	if xw.binaryTimes {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(value.Unix()+kdbx4TimeUnixOffset))
+		xw.writeString(name, base64.StdEncoding.EncodeToString(b[:]))
+		return
+	}
+	xw.writeString(name, value.UTC().Format(xmlTimeFormat))
+}
@@ -1,21 +1,24 @@
 package database
 
 import (
-	"io"
-	"github.com/riking/go-keepass2/lib"
-	"crypto/rand"
-	"fmt"
-	"github.com/riking/go-keepass2/lib/kpcrypto"
 	"bytes"
-	"encoding/binary"
-	"math"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
-	"github.com/riking/go-keepass2/lib/kpstruct"
+	"encoding/binary"
 	"encoding/xml"
-	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/riking/go-keepass2/lib"
+	"github.com/riking/go-keepass2/lib/keys"
+	"github.com/riking/go-keepass2/lib/kpcrypto"
 )
 
 type WriteFormat int
+
 const (
 	WriteFormatEncrypted WriteFormat = iota
 	WriteFormatPlain
@@ -35,26 +38,27 @@ func fillRandomOrPanic(b []byte) {
 }
 
 // WriteTo writes the database to the given writer stream with the default settings.
-func (db *Database) WriteTo(w io.Writer) (n uint64, error) {
-	return db.WriteOut(w, WriteFormatEncrypted)
+func (db *Database) WriteTo(w io.Writer) (n int64, err error) {
+	count, err := db.WriteOut(w, WriteFormatEncrypted)
+	return int64(count), err
 }
 
 // WriteOut writes the database to the given writer stream.
 //
 // File: KeePassLib/Serialization/KdbxFile.Write.cs
 // Save()
-func (db *Database) WriteOut(w io.Writer, format WriteFormat) (outputCounter uint64, error) {
+func (db *Database) WriteOut(w io.Writer, format WriteFormat) (outputCounter uint64, err error) {
+	if db.Compression >= CompressionInvalid {
+		return 0, ErrUnknownCompression
+	}
+	if db.FormatVersion != FileVersion31 && db.FormatVersion != FileVersion40 {
+		return 0, ErrUnsupportedVersion
+	}
 
 	var masterSeed [32]byte
-	var transformSeed [32]byte
-	var encryptionIV [16]byte
-	var protectedStreamKey [32]byte
 	var streamStartBytes [32]byte
 
 	fillRandomOrPanic(masterSeed[:])
-	fillRandomOrPanic(transformSeed[:])
-	fillRandomOrPanic(encryptionIV[:])
-	fillRandomOrPanic(protectedStreamKey[:])
 	fillRandomOrPanic(streamStartBytes[:])
 
 	hashingWriter := lib.NewHashingWriter(w)
@@ -63,125 +67,229 @@ func (db *Database) WriteOut(w io.Writer, format WriteFormat) (outputCounter uin
 	}()
 
 	var writerStream io.Writer
+	// Streams that need to be closed after the document is written, outermost first
+	var closers []io.Closer
 	var hashOfHeader [sha256.Size]byte
 	var haveHashOfHeader bool
 	buf := bytes.Buffer{}
 
-	db.InnerRandomStream = StreamCipherSalsa20
-	salsaStream := kpcrypto.NewSalsaRandomStream(&protectedStreamKey)
+	// KeePass uses Salsa20 for KDBX 3.1 and ChaCha20 for KDBX 4
+	var protectedStreamKey []byte
+	if db.isKdbx4() {
+		db.InnerRandomStream = StreamCipherChaCha20
+		protectedStreamKey = make([]byte, 64)
+	} else {
+		db.InnerRandomStream = StreamCipherSalsa20
+		protectedStreamKey = make([]byte, 32)
+	}
+	fillRandomOrPanic(protectedStreamKey)
+	randomStream, err := NewRandomStream(db.InnerRandomStream, protectedStreamKey)
+	if err != nil {
+		return 0, err
+	}
+
+	// WriteDocument
+	protBinPool := buildBinPool(db.Root)
 
 	if format == WriteFormatEncrypted {
+		isKdbx4 := db.isKdbx4()
+
+		kdf := keys.LookupKDF(keys.KdfUUID(db.KdfParameters))
+		if kdf == nil {
+			return 0, keys.ErrUnknownKDF
+		}
+		if !isKdbx4 && kdf.UUID() != keys.KdfUUIDAes {
+			return 0, ErrKdbx4Required
+		}
+		kdf.Randomize(db.KdfParameters)
+
+		cipherEngine := kpcrypto.LookupCipher(db.CipherID)
+		if cipherEngine == nil {
+			return 0, ErrUnknownCipher
+		}
+		if !isKdbx4 && db.CipherID == kpcrypto.CipherUUIDChaCha20 {
+			return 0, ErrKdbx4Required
+		}
+		encryptionIV := make([]byte, cipherEngine.IVLength())
+		fillRandomOrPanic(encryptionIV)
+
 		// WriteHeader()
 		buf.Reset()
 		var scratch [8]byte
 		binary.LittleEndian.PutUint32(scratch[0:4], KP2Signature1)
 		binary.LittleEndian.PutUint32(scratch[4:8], KP2Signature2)
 		buf.Write(scratch[0:8])
-		binary.LittleEndian.PutUint32(scratch[0:4], FileVersion)
+		binary.LittleEndian.PutUint32(scratch[0:4], db.FormatVersion)
 		buf.Write(scratch[0:4])
 
-		if db.CipherID != CipherUUIDAesParsed {
-			panic("keepass.Database.WriteOut: Don't know how to write out any cipher other than AES")
-		}
-		writeHeaderField(&buf, HeaderCipherID, db.CipherID.Bytes()) // TODO - bytes might be wrong order
+		writeHeaderField(&buf, db.FormatVersion, HeaderCipherID, db.CipherID.Bytes())
 		binary.LittleEndian.PutUint32(scratch[0:4], uint32(db.Compression))
-		writeHeaderField(&buf, HeaderCompressionFlags, scratch[0:4])
-		writeHeaderField(&buf, HeaderMasterSeed, masterSeed[:])
-		writeHeaderField(&buf, HeaderTransformSeed, transformSeed[:])
-		binary.LittleEndian.PutUint64(scratch[0:8], db.KeyEncryptionRounds)
-		writeHeaderField(&buf, HeaderTransformRounds, scratch[0:8])
-		writeHeaderField(&buf, HeaderEncryptionIV, encryptionIV[:])
-		writeHeaderField(&buf, HeaderProtectedStreamKey, protectedStreamKey[:])
-		writeHeaderField(&buf, HeaderStreamStartBytes, streamStartBytes[:])
-		// note: this was set earlier, so all newly written databases are salsa20
-		binary.LittleEndian.PutUint32(scratch[0:4], uint32(db.InnerRandomStream))
-		writeHeaderField(&buf, HeaderInnerRandomStreamID, scratch[0:4])
+		writeHeaderField(&buf, db.FormatVersion, HeaderCompressionFlags, scratch[0:4])
+		writeHeaderField(&buf, db.FormatVersion, HeaderMasterSeed, masterSeed[:])
+		if !isKdbx4 {
+			writeHeaderField(&buf, db.FormatVersion, HeaderTransformSeed, db.KdfParameters.GetByteArray(keys.AesKdfParamSeed))
+			binary.LittleEndian.PutUint64(scratch[0:8], db.KdfParameters.GetUInt64(keys.AesKdfParamRounds, keys.DefaultKeyEncryptionRounds))
+			writeHeaderField(&buf, db.FormatVersion, HeaderTransformRounds, scratch[0:8])
+		} else {
+			data, err := db.KdfParameters.MarshalBinary()
+			if err != nil {
+				return 0, err
+			}
+			writeHeaderField(&buf, db.FormatVersion, HeaderKdfParameters, data)
+		}
+		writeHeaderField(&buf, db.FormatVersion, HeaderEncryptionIV, encryptionIV)
+		if !isKdbx4 {
+			writeHeaderField(&buf, db.FormatVersion, HeaderProtectedStreamKey, protectedStreamKey)
+			writeHeaderField(&buf, db.FormatVersion, HeaderStreamStartBytes, streamStartBytes[:])
+			// note: this was set earlier, based on the format version
+			binary.LittleEndian.PutUint32(scratch[0:4], uint32(db.InnerRandomStream))
+			writeHeaderField(&buf, db.FormatVersion, HeaderInnerRandomStreamID, scratch[0:4])
+		} else if db.PublicCustomData != nil && db.PublicCustomData.Len() > 0 {
+			data, err := db.PublicCustomData.MarshalBinary()
+			if err != nil {
+				return 0, err
+			}
+			writeHeaderField(&buf, db.FormatVersion, HeaderPublicCustomData, data)
+		}
 		scratch[0], scratch[1] = '\r', '\n'
 		scratch[2], scratch[3] = '\r', '\n'
-		writeHeaderField(&buf, HeaderEndOfHeader, scratch[0:4])
+		writeHeaderField(&buf, db.FormatVersion, HeaderEndOfHeader, scratch[0:4])
 
+		hashOfHeader = sha256.Sum256(buf.Bytes())
+		haveHashOfHeader = !isKdbx4
 
-		{
-			shaHash := sha256.New()
-			buf.WriteTo(shaHash)
-			shaHash.Sum(hashOfHeader[:0])
-			haveHashOfHeader = true
+		cipherKey, hmacKey, err := db.computeKeys(masterSeed[:])
+		if err != nil {
+			return 0, err
+		}
+		defer func() {
+			for i, _ := range hmacKey {
+				hmacKey[i] = 0
+			}
+		}()
+
+		var headerHMAC []byte
+		if isKdbx4 {
+			mac := hmac.New(sha256.New, lib.HMACBlockKey(hmacKey[:], math.MaxUint64))
+			mac.Write(buf.Bytes())
+			headerHMAC = mac.Sum(nil)
 		}
 
-		_, err := buf.WriteTo(hashingWriter)
+		_, err = buf.WriteTo(hashingWriter)
 		if err != nil {
 			return 0, err
 		}
 
 		// AttachStreamEncryption
-		buf.Reset()
-		buf.Write(masterSeed[:])
-		pbInKey, err := db.MasterKey.GenerateKey32(&transformSeed, db.KeyEncryptionRounds)
+		var cipherStream io.Writer = hashingWriter
+		if isKdbx4 {
+			_, err = hashingWriter.Write(hashOfHeader[:])
+			if err != nil {
+				return 0, err
+			}
+			_, err = hashingWriter.Write(headerHMAC)
+			if err != nil {
+				return 0, err
+			}
+
+			hmacStream := lib.NewHMACBlockWriter(hashingWriter, hmacKey[:])
+			closers = append(closers, hmacStream)
+			cipherStream = hmacStream
+		}
+
+		encryptedStream, err := cipherEngine.EncryptStream(cipherStream, cipherKey[:], encryptionIV)
+		for i, _ := range cipherKey {
+			cipherKey[i] = 0
+		}
 		if err != nil {
 			return 0, err
 		}
-		pbInKey.WriteTo(&buf)
-		pbInKey.Clear()
-		var aesKey [sha256.Size]byte
-		{
-			shaHash := sha256.New()
-			buf.WriteTo(shaHash)
-			shaHash.Sum(aesKey[:0])
+		closers = append(closers, encryptedStream)
+		writerStream = encryptedStream
+
+		if !isKdbx4 {
+			_, err = encryptedStream.Write(streamStartBytes[:])
+			if err != nil {
+				return 0, err
+			}
+
+			blockStream := lib.NewHashedBlockWriter(encryptedStream)
+			closers = append(closers, blockStream)
+			writerStream = blockStream
 		}
 
-		// Sanitize buffer
-		buf.Reset()
-		buf.Write(masterSeed[:])
-		buf.Write(transformSeed[:])
+		if db.Compression == CompressionGzip {
+			gzipStream := gzip.NewWriter(writerStream)
+			closers = append(closers, gzipStream)
+			writerStream = gzipStream
+		}
 
-		writerStream = kpcrypto.NewAES256_CBC_PCKS7_Encoder(hashingWriter, aesKey, encryptionIV)
-		for i, _ := range aesKey {
-			aesKey[i] = 0
+		if isKdbx4 {
+			err = db.writeInnerHeader(writerStream, protectedStreamKey, protBinPool)
+			if err != nil {
+				return 0, err
+			}
 		}
 	} else if format == WriteFormatPlain {
 		writerStream = hashingWriter
+		// Protected values are written in plain text
+		randomStream = nil
 	} else {
 		panic("bad WriteFormat in keepass.Database.WriteOut")
 	}
 
-	// WriteDocument
-	var numGroups, numEntries uint32
-	numGroups, numEntries = db.Root.GetCounts(kpstruct.GetCountsRecursive)
-
-	protBinPool := buildBinPool(db.Root)
-
-	enc := xml.NewEncoder(writerStream)
-	enc.Indent("", "\t")
+	xw := newXMLWriter(db, writerStream, randomStream, protBinPool)
+	// KDBX 4 files keep the binary pool in the inner header
+	xw.binPoolInMeta = !db.isKdbx4() || format == WriteFormatPlain
+	xw.binaryTimes = db.isKdbx4() && format == WriteFormatEncrypted
+	enc := xw.enc
 	writerStream.Write([]byte(xmlHeader))
 	enc.EncodeToken(startElem(xmlElemDocNode))
 
-	// WriteMeta()
-	enc.EncodeToken(startElem(xmlElemMeta))
-
-	enc.EncodeElement(ProductName, startElem(xmlElemGenerator))
 	if haveHashOfHeader {
-		buf.Reset()
-		b64Enc := base64.NewEncoder(base64.StdEncoding, buf)
-		b64Enc.Write(hashOfHeader[:])
-		b64Enc.Close()
-		enc.EncodeElement(buf.String(), startElem(xmlElemHeaderHash))
+		xw.writeMeta(hashOfHeader[:])
+	} else {
+		xw.writeMeta(nil)
 	}
 
-
-
-	enc.EncodeToken(endElem(xmlElemMeta))
-	// end WriteMeta()
-
 	enc.EncodeToken(startElem(xmlElemRoot))
+	xw.writeGroup(db.Root)
+	xw.writeDeletedObjects(db.DeletedObjects)
+	enc.EncodeToken(endElem(xmlElemRoot))
 
-	_ = salsaStream
+	enc.EncodeToken(endElem(xmlElemDocNode))
 
-	// TODO
+	if xw.err != nil {
+		return 0, xw.err
+	}
+	err = enc.Flush()
+	if err != nil {
+		return 0, err
+	}
+	for i := len(closers) - 1; i >= 0; i-- {
+		err = closers[i].Close()
+		if err != nil {
+			return 0, err
+		}
+	}
 	return 0, nil
 }
 
-func writeHeaderField(w io.Writer, id kdbxHeaderFieldID, data []byte) {
+func writeHeaderField(w io.Writer, version uint32, id kdbxHeaderFieldID, data []byte) {
 	l := len(data)
+	if version >= FileVersion40 {
+		if l > math.MaxInt32 {
+			panic(fmt.Errorf("header field too big: got %d bytes", l))
+		}
+
+		var head [5]byte
+		head[0] = byte(id)
+		binary.LittleEndian.PutUint32(head[1:], uint32(l))
+		w.Write(head[:])
+		w.Write(data)
+		return
+	}
+
 	if l > math.MaxUint16 {
 		panic(fmt.Errorf("header field too big: got %d bytes", l))
 	}
@@ -193,6 +301,60 @@ func writeHeaderField(w io.Writer, id kdbxHeaderFieldID, data []byte) {
 	w.Write(data)
 }
 
+// writeInnerHeader writes the KDBX 4 inner header, which holds the inner
+// random stream settings and the binary pool.
+//
+// File: KeePassLib/Serialization/KdbxFile.Write.cs
+// WriteInnerHeader()
+func (db *Database) writeInnerHeader(w io.Writer, protectedStreamKey []byte, binPool *binaryPool) error {
+	var scratch [4]byte
+	binary.LittleEndian.PutUint32(scratch[0:4], uint32(db.InnerRandomStream))
+	err := writeInnerHeaderField(w, InnerHeaderInnerRandomStreamID, scratch[0:4])
+	if err != nil {
+		return err
+	}
+	err = writeInnerHeaderField(w, InnerHeaderInnerRandomStreamKey, protectedStreamKey)
+	if err != nil {
+		return err
+	}
+
+	for _, pb := range binPool.binaries {
+		// The binary is streamed rather than passed to writeInnerHeaderField,
+		// as it can be large
+		if pb.Len() >= math.MaxInt32 {
+			return fmt.Errorf("keepass: attachment too big for the file format: %d bytes", pb.Len())
+		}
+		var head [6]byte
+		head[0] = byte(InnerHeaderBinary)
+		binary.LittleEndian.PutUint32(head[1:5], uint32(1+pb.Len()))
+		if pb.IsProtected() {
+			head[5] = innerBinaryFlagProtected
+		}
+		_, err = w.Write(head[:])
+		if err != nil {
+			return err
+		}
+		_, err = pb.WriteTo(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeInnerHeaderField(w, InnerHeaderEndOfHeader, nil)
+}
+
+func writeInnerHeaderField(w io.Writer, id innerHeaderFieldID, data []byte) error {
+	var head [5]byte
+	head[0] = byte(id)
+	binary.LittleEndian.PutUint32(head[1:], uint32(len(data)))
+	_, err := w.Write(head[:])
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
 func startElem(name string) xml.StartElement {
 	return xml.StartElement{Name: xml.Name{Local: name}}
 }
@@ -208,9 +370,3 @@ func encodeBool(val bool) string {
 		return "False"
 	}
 }
-
-func buildBinPool(root kpstruct.PasswordGroup) map[string]kpcrypto.ProtectedBuffer {
-	pool := make(map[string]kpcrypto.ProtectedBuffer)
-	// TODO
-	return pool
-}
\ No newline at end of file
@@ -0,0 +1,331 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/riking/go-keepass2/lib"
+	"github.com/riking/go-keepass2/lib/keys"
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+	"github.com/riking/go-keepass2/lib/kpstruct"
+	"github.com/satori/go.uuid"
+)
+
+const testPassword = "correct horse battery staple"
+
+// fastKdfParameters returns parameters for kdf that are as cheap as the
+// KDF allows.
+func fastKdfParameters(kdf keys.KDF) *lib.VariantDictionary {
+	p := kdf.DefaultParameters()
+	switch kdf.UUID() {
+	case keys.KdfUUIDAes:
+		p.Set(keys.AesKdfParamRounds, uint64(10))
+	case keys.KdfUUIDArgon2d, keys.KdfUUIDArgon2id:
+		p.Set(keys.Argon2ParamIterations, uint64(1))
+		p.Set(keys.Argon2ParamMemory, uint64(8*1024))
+		p.Set(keys.Argon2ParamParallelism, uint32(1))
+	}
+	return p
+}
+
+var testTime = time.Date(2017, time.March, 4, 5, 6, 7, 0, time.UTC)
+
+func testTimes(offset time.Duration) kpstruct.Times {
+	t := testTime.Add(offset)
+	return kpstruct.Times{
+		CreationTime:         t,
+		LastModificationTime: t.Add(time.Hour),
+		LastAccessTime:       t.Add(2 * time.Hour),
+		ExpiryTime:           t.Add(24 * time.Hour),
+		Expires:              true,
+		UsageCount:           3,
+		LocationChanged:      t.Add(3 * time.Hour),
+	}
+}
+
+// newTestDatabase returns a database that uses most features of the file
+// format.
+func newTestDatabase() *Database {
+	db := New()
+	db.MasterKey.AddPassword(testPassword)
+
+	db.Name = "Test database"
+	db.NameChanged = testTime
+	db.Description = "Description <with> & markup"
+	db.DescriptionChanged = testTime.Add(time.Minute)
+	db.DefaultUserName = "user"
+	db.DefaultUserNameChanged = testTime.Add(2 * time.Minute)
+	db.MaintenanceHistoryDays = 30
+	db.Color = "#FF0000"
+	db.MasterKeyChanged = testTime.Add(3 * time.Minute)
+	db.MasterKeyChangeRec = 90
+	db.MasterKeyChangeForce = 180
+	db.MemoryProtection = MemoryProtectionConfig{ProtectUserName: true, ProtectPassword: true}
+	db.CustomIcons = []CustomIcon{{
+		UUID:                 uuid.NewV4(),
+		Data:                 []byte("\x89PNG not really"),
+		Name:                 "icon",
+		LastModificationTime: testTime,
+	}}
+	db.RecycleBinEnabled = false
+	db.RecycleBinUUID = uuid.NewV4()
+	db.RecycleBinChanged = testTime.Add(4 * time.Minute)
+	db.EntryTemplatesGroup = uuid.NewV4()
+	db.EntryTemplatesGroupChanged = testTime.Add(5 * time.Minute)
+	db.HistoryMaxItems = 5
+	db.HistoryMaxSize = 1024 * 1024
+	db.LastSelectedGroup = uuid.NewV4()
+	db.LastTopVisibleGroup = uuid.NewV4()
+	db.CustomData["plugin.setting"] = "value"
+	db.DeletedObjects = []kpstruct.DeletedObject{
+		{UUID: uuid.NewV4(), DeletionTime: testTime},
+		{UUID: uuid.NewV4(), DeletionTime: testTime.Add(time.Hour)},
+	}
+
+	db.Root.Times = testTimes(0)
+	group := kpstruct.NewPasswordGroup("Subgroup")
+	group.Notes = "Group notes"
+	group.Times = testTimes(time.Hour)
+	group.EnableAutoType = kpstruct.TriStateFalse
+	db.Root.AddGroup(group)
+
+	attachment := []byte("shared attachment contents")
+
+	pe := kpstruct.NewPasswordEntry()
+	pe.Strings.Set(kpstruct.TitleField, kpcrypto.NewProtectedString(false, "First"))
+	pe.Strings.Set(kpstruct.UserNameField, kpcrypto.NewProtectedString(true, "alice"))
+	pe.Strings.Set(kpstruct.PasswordField, kpcrypto.NewProtectedString(true, "old password"))
+	pe.Strings.Set("PIN", kpcrypto.NewProtectedString(true, "1234"))
+	pe.Strings.Set("Plain", kpcrypto.NewProtectedString(false, "not secret"))
+	pe.Binaries.Set("a.txt", kpcrypto.NewProtectedBinary(false, attachment))
+	pe.Tags = []string{"one", "two"}
+	pe.Times = testTimes(2 * time.Hour)
+	pe.CreateBackup()
+	pe.Strings.Set(kpstruct.PasswordField, kpcrypto.NewProtectedString(true, "new password"))
+	pe.Binaries.Set("b.bin", kpcrypto.NewProtectedBinary(true, []byte{0, 1, 2, 3}))
+	pe.Times.LastModificationTime = pe.Times.LastModificationTime.Add(time.Hour)
+	db.Root.AddEntry(pe)
+
+	// A second copy of the attachment, which is stored only once
+	pe2 := kpstruct.NewPasswordEntry()
+	pe2.Strings.Set(kpstruct.TitleField, kpcrypto.NewProtectedString(false, "Second"))
+	pe2.Strings.Set(kpstruct.PasswordField, kpcrypto.NewProtectedString(true, ""))
+	pe2.Binaries.Set("copy.txt", kpcrypto.NewProtectedBinary(false, append([]byte(nil), attachment...)))
+	pe2.Times = testTimes(3 * time.Hour)
+	pe2.Times.Expires = false
+	group.AddEntry(pe2)
+
+	return db
+}
+
+// roundTrip writes db and reads it back with the same master key.
+func roundTrip(t *testing.T, db *Database) *Database {
+	var buf bytes.Buffer
+	if _, err := db.WriteOut(&buf, WriteFormatEncrypted); err != nil {
+		t.Fatalf("WriteOut: %v", err)
+	}
+	got, err := Open(&buf, &db.MasterKey)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return got
+}
+
+func checkEntry(t *testing.T, name string, want, got *kpstruct.PasswordEntry) {
+	if got.UUID != want.UUID {
+		t.Errorf("%s: UUID %v, want %v", name, got.UUID, want.UUID)
+	}
+	if !got.Strings.Equal(&want.Strings) {
+		t.Errorf("%s: strings differ", name)
+		for _, k := range want.Strings.Keys() {
+			g, w := got.Strings.Get(k), want.Strings.Get(k)
+			if g == nil || !g.Equal(w, true) {
+				t.Errorf("%s: string %q = %v, want %v", name, k, g, w)
+			}
+		}
+	}
+	if !got.Binaries.Equal(&want.Binaries) {
+		t.Errorf("%s: binaries differ", name)
+	}
+	if !reflect.DeepEqual(got.Tags, want.Tags) {
+		t.Errorf("%s: tags %q, want %q", name, got.Tags, want.Tags)
+	}
+	if got.Times != want.Times {
+		t.Errorf("%s: times %+v, want %+v", name, got.Times, want.Times)
+	}
+	if len(got.History) != len(want.History) {
+		t.Errorf("%s: %d history entries, want %d", name, len(got.History), len(want.History))
+		return
+	}
+	for i := range want.History {
+		checkEntry(t, fmt.Sprintf("%s history %d", name, i), want.History[i], got.History[i])
+	}
+}
+
+func checkDatabase(t *testing.T, want, got *Database) {
+	wantIcons := want.CustomIcons
+	if !want.isKdbx4() {
+		// The name and time of custom icons are only stored by KDBX 4
+		wantIcons = nil
+		for _, icon := range want.CustomIcons {
+			wantIcons = append(wantIcons, CustomIcon{UUID: icon.UUID, Data: icon.Data})
+		}
+	}
+
+	metaChecks := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"Name", got.Name, want.Name},
+		{"NameChanged", got.NameChanged, want.NameChanged},
+		{"Description", got.Description, want.Description},
+		{"DescriptionChanged", got.DescriptionChanged, want.DescriptionChanged},
+		{"DefaultUserName", got.DefaultUserName, want.DefaultUserName},
+		{"DefaultUserNameChanged", got.DefaultUserNameChanged, want.DefaultUserNameChanged},
+		{"MaintenanceHistoryDays", got.MaintenanceHistoryDays, want.MaintenanceHistoryDays},
+		{"Color", got.Color, want.Color},
+		{"MasterKeyChanged", got.MasterKeyChanged, want.MasterKeyChanged},
+		{"MasterKeyChangeRec", got.MasterKeyChangeRec, want.MasterKeyChangeRec},
+		{"MasterKeyChangeForce", got.MasterKeyChangeForce, want.MasterKeyChangeForce},
+		{"MemoryProtection", got.MemoryProtection, want.MemoryProtection},
+		{"CustomIcons", got.CustomIcons, wantIcons},
+		{"RecycleBinEnabled", got.RecycleBinEnabled, want.RecycleBinEnabled},
+		{"RecycleBinUUID", got.RecycleBinUUID, want.RecycleBinUUID},
+		{"RecycleBinChanged", got.RecycleBinChanged, want.RecycleBinChanged},
+		{"EntryTemplatesGroup", got.EntryTemplatesGroup, want.EntryTemplatesGroup},
+		{"EntryTemplatesGroupChanged", got.EntryTemplatesGroupChanged, want.EntryTemplatesGroupChanged},
+		{"HistoryMaxItems", got.HistoryMaxItems, want.HistoryMaxItems},
+		{"HistoryMaxSize", got.HistoryMaxSize, want.HistoryMaxSize},
+		{"LastSelectedGroup", got.LastSelectedGroup, want.LastSelectedGroup},
+		{"LastTopVisibleGroup", got.LastTopVisibleGroup, want.LastTopVisibleGroup},
+		{"CustomData", got.CustomData, want.CustomData},
+		{"DeletedObjects", got.DeletedObjects, want.DeletedObjects},
+		{"FormatVersion", got.FormatVersion, want.FormatVersion},
+		{"CipherID", got.CipherID, want.CipherID},
+		{"Compression", got.Compression, want.Compression},
+		{"KdfUUID", keys.KdfUUID(got.KdfParameters), keys.KdfUUID(want.KdfParameters)},
+	}
+	for _, c := range metaChecks {
+		if !reflect.DeepEqual(c.got, c.want) {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	if got.Root.UUID != want.Root.UUID || got.Root.Times != want.Root.Times {
+		t.Errorf("root group differs")
+	}
+	wantGroup, gotGroup := want.Root.Groups()[0], got.Root.FindGroup(want.Root.Groups()[0].UUID, false)
+	if gotGroup == nil {
+		t.Fatalf("subgroup missing")
+	}
+	if gotGroup.Name != wantGroup.Name || gotGroup.Notes != wantGroup.Notes ||
+		gotGroup.Times != wantGroup.Times || gotGroup.EnableAutoType != wantGroup.EnableAutoType {
+		t.Errorf("subgroup %+v, want %+v", gotGroup, wantGroup)
+	}
+
+	pe, pe2 := want.Root.Entries()[0], wantGroup.Entries()[0]
+	gotPe, gotPe2 := got.Root.FindEntry(pe.UUID, false), gotGroup.FindEntry(pe2.UUID, false)
+	if gotPe == nil || gotPe2 == nil {
+		t.Fatalf("entries missing")
+	}
+	checkEntry(t, "first entry", pe, gotPe)
+	checkEntry(t, "second entry", pe2, gotPe2)
+
+	// The attachment is written once, so all entries get the same binary
+	shared := gotPe.Binaries.Get("a.txt")
+	if gotPe2.Binaries.Get("copy.txt") != shared {
+		t.Errorf("attachment of the second entry is not shared")
+	}
+	if len(gotPe.History) == 1 && gotPe.History[0].Binaries.Get("a.txt") != shared {
+		t.Errorf("attachment of the history entry is not shared")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	ciphers := []uuid.UUID{kpcrypto.CipherUUIDAes, kpcrypto.CipherUUIDChaCha20, kpcrypto.CipherUUIDTwofish}
+	kdfs := []keys.KDF{keys.AesKDF{}, keys.Argon2KDF{Variant: kpcrypto.Argon2d}, keys.Argon2KDF{Variant: kpcrypto.Argon2id}}
+
+	for _, version := range []uint32{FileVersion31, FileVersion40} {
+		for _, compression := range []CompressionAlgorithmID{CompressionNone, CompressionGzip} {
+			for _, cipherID := range ciphers {
+				for _, kdf := range kdfs {
+					ce := kpcrypto.LookupCipher(cipherID)
+					name := fmt.Sprintf("version %x, compression %d, %s, KDF %v", version, compression, ce.DisplayName(), kdf.UUID())
+					kdbx4Only := cipherID == kpcrypto.CipherUUIDChaCha20 || kdf.UUID() != keys.KdfUUIDAes
+
+					db := newTestDatabase()
+					db.FormatVersion = version
+					db.Compression = compression
+					db.CipherID = cipherID
+					db.KdfParameters = fastKdfParameters(kdf)
+
+					if version == FileVersion31 && kdbx4Only {
+						_, err := db.WriteOut(new(bytes.Buffer), WriteFormatEncrypted)
+						if err != ErrKdbx4Required {
+							t.Errorf("%s: got %v, want ErrKdbx4Required", name, err)
+						}
+						continue
+					}
+
+					t.Run(name, func(t *testing.T) {
+						checkDatabase(t, db, roundTrip(t, db))
+					})
+				}
+			}
+		}
+	}
+}
+
+func TestRoundTripPlain(t *testing.T) {
+	db := newTestDatabase()
+	var buf bytes.Buffer
+	if _, err := db.WriteOut(&buf, WriteFormatPlain); err != nil {
+		t.Fatal(err)
+	}
+	got := New()
+	if err := got.ReadIn(&buf, WriteFormatPlain); err != nil {
+		t.Fatal(err)
+	}
+	// The settings in the file header are not part of the XML document
+	got.FormatVersion = db.FormatVersion
+	got.CipherID = db.CipherID
+	got.Compression = db.Compression
+	got.KdfParameters = db.KdfParameters
+	checkDatabase(t, db, got)
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, version := range []uint32{FileVersion31, FileVersion40} {
+		db := newTestDatabase()
+		db.FormatVersion = version
+		db.Compression = CompressionNone
+		db.KdfParameters = fastKdfParameters(keys.AesKDF{})
+
+		var buf bytes.Buffer
+		if _, err := db.WriteOut(&buf, WriteFormatEncrypted); err != nil {
+			t.Fatal(err)
+		}
+		file := buf.Bytes()
+
+		var wrongKey keys.Composite
+		wrongKey.AddPassword(testPassword + "!")
+		if _, err := Open(bytes.NewReader(file), &wrongKey); err != ErrInvalidKey {
+			t.Errorf("version %x, wrong key: got %v, want ErrInvalidKey", version, err)
+		}
+
+		// The byte is inside the encrypted document, before the final block
+		// of the block stream.
+		corrupted := append([]byte(nil), file...)
+		corrupted[len(corrupted)-100] ^= 0x01
+		if _, err := Open(bytes.NewReader(corrupted), &db.MasterKey); err == nil {
+			t.Errorf("version %x, flipped body byte: no error", version)
+		}
+
+		if _, err := Open(bytes.NewReader(file[:len(file)/2]), &db.MasterKey); err == nil {
+			t.Errorf("version %x, truncated: no error", version)
+		}
+	}
+}
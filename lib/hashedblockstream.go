@@ -0,0 +1,227 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultHashedBlockSize is the size of the blocks written by a HashedBlockWriter.
+//
+// File: KeePassLib/Serialization/HashedBlockStream.cs
+// m_nDefaultBufferSize
+const DefaultHashedBlockSize = 1024 * 1024
+
+// ErrBlockStreamCorrupted is returned by a HashedBlockReader when the block
+// framing is invalid.
+var ErrBlockStreamCorrupted = errors.New("keepass: hashed block stream is corrupted")
+
+// BlockHashMismatchError is returned by a HashedBlockReader when the contents
+// of a block do not match its stored hash.
+type BlockHashMismatchError struct {
+	BlockIndex uint32
+}
+
+func (e *BlockHashMismatchError) Error() string {
+	return fmt.Sprintf("keepass: hash mismatch in block %d of hashed block stream", e.BlockIndex)
+}
+
+// HashedBlockWriter splits the written data into blocks, each prefixed with
+// its index, SHA-256 hash and length.
+//
+// File: KeePassLib/Serialization/HashedBlockStream.cs
+type HashedBlockWriter struct {
+	w          io.Writer
+	buffer     []byte
+	blockIndex uint32
+	stickErr   error
+}
+
+// NewHashedBlockWriter creates a new HashedBlockWriter over the provided io.Writer.
+func NewHashedBlockWriter(w io.Writer) *HashedBlockWriter {
+	return &HashedBlockWriter{
+		w:      w,
+		buffer: make([]byte, 0, DefaultHashedBlockSize),
+	}
+}
+
+func (hw *HashedBlockWriter) Write(p []byte) (n int, err error) {
+	if hw.stickErr != nil {
+		return 0, hw.stickErr
+	}
+	for len(p) > 0 {
+		if len(hw.buffer) == cap(hw.buffer) {
+			err = hw.writeHashedBlock()
+			if err != nil {
+				hw.stickErr = err
+				return n, err
+			}
+		}
+
+		cur := len(hw.buffer)
+		nCopy := copy(hw.buffer[cur:cap(hw.buffer)], p)
+		hw.buffer = hw.buffer[:cur+nCopy]
+		p = p[nCopy:]
+		n += nCopy
+	}
+	return n, nil
+}
+
+// Close writes out any buffered data, followed by the terminating block.
+// It does not close the underlying writer.
+//
+// File: KeePassLib/Serialization/HashedBlockStream.cs
+// Close()
+func (hw *HashedBlockWriter) Close() error {
+	if hw.stickErr != nil {
+		return hw.stickErr
+	}
+	if len(hw.buffer) > 0 {
+		// Write remaining buffered data
+		hw.stickErr = hw.writeHashedBlock()
+		if hw.stickErr != nil {
+			return hw.stickErr
+		}
+	}
+	// Write terminating block
+	hw.stickErr = hw.writeHashedBlock()
+	if hw.stickErr != nil {
+		return hw.stickErr
+	}
+	hw.stickErr = errors.New("keepass: write to closed HashedBlockWriter")
+	return nil
+}
+
+// File: KeePassLib/Serialization/HashedBlockStream.cs
+// WriteHashedBlock()
+func (hw *HashedBlockWriter) writeHashedBlock() error {
+	var head [4 + sha256.Size + 4]byte
+	binary.LittleEndian.PutUint32(head[0:4], hw.blockIndex)
+	hw.blockIndex++
+
+	if len(hw.buffer) > 0 {
+		hash := sha256.Sum256(hw.buffer)
+		copy(head[4:4+sha256.Size], hash[:])
+	}
+	// else: zero hash
+	binary.LittleEndian.PutUint32(head[4+sha256.Size:], uint32(len(hw.buffer)))
+
+	_, err := hw.w.Write(head[:])
+	if err != nil {
+		return err
+	}
+	if len(hw.buffer) > 0 {
+		_, err = hw.w.Write(hw.buffer)
+		if err != nil {
+			return err
+		}
+	}
+
+	hw.buffer = hw.buffer[:0]
+	return nil
+}
+
+// HashedBlockReader reads the data written by a HashedBlockWriter, verifying
+// the hash of each block.
+//
+// File: KeePassLib/Serialization/HashedBlockStream.cs
+type HashedBlockReader struct {
+	r          io.Reader
+	buffer     []byte
+	bufferPos  int
+	blockIndex uint32
+	stickErr   error
+}
+
+// NewHashedBlockReader creates a new HashedBlockReader over the provided io.Reader.
+func NewHashedBlockReader(r io.Reader) *HashedBlockReader {
+	return &HashedBlockReader{r: r}
+}
+
+func (hr *HashedBlockReader) Read(p []byte) (n int, err error) {
+	for hr.bufferPos == len(hr.buffer) {
+		if hr.stickErr != nil {
+			return 0, hr.stickErr
+		}
+		hr.stickErr = hr.readHashedBlock()
+	}
+
+	n = copy(p, hr.buffer[hr.bufferPos:])
+	hr.bufferPos += n
+	return n, nil
+}
+
+// readHashedBlock reads and verifies the next block. It returns io.EOF after
+// reading the terminating block.
+//
+// File: KeePassLib/Serialization/HashedBlockStream.cs
+// ReadHashedBlock()
+func (hr *HashedBlockReader) readHashedBlock() error {
+	var head [4 + sha256.Size + 4]byte
+	_, err := io.ReadFull(hr.r, head[:])
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	}
+
+	if binary.LittleEndian.Uint32(head[0:4]) != hr.blockIndex {
+		return ErrBlockStreamCorrupted
+	}
+	blockIndex := hr.blockIndex
+	hr.blockIndex++
+
+	storedHash := head[4 : 4+sha256.Size]
+	blockSize := int32(binary.LittleEndian.Uint32(head[4+sha256.Size:]))
+	if blockSize < 0 {
+		return ErrBlockStreamCorrupted
+	}
+
+	if blockSize == 0 {
+		var zeroHash [sha256.Size]byte
+		if !bytes.Equal(storedHash, zeroHash[:]) {
+			return ErrBlockStreamCorrupted
+		}
+		return io.EOF
+	}
+
+	block, err := readBlockData(hr.r, hr.buffer, int(blockSize))
+	if err != nil {
+		return err
+	}
+
+	computedHash := sha256.Sum256(block)
+	if !bytes.Equal(storedHash, computedHash[:]) {
+		return &BlockHashMismatchError{BlockIndex: blockIndex}
+	}
+	hr.buffer = block
+	hr.bufferPos = 0
+	return nil
+}
+
+// readBlockData reads a block of size bytes from r, reusing buf if it is
+// large enough. Larger blocks are copied through a bytes.Buffer so that a
+// corrupt size cannot make us allocate more memory than the stream
+// actually contains.
+func readBlockData(r io.Reader, buf []byte, size int) ([]byte, error) {
+	if size <= cap(buf) {
+		buf = buf[:size]
+		_, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return buf, err
+	}
+
+	dataBuf := bytes.Buffer{}
+	_, err := io.CopyN(&dataBuf, r, int64(size))
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	return dataBuf.Bytes(), nil
+}
@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+// blockStreamSizes are the data sizes used for the block stream round
+// trips, around the block size.
+var blockStreamSizes = []int{0, 1, 1000, DefaultHashedBlockSize, DefaultHashedBlockSize + 1, 3*DefaultHashedBlockSize + 17}
+
+// testBlockRoundTrip writes data of each of the blockStreamSizes through a
+// block stream writer, and checks that the reader returns it unchanged.
+func testBlockRoundTrip(t *testing.T, newWriter func(io.Writer) io.WriteCloser, newReader func(io.Reader) io.Reader) {
+	for _, n := range blockStreamSizes {
+		data := testData(n)
+		var buf bytes.Buffer
+		w := newWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := io.ReadAll(newReader(&buf))
+		if err != nil {
+			t.Fatalf("n=%d: %v", n, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("n=%d: data mismatch", n)
+		}
+	}
+}
+
+func TestHashedBlockRoundTrip(t *testing.T) {
+	testBlockRoundTrip(t,
+		func(w io.Writer) io.WriteCloser { return NewHashedBlockWriter(w) },
+		func(r io.Reader) io.Reader { return NewHashedBlockReader(r) })
+}
+
+func TestHashedBlockCorrupted(t *testing.T) {
+	var buf bytes.Buffer
+	hw := NewHashedBlockWriter(&buf)
+	hw.Write(testData(100))
+	hw.Close()
+	good := buf.Bytes()
+
+	tampered := append([]byte(nil), good...)
+	tampered[4+sha256.Size+4+10] ^= 1
+	_, err := io.ReadAll(NewHashedBlockReader(bytes.NewReader(tampered)))
+	if _, ok := err.(*BlockHashMismatchError); !ok {
+		t.Errorf("tampered data: got %v, want BlockHashMismatchError", err)
+	}
+
+	_, err = io.ReadAll(NewHashedBlockReader(bytes.NewReader(good[:len(good)-1])))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated stream: got %v, want io.ErrUnexpectedEOF", err)
+	}
+
+	// A block claiming to be 2 GiB long must fail without allocating it
+	huge := append([]byte(nil), good[:4+sha256.Size+4]...)
+	binary.LittleEndian.PutUint32(huge[4+sha256.Size:], 0x7FFFFFFF)
+	huge = append(huge, good[4+sha256.Size+4:]...)
+	_, err = io.ReadAll(NewHashedBlockReader(bytes.NewReader(huge)))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("oversized block: got %v, want io.ErrUnexpectedEOF", err)
+	}
+}
@@ -1,14 +1,14 @@
 package lib
 
 import (
-	"io"
-	"hash"
 	"crypto/sha256"
+	"hash"
+	"io"
 )
 
 type HashingWriter struct {
-	w io.Writer
-	hasher hash.Hash
+	w         io.Writer
+	hasher    hash.Hash
 	byteCount uint64
 }
 
@@ -16,7 +16,7 @@ type HashingWriter struct {
 // If hasher is nil, sha256.New() will be used.
 func NewHashingWriter(w io.Writer) *HashingWriter {
 	return &HashingWriter{
-		w: w,
+		w:      w,
 		hasher: sha256.New(),
 	}
 }
@@ -37,11 +37,11 @@ func (hw *HashingWriter) Write(p []byte) (n int, err error) {
 		// Re-slice to account for short writes
 		hw.hasher.Write(p[:n])
 	}
-	hw.byteCount += n
+	hw.byteCount += uint64(n)
 	return
 }
 
 // ByteCount returns the number of bytes that have passed through this writer.
 func (hw *HashingWriter) ByteCount() uint64 {
 	return hw.byteCount
-}
\ No newline at end of file
+}
@@ -0,0 +1,209 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BlockHMACMismatchError is returned by a HMACBlockReader when the contents
+// of a block do not match its stored HMAC.
+type BlockHMACMismatchError struct {
+	BlockIndex uint64
+}
+
+func (e *BlockHMACMismatchError) Error() string {
+	return fmt.Sprintf("keepass: HMAC mismatch in block %d of HMAC block stream", e.BlockIndex)
+}
+
+// HMACBlockKey derives the HMAC-SHA256 key for the block with the given index
+// from the 64-byte HMAC base key.
+//
+// File: KeePassLib/Serialization/HmacBlockStream.cs
+// GetHmacKey64()
+func HMACBlockKey(baseKey []byte, blockIndex uint64) []byte {
+	var scratch [8]byte
+	binary.LittleEndian.PutUint64(scratch[:], blockIndex)
+	h := sha512.New()
+	h.Write(scratch[:])
+	h.Write(baseKey)
+	return h.Sum(nil)
+}
+
+// HMACBlockWriter splits the written data into blocks, each prefixed with
+// its HMAC-SHA256 and length. It is the KDBX 4 replacement for HashedBlockWriter.
+//
+// File: KeePassLib/Serialization/HmacBlockStream.cs
+type HMACBlockWriter struct {
+	w          io.Writer
+	key        []byte
+	buffer     []byte
+	blockIndex uint64
+	stickErr   error
+}
+
+// NewHMACBlockWriter creates a new HMACBlockWriter over the provided io.Writer,
+// using the 64-byte HMAC base key.
+func NewHMACBlockWriter(w io.Writer, baseKey []byte) *HMACBlockWriter {
+	return &HMACBlockWriter{
+		w:      w,
+		key:    append([]byte(nil), baseKey...),
+		buffer: make([]byte, 0, DefaultHashedBlockSize),
+	}
+}
+
+func (hw *HMACBlockWriter) Write(p []byte) (n int, err error) {
+	if hw.stickErr != nil {
+		return 0, hw.stickErr
+	}
+	for len(p) > 0 {
+		if len(hw.buffer) == cap(hw.buffer) {
+			err = hw.writeSafeBlock()
+			if err != nil {
+				hw.stickErr = err
+				return n, err
+			}
+		}
+
+		cur := len(hw.buffer)
+		nCopy := copy(hw.buffer[cur:cap(hw.buffer)], p)
+		hw.buffer = hw.buffer[:cur+nCopy]
+		p = p[nCopy:]
+		n += nCopy
+	}
+	return n, nil
+}
+
+// Close writes out any buffered data, followed by the terminating block.
+// It does not close the underlying writer.
+func (hw *HMACBlockWriter) Close() error {
+	if hw.stickErr != nil {
+		return hw.stickErr
+	}
+	if len(hw.buffer) > 0 {
+		hw.stickErr = hw.writeSafeBlock()
+		if hw.stickErr != nil {
+			return hw.stickErr
+		}
+	}
+	hw.stickErr = hw.writeSafeBlock()
+	if hw.stickErr != nil {
+		return hw.stickErr
+	}
+	for i := range hw.key {
+		hw.key[i] = 0
+	}
+	hw.stickErr = errors.New("keepass: write to closed HMACBlockWriter")
+	return nil
+}
+
+// File: KeePassLib/Serialization/HmacBlockStream.cs
+// WriteSafeBlock()
+func (hw *HMACBlockWriter) writeSafeBlock() error {
+	var head [sha256.Size + 4]byte
+	mac := blockHMAC(hw.key, hw.blockIndex, hw.buffer)
+	copy(head[0:sha256.Size], mac)
+	binary.LittleEndian.PutUint32(head[sha256.Size:], uint32(len(hw.buffer)))
+	hw.blockIndex++
+
+	_, err := hw.w.Write(head[:])
+	if err != nil {
+		return err
+	}
+	if len(hw.buffer) > 0 {
+		_, err = hw.w.Write(hw.buffer)
+		if err != nil {
+			return err
+		}
+	}
+
+	hw.buffer = hw.buffer[:0]
+	return nil
+}
+
+// HMACBlockReader reads the data written by a HMACBlockWriter, verifying the
+// HMAC of each block.
+//
+// File: KeePassLib/Serialization/HmacBlockStream.cs
+type HMACBlockReader struct {
+	r          io.Reader
+	key        []byte
+	buffer     []byte
+	bufferPos  int
+	blockIndex uint64
+	stickErr   error
+}
+
+// NewHMACBlockReader creates a new HMACBlockReader over the provided io.Reader,
+// using the 64-byte HMAC base key.
+func NewHMACBlockReader(r io.Reader, baseKey []byte) *HMACBlockReader {
+	return &HMACBlockReader{r: r, key: append([]byte(nil), baseKey...)}
+}
+
+func (hr *HMACBlockReader) Read(p []byte) (n int, err error) {
+	for hr.bufferPos == len(hr.buffer) {
+		if hr.stickErr != nil {
+			return 0, hr.stickErr
+		}
+		hr.stickErr = hr.readSafeBlock()
+	}
+
+	n = copy(p, hr.buffer[hr.bufferPos:])
+	hr.bufferPos += n
+	return n, nil
+}
+
+// readSafeBlock reads and verifies the next block. It returns io.EOF after
+// reading the terminating block.
+//
+// File: KeePassLib/Serialization/HmacBlockStream.cs
+// ReadSafeBlock()
+func (hr *HMACBlockReader) readSafeBlock() error {
+	var head [sha256.Size + 4]byte
+	_, err := io.ReadFull(hr.r, head[:])
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	}
+
+	storedMAC := head[0:sha256.Size]
+	blockSize := int32(binary.LittleEndian.Uint32(head[sha256.Size:]))
+	if blockSize < 0 {
+		return ErrBlockStreamCorrupted
+	}
+
+	block, err := readBlockData(hr.r, hr.buffer, int(blockSize))
+	if err != nil {
+		return err
+	}
+
+	blockIndex := hr.blockIndex
+	hr.blockIndex++
+	if !hmac.Equal(storedMAC, blockHMAC(hr.key, blockIndex, block)) {
+		return &BlockHMACMismatchError{BlockIndex: blockIndex}
+	}
+
+	hr.buffer = block
+	hr.bufferPos = 0
+	if blockSize == 0 {
+		return io.EOF
+	}
+	return nil
+}
+
+// blockHMAC computes the HMAC of one block: its index, length and data.
+func blockHMAC(baseKey []byte, blockIndex uint64, data []byte) []byte {
+	var scratch [12]byte
+	binary.LittleEndian.PutUint64(scratch[0:8], blockIndex)
+	binary.LittleEndian.PutUint32(scratch[8:12], uint32(len(data)))
+
+	mac := hmac.New(sha256.New, HMACBlockKey(baseKey, blockIndex))
+	mac.Write(scratch[:])
+	mac.Write(data)
+	return mac.Sum(nil)
+}
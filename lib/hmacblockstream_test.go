@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+var testHMACKey = bytes.Repeat([]byte{0x42}, 64)
+
+func TestHMACBlockRoundTrip(t *testing.T) {
+	testBlockRoundTrip(t,
+		func(w io.Writer) io.WriteCloser { return NewHMACBlockWriter(w, testHMACKey) },
+		func(r io.Reader) io.Reader { return NewHMACBlockReader(r, testHMACKey) })
+}
+
+// hmacBlocks writes data through a HMACBlockWriter, and returns the
+// encoded blocks, including the final empty block.
+func hmacBlocks(t *testing.T, data []byte) [][]byte {
+	var buf bytes.Buffer
+	hw := NewHMACBlockWriter(&buf, testHMACKey)
+	if _, err := hw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := hw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var blocks [][]byte
+	b := buf.Bytes()
+	for len(b) > 0 {
+		n := sha256.Size + 4 + int(binary.LittleEndian.Uint32(b[sha256.Size:]))
+		blocks = append(blocks, b[:n:n])
+		b = b[n:]
+	}
+	return blocks
+}
+
+func TestHMACBlockCorrupted(t *testing.T) {
+	blocks := hmacBlocks(t, testData(2*DefaultHashedBlockSize+10))
+	if len(blocks) != 4 {
+		t.Fatalf("got %d blocks, want 4", len(blocks))
+	}
+	b0, b1, b2, final := blocks[0], blocks[1], blocks[2], blocks[3]
+
+	flipped := func(block []byte, i int) []byte {
+		block = append([]byte(nil), block...)
+		block[i] ^= 1
+		return block
+	}
+	tests := []struct {
+		name   string
+		blocks [][]byte
+		// mismatch is the index of the block that fails verification, or -1
+		// if reading should fail with io.ErrUnexpectedEOF
+		mismatch int
+	}{
+		{"reordered blocks", [][]byte{b1, b0, b2, final}, 0},
+		{"missing block", [][]byte{b0, b2, final}, 1},
+		{"tampered data", [][]byte{b0, flipped(b1, sha256.Size+4+10), b2, final}, 1},
+		{"tampered final block", [][]byte{b0, b1, b2, flipped(final, 0)}, 3},
+		{"missing final block", [][]byte{b0, b1, b2}, -1},
+		{"truncated final block", [][]byte{b0, b1, b2, final[:10]}, -1},
+	}
+	for _, tt := range tests {
+		_, err := io.ReadAll(NewHMACBlockReader(bytes.NewReader(bytes.Join(tt.blocks, nil)), testHMACKey))
+		if tt.mismatch < 0 {
+			if err != io.ErrUnexpectedEOF {
+				t.Errorf("%s: got %v, want io.ErrUnexpectedEOF", tt.name, err)
+			}
+			continue
+		}
+		if e, ok := err.(*BlockHMACMismatchError); !ok || e.BlockIndex != uint64(tt.mismatch) {
+			t.Errorf("%s: got %v, want a mismatch in block %d", tt.name, err, tt.mismatch)
+		}
+	}
+}
+
+func TestHMACBlockWrongKey(t *testing.T) {
+	stream := bytes.Join(hmacBlocks(t, testData(100)), nil)
+	wrongKey := bytes.Repeat([]byte{0x43}, 64)
+	_, err := io.ReadAll(NewHMACBlockReader(bytes.NewReader(stream), wrongKey))
+	if e, ok := err.(*BlockHMACMismatchError); !ok || e.BlockIndex != 0 {
+		t.Errorf("got %v, want a mismatch in block 0", err)
+	}
+}
+
+func TestHMACBlockOversized(t *testing.T) {
+	// A block claiming to be 2 GiB long must fail without allocating it
+	stream := bytes.Join(hmacBlocks(t, testData(100)), nil)
+	binary.LittleEndian.PutUint32(stream[sha256.Size:], 0x7FFFFFFF)
+	_, err := io.ReadAll(NewHMACBlockReader(bytes.NewReader(stream), testHMACKey))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v, want io.ErrUnexpectedEOF", err)
+	}
+}
@@ -0,0 +1,200 @@
+// Package keyfile reads and writes KeePass key files.
+package keyfile
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+)
+
+// KeySize is the size of the key stored in a key file.
+const KeySize = 32
+
+var (
+	ErrInvalidFormat      = errors.New("keepass: the key file is corrupted")
+	ErrHashMismatch       = errors.New("keepass: the key file hash check failed, the key file is corrupted")
+	ErrUnsupportedVersion = errors.New("keepass: the key file version is unsupported")
+)
+
+// xmlKeyFile is the structure of a XML key file.
+//
+// file: KeePassLib/Keys/KfxFile.cs
+type xmlKeyFile struct {
+	XMLName xml.Name `xml:"KeyFile"`
+	Meta    struct {
+		Version string `xml:"Version"`
+	} `xml:"Meta"`
+	Key struct {
+		Data struct {
+			Hash  string `xml:"Hash,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"Data"`
+	} `xml:"Key"`
+}
+
+// Load reads a key file from r and returns its key.
+//
+// file: KeePassLib/Keys/KcpKeyFile.cs
+// LoadKeyFile()
+func Load(r io.Reader) (*kpcrypto.ProtectedBuffer, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for i := range data {
+			data[i] = 0
+		}
+	}()
+	return Parse(data)
+}
+
+// Parse returns the key of the key file with the given contents.
+//
+// XML key files (versions 1.0 and 2.0) are read first. Otherwise a file of
+// 32 bytes is used as the key directly, a file of 64 hex digits is decoded,
+// and any other file is hashed with SHA-256.
+//
+// file: KeePassLib/Keys/KcpKeyFile.cs
+// LoadKeyFile()
+func Parse(data []byte) (*kpcrypto.ProtectedBuffer, error) {
+	var kf xmlKeyFile
+	if xml.Unmarshal(data, &kf) == nil {
+		return parseXML(&kf)
+	}
+
+	key := make([]byte, KeySize)
+	switch {
+	case len(data) == KeySize:
+		copy(key, data)
+	case len(data) == 2*KeySize && isHex(data):
+		hex.Decode(key, data)
+	default:
+		sum := sha256.Sum256(data)
+		copy(key, sum[:])
+	}
+	return protect(key), nil
+}
+
+func isHex(data []byte) bool {
+	return len(bytes.Trim(data, "0123456789abcdefABCDEF")) == 0
+}
+
+// file: KeePassLib/Keys/KfxFile.cs
+// Load()
+func parseXML(kf *xmlKeyFile) (*kpcrypto.ProtectedBuffer, error) {
+	major, err := strconv.Atoi(strings.SplitN(strings.TrimSpace(kf.Meta.Version), ".", 2)[0])
+	if err != nil {
+		return nil, ErrInvalidFormat
+	}
+
+	var key []byte
+	switch major {
+	case 1:
+		key, err = base64.StdEncoding.DecodeString(strings.TrimSpace(kf.Key.Data.Value))
+		if err != nil {
+			return nil, ErrInvalidFormat
+		}
+	case 2:
+		key, err = hex.DecodeString(strings.Join(strings.Fields(kf.Key.Data.Value), ""))
+		if err != nil {
+			return nil, ErrInvalidFormat
+		}
+		if kf.Key.Data.Hash != "" {
+			storedHash, err := hex.DecodeString(strings.TrimSpace(kf.Key.Data.Hash))
+			if err != nil {
+				return nil, ErrInvalidFormat
+			}
+			if !bytes.Equal(storedHash, keyHash(key)) {
+				return nil, ErrHashMismatch
+			}
+		}
+	default:
+		return nil, ErrUnsupportedVersion
+	}
+
+	if len(key) != KeySize {
+		return nil, ErrInvalidFormat
+	}
+	return protect(key), nil
+}
+
+// protect moves key into a ProtectedBuffer, zeroing key.
+func protect(key []byte) *kpcrypto.ProtectedBuffer {
+	pb := kpcrypto.NewProtectedBuffer(key)
+	for i := range key {
+		key[i] = 0
+	}
+	return pb
+}
+
+// keyHash returns the check hash stored with the key in version 2.0 files.
+func keyHash(key []byte) []byte {
+	sum := sha256.Sum256(key)
+	return sum[0:4]
+}
+
+// Generate writes a new version 2.0 XML key file with a random key to w.
+//
+// file: KeePassLib/Keys/KcpKeyFile.cs
+// Create()
+func Generate(w io.Writer) error {
+	key := make([]byte, KeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return err
+	}
+	err = Write(w, key)
+	for i := range key {
+		key[i] = 0
+	}
+	return err
+}
+
+// Write writes a version 2.0 XML key file holding key to w.
+//
+// file: KeePassLib/Keys/KfxFile.cs
+// Create(), Save()
+func Write(w io.Writer, key []byte) error {
+	if len(key) != KeySize {
+		return fmt.Errorf("keepass: key file key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	// The key is written as groups of 8 hex digits, 4 groups per line
+	hexKey := strings.ToUpper(hex.EncodeToString(key))
+	var data strings.Builder
+	for i := 0; i < len(hexKey); i += 8 {
+		if i%32 == 0 {
+			data.WriteString("\n\t\t\t")
+		} else {
+			data.WriteByte(' ')
+		}
+		data.WriteString(hexKey[i : i+8])
+	}
+
+	_, err := fmt.Fprintf(w, xmlKeyFileV2, strings.ToUpper(hex.EncodeToString(keyHash(key))), data.String())
+	return err
+}
+
+// xmlKeyFileV2 is the layout of the version 2.0 files written by KeePass.
+const xmlKeyFileV2 = `<?xml version="1.0" encoding="utf-8"?>
+<KeyFile>
+	<Meta>
+		<Version>2.0</Version>
+	</Meta>
+	<Key>
+		<Data Hash="%s">%s
+		</Data>
+	</Key>
+</KeyFile>
+`
@@ -0,0 +1,129 @@
+package keyfile
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// testKeyHex is the key of the test files, the bytes 0x00 to 0x1F.
+const testKeyHex = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+const testKeyFileV2 = `<?xml version="1.0" encoding="utf-8"?>
+<KeyFile>
+	<Meta>
+		<Version>2.0</Version>
+	</Meta>
+	<Key>
+		<Data Hash="630DCD29">
+			00010203 04050607 08090A0B 0C0D0E0F
+			10111213 14151617 18191A1B 1C1D1E1F
+		</Data>
+	</Key>
+</KeyFile>
+`
+
+const testKeyFileV1 = `<?xml version="1.0" encoding="utf-8"?>
+<KeyFile>
+	<Meta>
+		<Version>1.00</Version>
+	</Meta>
+	<Key>
+		<Data>AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=</Data>
+	</Key>
+</KeyFile>
+`
+
+func testKey() []byte {
+	key, _ := hex.DecodeString(testKeyHex)
+	return key
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"version 2.0", testKeyFileV2, testKeyHex},
+		{"version 1.0", testKeyFileV1, testKeyHex},
+		{"raw 32 bytes", string(testKey()), testKeyHex},
+		{"64 hex digits", testKeyHex, testKeyHex},
+		{"64 upper-case hex digits", strings.ToUpper(testKeyHex), testKeyHex},
+		// Other files are hashed with SHA-256
+		{"arbitrary file", "This is not a key file.\n", "aaf3ba3cde3d9f85f1a1e3b60fb637ebb2a32763e2c94d9ed30ad4b54600856b"},
+		{"64 characters, not hex", strings.Repeat("g", 64), "4e52b0a8d918b923a15f50e49b43cd4f99cf19eb581bd84dcc2f0b288e55da04"},
+	}
+	for _, tt := range tests {
+		pb, err := Parse([]byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := hex.EncodeToString(pb.Reveal()); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	// Flip one hex digit of the key, so that it no longer matches the hash
+	flipped := strings.Replace(testKeyFileV2, "08090A0B", "08090A0C", 1)
+	tests := []struct {
+		name string
+		data string
+		want error
+	}{
+		{"flipped hex digit", flipped, ErrHashMismatch},
+		{"unsupported version", strings.Replace(testKeyFileV2, "2.0", "3.0", 1), ErrUnsupportedVersion},
+		{"invalid version", strings.Replace(testKeyFileV2, "2.0", "x", 1), ErrInvalidFormat},
+		{"invalid hex", strings.Replace(testKeyFileV2, "08090A0B", "08090A0X", 1), ErrInvalidFormat},
+		{"short key", strings.Replace(testKeyFileV1, "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=", "AAECAwQF", 1), ErrInvalidFormat},
+	}
+	for _, tt := range tests {
+		if _, err := Parse([]byte(tt.data)); err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, testKey()); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != testKeyFileV2 {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), testKeyFileV2)
+	}
+	if err := Write(&buf, testKey()[:16]); err == nil {
+		t.Error("short key: expected an error")
+	}
+}
+
+func TestGenerateRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Generate(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	pb, err := Load(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pb.Len() != KeySize {
+		t.Errorf("got a %d byte key, want %d", pb.Len(), KeySize)
+	}
+
+	var buf2 bytes.Buffer
+	if err := Generate(&buf2); err != nil {
+		t.Fatal(err)
+	}
+	pb2, err := Parse(buf2.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pb.Equal(pb2) {
+		t.Error("two generated key files have the same key")
+	}
+}
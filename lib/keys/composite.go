@@ -1,12 +1,99 @@
 package keys
 
-import "github.com/riking/go-keepass2/lib/kpcrypto"
+import (
+	"crypto/sha256"
+	"io"
 
+	"github.com/riking/go-keepass2/lib"
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+)
+
+// Composite is the master key of a database, made of one or more user key
+// components.
+//
+// file: KeePassLib/Keys/CompositeKey.cs
 type Composite struct {
+	userKeys []UserKey
+}
+
+// AddUserKey adds a component to the key. Components are kept in the order
+// that KeePass uses, so the order of the Add calls does not matter.
+//
+// file: KeePassLib/Keys/CompositeKey.cs
+// AddUserKey()
+func (ck *Composite) AddUserKey(k UserKey) {
+	order := userKeyOrder(k)
+	i := len(ck.userKeys)
+	for i > 0 && userKeyOrder(ck.userKeys[i-1]) > order {
+		i--
+	}
+	ck.userKeys = append(ck.userKeys, nil)
+	copy(ck.userKeys[i+1:], ck.userKeys[i:])
+	ck.userKeys[i] = k
+}
 
+// AddPassword adds a master password to the key.
+func (ck *Composite) AddPassword(password string) {
+	ck.AddUserKey(NewPasswordKey(password))
 }
 
-func (ck *Composite) GenerateKey32(seed *[32]byte, numRounds uint64) (kpcrypto.ProtectedBuffer, error) {
+// AddKeyFile adds the key file read from r to the key.
+func (ck *Composite) AddKeyFile(r io.Reader) error {
+	k, err := NewKeyFileKey(r)
+	if err != nil {
+		return err
+	}
+	ck.AddUserKey(k)
+	return nil
+}
+
+// AddCustomKey adds the key data from the named key provider to the key.
+func (ck *Composite) AddCustomKey(name string, data []byte) {
+	ck.AddUserKey(NewCustomKey(name, data))
+}
 
-	return nil, nil
-}
\ No newline at end of file
+// UserKeys returns the components of the key.
+func (ck *Composite) UserKeys() []UserKey {
+	return append([]UserKey(nil), ck.userKeys...)
+}
+
+// GenerateKey32 derives the 32-byte key from the composite key, using the
+// KDF and parameters in p.
+//
+// file: KeePassLib/Keys/CompositeKey.cs
+// GenerateKey32()
+func (ck *Composite) GenerateKey32(p *lib.VariantDictionary) (*kpcrypto.ProtectedBuffer, error) {
+	kdf := LookupKDF(KdfUUID(p))
+	if kdf == nil {
+		return nil, ErrUnknownKDF
+	}
+
+	raw := ck.rawKey32()
+	key, err := kdf.Transform(raw[:], p)
+	wipe(raw[:])
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		sum := sha256.Sum256(key)
+		wipe(key)
+		key = sum[:]
+	}
+	pb := kpcrypto.NewProtectedBuffer(key)
+	wipe(key)
+	return pb, nil
+}
+
+// rawKey32 hashes the user key components together.
+//
+// file: KeePassLib/Keys/CompositeKey.cs
+// CreateRawCompositeKey32()
+func (ck *Composite) rawKey32() [32]byte {
+	h := sha256.New()
+	for _, k := range ck.userKeys {
+		k.KeyData().WriteTo(h)
+	}
+	var raw [32]byte
+	h.Sum(raw[:0])
+	return raw
+}
@@ -0,0 +1,165 @@
+package keys
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/riking/go-keepass2/lib"
+	"github.com/satori/go.uuid"
+)
+
+// KdfUUIDAes identifies the AES-KDF.
+//
+// file: KeePassLib/Cryptography/KeyDerivation/AesKdf.cs
+// g_uuid
+var KdfUUIDAes = uuid.FromBytesOrNil([]byte{0xC9, 0xD9, 0xF3, 0x9A, 0x62, 0x8A, 0x44, 0x60, 0xBF, 0x74, 0x0D, 0x08, 0xC1, 0x8A, 0x4F, 0xEA})
+
+// Names of the AES-KDF parameters.
+//
+// file: KeePassLib/Cryptography/KeyDerivation/AesKdf.cs
+// ParamRounds, ParamSeed
+const (
+	AesKdfParamRounds = "R"
+	AesKdfParamSeed   = "S"
+)
+
+// DefaultKeyEncryptionRounds is the number of AES-KDF rounds used for new databases.
+//
+// File: KeePassLib/PwDefs.cs
+// DefaultKeyEncryptionRounds
+const DefaultKeyEncryptionRounds = 6000
+
+// AesKDF is the KDF used by KDBX 3.1: the key is encrypted with AES-256 in
+// ECB mode a number of times, using the seed as the AES key.
+//
+// file: KeePassLib/Cryptography/KeyDerivation/AesKdf.cs
+type AesKDF struct{}
+
+func (AesKDF) UUID() uuid.UUID {
+	return KdfUUIDAes
+}
+
+func (k AesKDF) DefaultParameters() *lib.VariantDictionary {
+	p := newKdfParameters(KdfUUIDAes)
+	p.Set(AesKdfParamRounds, uint64(DefaultKeyEncryptionRounds))
+	k.Randomize(p)
+	return p
+}
+
+func (AesKDF) Randomize(p *lib.VariantDictionary) {
+	seed := make([]byte, 32)
+	_, err := rand.Read(seed)
+	if err != nil {
+		panic(err)
+	}
+	p.Set(AesKdfParamSeed, seed)
+}
+
+// Transform runs the AES-KDF over msg.
+//
+// file: KeePassLib/Cryptography/KeyDerivation/AesKdf.cs
+// Transform(), TransformKey()
+func (AesKDF) Transform(msg []byte, p *lib.VariantDictionary) ([]byte, error) {
+	seed := p.GetByteArray(AesKdfParamSeed)
+	if len(seed) != 32 {
+		return nil, ErrBadKDFParameters
+	}
+	v, _ := p.Get(AesKdfParamRounds)
+	numRounds, ok := v.(uint64)
+	if !ok {
+		return nil, ErrBadKDFParameters
+	}
+
+	var key [32]byte
+	if len(msg) == 32 {
+		copy(key[:], msg)
+	} else {
+		key = sha256.Sum256(msg)
+	}
+
+	err := transformKey(&key, seed, numRounds)
+	if err != nil {
+		return nil, err
+	}
+
+	result := sha256.Sum256(key[:])
+	for i := range key {
+		key[i] = 0
+	}
+	return result[:], nil
+}
+
+// transformKey encrypts both halves of key numRounds times, in parallel.
+//
+// file: KeePassLib/Cryptography/KeyDerivation/AesKdf.cs
+// TransformKey()
+func transformKey(key *[32]byte, seed []byte, numRounds uint64) error {
+	// One cipher per goroutine, as cipher.Block is not documented to be
+	// safe for concurrent use
+	block1, err := aes.NewCipher(seed)
+	if err != nil {
+		return err
+	}
+	block2, err := aes.NewCipher(seed)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		encryptRounds(block2, key[16:32], numRounds)
+		wg.Done()
+	}()
+	encryptRounds(block1, key[0:16], numRounds)
+	wg.Wait()
+	return nil
+}
+
+func encryptRounds(block cipher.Block, half []byte, numRounds uint64) {
+	for i := uint64(0); i < numRounds; i++ {
+		block.Encrypt(half, half)
+	}
+}
+
+// TransformKeyBenchmark returns the number of AES-KDF rounds that take
+// about the target duration on this machine.
+//
+// file: KeePassLib/Cryptography/KeyDerivation/AesKdf.cs
+// TransformKeyBenchmark()
+func TransformKeyBenchmark(target time.Duration) uint64 {
+	const step = 1024
+	var seed [32]byte
+	var half [16]byte
+	block, err := aes.NewCipher(seed[:])
+	if err != nil {
+		panic(err)
+	}
+
+	// The two halves are transformed in parallel, so only one is timed
+	var numRounds uint64
+	start := time.Now()
+	for time.Since(start) < target {
+		encryptRounds(block, half[:], step)
+		numRounds += step
+	}
+	return numRounds
+}
+
+// GetBestParameters returns new parameters with a round count that takes
+// about the target duration on this machine.
+//
+// file: KeePassLib/Cryptography/KeyDerivation/AesKdf.cs
+// GetBestParameters()
+func (k AesKDF) GetBestParameters(target time.Duration) *lib.VariantDictionary {
+	p := k.DefaultParameters()
+	numRounds := TransformKeyBenchmark(target)
+	if numRounds > 0 {
+		p.Set(AesKdfParamRounds, numRounds)
+	}
+	return p
+}
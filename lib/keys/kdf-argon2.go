@@ -0,0 +1,114 @@
+package keys
+
+import (
+	"crypto/rand"
+	"math"
+
+	"github.com/riking/go-keepass2/lib"
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+	"github.com/satori/go.uuid"
+)
+
+// UUIDs of the Argon2 KDFs.
+//
+// file: KeePassLib/Cryptography/KeyDerivation/Argon2Kdf.cs
+// g_uuidD, g_uuidID
+var (
+	KdfUUIDArgon2d  = uuid.FromBytesOrNil([]byte{0xEF, 0x63, 0x6D, 0xDF, 0x8C, 0x29, 0x44, 0x4B, 0x91, 0xF7, 0xA9, 0xA4, 0x03, 0xE3, 0x0A, 0x0C})
+	KdfUUIDArgon2id = uuid.FromBytesOrNil([]byte{0x9E, 0x29, 0x8B, 0x19, 0x56, 0xDB, 0x47, 0x73, 0xB2, 0x3D, 0xFC, 0x3E, 0xC6, 0xF0, 0xA1, 0xE6})
+)
+
+// Names of the Argon2 parameters.
+//
+// file: KeePassLib/Cryptography/KeyDerivation/Argon2Kdf.cs
+// ParamSalt and friends
+const (
+	Argon2ParamSalt        = "S" // []byte
+	Argon2ParamParallelism = "P" // uint32
+	Argon2ParamMemory      = "M" // uint64, in bytes
+	Argon2ParamIterations  = "I" // uint64
+	Argon2ParamVersion     = "V" // uint32
+	Argon2ParamSecretKey   = "K" // []byte, optional
+	Argon2ParamAssocData   = "A" // []byte, optional
+)
+
+// Default and limit values of the Argon2 parameters.
+//
+// file: KeePassLib/Cryptography/KeyDerivation/Argon2Kdf.cs
+// DefaultIterations and friends
+const (
+	Argon2DefaultIterations  = 2
+	Argon2DefaultMemory      = 64 * 1024 * 1024
+	Argon2DefaultParallelism = 2
+
+	argon2MinSalt        = 8
+	argon2MinMemory      = 8 * 1024
+	argon2MaxMemory      = math.MaxUint32 * 1024
+	argon2MaxIterations  = math.MaxUint32
+	argon2MaxParallelism = (1 << 24) - 1
+)
+
+// Argon2KDF is the Argon2 key derivation function, in the Argon2d or
+// Argon2id variant.
+//
+// file: KeePassLib/Cryptography/KeyDerivation/Argon2Kdf.cs
+type Argon2KDF struct {
+	Variant kpcrypto.Argon2Type
+}
+
+func (k Argon2KDF) UUID() uuid.UUID {
+	if k.Variant == kpcrypto.Argon2id {
+		return KdfUUIDArgon2id
+	}
+	return KdfUUIDArgon2d
+}
+
+func (k Argon2KDF) DefaultParameters() *lib.VariantDictionary {
+	p := newKdfParameters(k.UUID())
+	p.Set(Argon2ParamVersion, uint32(kpcrypto.Argon2Version13))
+	p.Set(Argon2ParamIterations, uint64(Argon2DefaultIterations))
+	p.Set(Argon2ParamMemory, uint64(Argon2DefaultMemory))
+	p.Set(Argon2ParamParallelism, uint32(Argon2DefaultParallelism))
+	k.Randomize(p)
+	return p
+}
+
+func (Argon2KDF) Randomize(p *lib.VariantDictionary) {
+	salt := make([]byte, 32)
+	_, err := rand.Read(salt)
+	if err != nil {
+		panic(err)
+	}
+	p.Set(Argon2ParamSalt, salt)
+}
+
+// Transform runs Argon2 over msg, producing a 32-byte key.
+//
+// file: KeePassLib/Cryptography/KeyDerivation/Argon2Kdf.cs
+// Transform()
+func (k Argon2KDF) Transform(msg []byte, p *lib.VariantDictionary) ([]byte, error) {
+	version := p.GetUInt32(Argon2ParamVersion, 0)
+	if version < kpcrypto.Argon2Version10 || version > kpcrypto.Argon2Version13 {
+		return nil, ErrBadKDFParameters
+	}
+	salt := p.GetByteArray(Argon2ParamSalt)
+	if len(salt) < argon2MinSalt {
+		return nil, ErrBadKDFParameters
+	}
+	parallelism := p.GetUInt32(Argon2ParamParallelism, 0)
+	if parallelism < 1 || parallelism > argon2MaxParallelism {
+		return nil, ErrBadKDFParameters
+	}
+	memory := p.GetUInt64(Argon2ParamMemory, 0)
+	if memory < argon2MinMemory || memory > argon2MaxMemory {
+		return nil, ErrBadKDFParameters
+	}
+	iterations := p.GetUInt64(Argon2ParamIterations, 0)
+	if iterations < 1 || iterations > argon2MaxIterations {
+		return nil, ErrBadKDFParameters
+	}
+
+	return kpcrypto.Argon2Key(k.Variant, version, msg, salt,
+		p.GetByteArray(Argon2ParamSecretKey), p.GetByteArray(Argon2ParamAssocData),
+		uint32(iterations), uint32(memory/1024), parallelism, 32), nil
+}
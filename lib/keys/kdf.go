@@ -0,0 +1,83 @@
+package keys
+
+import (
+	"errors"
+
+	"github.com/riking/go-keepass2/lib"
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+	"github.com/satori/go.uuid"
+)
+
+// KDF is a key derivation function, used to transform the raw composite key
+// into the key that the database is encrypted with.
+//
+// The parameters of a KDF are stored in a VariantDictionary, which also
+// holds the UUID of the KDF under ParamUUID.
+//
+// file: KeePassLib/Cryptography/KeyDerivation/KdfEngine.cs
+type KDF interface {
+	// UUID returns the identifier of the KDF.
+	UUID() uuid.UUID
+	// DefaultParameters returns a new set of parameters with the default
+	// settings and a random seed.
+	DefaultParameters() *lib.VariantDictionary
+	// Randomize replaces the seed or salt in p with new random data.
+	Randomize(p *lib.VariantDictionary)
+	// Transform derives a key from msg.
+	Transform(msg []byte, p *lib.VariantDictionary) ([]byte, error)
+}
+
+// ParamUUID is the name of the KDF parameter holding the KDF UUID.
+//
+// file: KeePassLib/Cryptography/KeyDerivation/KdfParameters.cs
+// ParamUuid
+const ParamUUID = "$UUID"
+
+var (
+	ErrUnknownKDF       = errors.New("keepass: unknown key derivation function")
+	ErrBadKDFParameters = errors.New("keepass: invalid key derivation function parameters")
+)
+
+// file: KeePassLib/Cryptography/KeyDerivation/KdfPool.cs
+var kdfPool = make(map[uuid.UUID]KDF)
+
+func init() {
+	RegisterKDF(AesKDF{})
+	RegisterKDF(Argon2KDF{Variant: kpcrypto.Argon2d})
+	RegisterKDF(Argon2KDF{Variant: kpcrypto.Argon2id})
+}
+
+// RegisterKDF makes a KDF available to LookupKDF, replacing any KDF with
+// the same UUID. It is not safe to call concurrently with LookupKDF.
+//
+// file: KeePassLib/Cryptography/KeyDerivation/KdfPool.cs
+// Add()
+func RegisterKDF(kdf KDF) {
+	kdfPool[kdf.UUID()] = kdf
+}
+
+// LookupKDF returns the registered KDF with the given UUID, or nil.
+//
+// file: KeePassLib/Cryptography/KeyDerivation/KdfPool.cs
+// Get()
+func LookupKDF(id uuid.UUID) KDF {
+	return kdfPool[id]
+}
+
+// KdfUUID returns the UUID of the KDF that the parameters are for.
+//
+// file: KeePassLib/Cryptography/KeyDerivation/KdfParameters.cs
+// KdfUuid
+func KdfUUID(p *lib.VariantDictionary) uuid.UUID {
+	if p == nil {
+		return uuid.Nil
+	}
+	return uuid.FromBytesOrNil(p.GetByteArray(ParamUUID))
+}
+
+// newKdfParameters creates an empty parameter set for the given KDF.
+func newKdfParameters(id uuid.UUID) *lib.VariantDictionary {
+	p := lib.NewVariantDictionary()
+	p.Set(ParamUUID, id.Bytes())
+	return p
+}
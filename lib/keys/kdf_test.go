@@ -0,0 +1,143 @@
+package keys
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/riking/go-keepass2/lib"
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+	"golang.org/x/crypto/argon2"
+)
+
+// aesKdfReference is a straightforward sequential AES-KDF.
+func aesKdfReference(msg, seed []byte, rounds uint64) []byte {
+	key := msg
+	if len(key) != 32 {
+		sum := sha256.Sum256(msg)
+		key = sum[:]
+	}
+	key = append([]byte(nil), key...)
+	block, _ := aes.NewCipher(seed)
+	for i := uint64(0); i < rounds; i++ {
+		block.Encrypt(key[0:16], key[0:16])
+		block.Encrypt(key[16:32], key[16:32])
+	}
+	sum := sha256.Sum256(key)
+	return sum[:]
+}
+
+func TestAesKDF(t *testing.T) {
+	seed := bytes.Repeat([]byte{0x5A}, 32)
+	tests := []struct {
+		msg    []byte
+		rounds uint64
+	}{
+		{bytes.Repeat([]byte{0x01}, 32), 0},
+		{bytes.Repeat([]byte{0x01}, 32), 1},
+		{bytes.Repeat([]byte{0x02}, 32), 6000},
+		{[]byte("not 32 bytes long"), 100},
+	}
+	for _, tt := range tests {
+		p := newKdfParameters(KdfUUIDAes)
+		p.Set(AesKdfParamSeed, seed)
+		p.Set(AesKdfParamRounds, tt.rounds)
+		got, err := AesKDF{}.Transform(tt.msg, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := aesKdfReference(tt.msg, seed, tt.rounds); !bytes.Equal(got, want) {
+			t.Errorf("rounds=%d: got %x, want %x", tt.rounds, got, want)
+		}
+	}
+}
+
+func TestAesKDFBadParameters(t *testing.T) {
+	p := newKdfParameters(KdfUUIDAes)
+	p.Set(AesKdfParamSeed, []byte("short"))
+	p.Set(AesKdfParamRounds, uint64(1))
+	if _, err := (AesKDF{}).Transform(make([]byte, 32), p); err != ErrBadKDFParameters {
+		t.Errorf("got %v, want ErrBadKDFParameters", err)
+	}
+}
+
+func argon2Parameters(k Argon2KDF, salt []byte, iterations, memory uint64, parallelism uint32) *lib.VariantDictionary {
+	p := newKdfParameters(k.UUID())
+	p.Set(Argon2ParamVersion, uint32(kpcrypto.Argon2Version13))
+	p.Set(Argon2ParamSalt, salt)
+	p.Set(Argon2ParamIterations, iterations)
+	p.Set(Argon2ParamMemory, memory)
+	p.Set(Argon2ParamParallelism, parallelism)
+	return p
+}
+
+// TestArgon2KDF checks Argon2id against the independent implementation in
+// golang.org/x/crypto/argon2.
+func TestArgon2KDF(t *testing.T) {
+	msg := bytes.Repeat([]byte{0x11}, 32)
+	salt := bytes.Repeat([]byte{0x22}, 32)
+	tests := []struct {
+		iterations  uint64
+		memoryKiB   uint32
+		parallelism uint32
+	}{
+		{1, 64, 1},
+		{2, 1024, 2},
+		{3, 256, 4},
+	}
+	for _, tt := range tests {
+		k := Argon2KDF{Variant: kpcrypto.Argon2id}
+		p := argon2Parameters(k, salt, tt.iterations, uint64(tt.memoryKiB)*1024, tt.parallelism)
+		got, err := k.Transform(msg, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := argon2.IDKey(msg, salt, uint32(tt.iterations), tt.memoryKiB, uint8(tt.parallelism), 32)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%+v: got %x, want %x", tt, got, want)
+		}
+	}
+}
+
+// TestArgon2dKDF checks the Argon2d KDF, with the optional secret key and
+// associated data, against the specification test vectors.
+func TestArgon2dKDF(t *testing.T) {
+	tests := []struct {
+		version uint32
+		want    string
+	}{
+		{kpcrypto.Argon2Version13, "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb"},
+		{kpcrypto.Argon2Version10, "96a9d4e5a1734092c85e29f410a45914a5dd1f5cbf08b2670da68a0285abf32b"},
+	}
+	for _, tt := range tests {
+		k := Argon2KDF{Variant: kpcrypto.Argon2d}
+		p := argon2Parameters(k, bytes.Repeat([]byte{0x02}, 16), 3, 32*1024, 4)
+		p.Set(Argon2ParamVersion, tt.version)
+		p.Set(Argon2ParamSecretKey, bytes.Repeat([]byte{0x03}, 8))
+		p.Set(Argon2ParamAssocData, bytes.Repeat([]byte{0x04}, 12))
+		got, err := k.Transform(bytes.Repeat([]byte{0x01}, 32), p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("version %#x: got %x, want %s", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestArgon2KDFBadParameters(t *testing.T) {
+	k := Argon2KDF{Variant: kpcrypto.Argon2d}
+	tests := []*lib.VariantDictionary{
+		argon2Parameters(k, []byte("short"), 1, 64*1024, 1),
+		argon2Parameters(k, make([]byte, 32), 0, 64*1024, 1),
+		argon2Parameters(k, make([]byte, 32), 1, 1024, 1),
+		argon2Parameters(k, make([]byte, 32), 1, 64*1024, 0),
+	}
+	for i, p := range tests {
+		if _, err := k.Transform(make([]byte, 32), p); err != ErrBadKDFParameters {
+			t.Errorf("%d: got %v, want ErrBadKDFParameters", i, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package keys
+
+import (
+	"crypto/sha256"
+	"io"
+
+	"github.com/riking/go-keepass2/lib/keyfile"
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+)
+
+// UserKey is one component of a Composite key.
+//
+// file: KeePassLib/Keys/IUserKey.cs
+type UserKey interface {
+	// KeyData returns the 32-byte hash of the component.
+	KeyData() *kpcrypto.ProtectedBuffer
+}
+
+// PasswordKey is a master password.
+//
+// file: KeePassLib/Keys/KcpPassword.cs
+type PasswordKey struct {
+	keyData *kpcrypto.ProtectedBuffer
+}
+
+// NewPasswordKey creates a PasswordKey from the password.
+func NewPasswordKey(password string) *PasswordKey {
+	h := sha256.Sum256([]byte(password))
+	k := &PasswordKey{keyData: kpcrypto.NewProtectedBuffer(h[:])}
+	wipe(h[:])
+	return k
+}
+
+func (k *PasswordKey) KeyData() *kpcrypto.ProtectedBuffer {
+	return k.keyData
+}
+
+// KeyFileKey is a key file.
+//
+// file: KeePassLib/Keys/KcpKeyFile.cs
+type KeyFileKey struct {
+	keyData *kpcrypto.ProtectedBuffer
+}
+
+// NewKeyFileKey reads a key file from r, see keyfile.Load.
+//
+// file: KeePassLib/Keys/KcpKeyFile.cs
+// Construct()
+func NewKeyFileKey(r io.Reader) (*KeyFileKey, error) {
+	keyData, err := keyfile.Load(r)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyFileKey{keyData: keyData}, nil
+}
+
+func (k *KeyFileKey) KeyData() *kpcrypto.ProtectedBuffer {
+	return k.keyData
+}
+
+// CustomKey is key data from a key provider, such as a hardware token.
+//
+// file: KeePassLib/Keys/KcpCustomKey.cs
+type CustomKey struct {
+	// Name is the name of the key provider.
+	Name    string
+	keyData *kpcrypto.ProtectedBuffer
+}
+
+// NewCustomKey creates a CustomKey. The data is hashed with SHA-256.
+func NewCustomKey(name string, data []byte) *CustomKey {
+	h := sha256.Sum256(data)
+	k := &CustomKey{Name: name, keyData: kpcrypto.NewProtectedBuffer(h[:])}
+	wipe(h[:])
+	return k
+}
+
+func (k *CustomKey) KeyData() *kpcrypto.ProtectedBuffer {
+	return k.keyData
+}
+
+// userKeyOrder returns the position of a component type in a composite key.
+// KeePass always adds the password first, followed by the key file or the
+// key provider.
+//
+// file: KeePass/Forms/KeyPromptForm.cs
+// CreateCompositeKey()
+func userKeyOrder(k UserKey) int {
+	switch k.(type) {
+	case *PasswordKey:
+		return 0
+	case *KeyFileKey, *CustomKey:
+		return 1
+	}
+	return 2
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -3,6 +3,7 @@ package kpcrypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"errors"
 	"io"
 )
 
@@ -20,9 +21,15 @@ func NewAES256_CBC_PCKS7_Encoder(w io.Writer, key *[32]byte, iv *[16]byte) (io.W
 		return nil, err
 	}
 
-	blockMode := cipher.NewCBCEncrypter(block, iv[:])
+	return NewCBC_PCKS7_Encoder(w, block, iv[:]), nil
+}
+
+// NewCBC_PCKS7_Encoder encrypts the written data with the block cipher in CBC
+// mode. Close adds the PCKS#7 padding; it does not close w.
+func NewCBC_PCKS7_Encoder(w io.Writer, block cipher.Block, iv []byte) io.WriteCloser {
+	blockMode := cipher.NewCBCEncrypter(block, iv)
 
-	return keepassEncoder{writer: w, blocker: blockMode, buffer: make([]byte, 0, 2048)}
+	return &keepassEncoder{writer: w, blocker: blockMode, buffer: make([]byte, 0, 2048)}
 }
 
 func (ke *keepassEncoder) Write(in []byte) (n int, err error) {
@@ -33,7 +40,7 @@ func (ke *keepassEncoder) Write(in []byte) (n int, err error) {
 	cur := len(ke.buffer)
 	max := cap(ke.buffer)
 
-	if cur + inBytesRemaining < max {
+	if cur+inBytesRemaining < max {
 		newLen := cur + inBytesRemaining
 		ke.buffer = ke.buffer[:newLen]
 		copy(ke.buffer[cur:newLen], in)
@@ -41,6 +48,7 @@ func (ke *keepassEncoder) Write(in []byte) (n int, err error) {
 	}
 
 	fillBytes := max - cur
+	ke.buffer = ke.buffer[:max]
 	copy(ke.buffer[cur:max], in[:fillBytes])
 	n, err = ke.flushFullBuffer()
 	if err != nil {
@@ -76,7 +84,7 @@ func (ke *keepassEncoder) Write(in []byte) (n int, err error) {
 }
 
 func (ke *keepassEncoder) Close() error {
-	if ke.stickErr {
+	if ke.stickErr != nil {
 		return ke.stickErr
 	}
 
@@ -84,7 +92,7 @@ func (ke *keepassEncoder) Close() error {
 
 	bufLen := len(ke.buffer)
 	bs := ke.blocker.BlockSize()
-	endOfFullBlocks := int(bufLen / bs) * bs
+	endOfFullBlocks := int(bufLen/bs) * bs
 	// Invariant: buffer is not full.
 	// Therefore, we can add another block on the end if the last one is full.
 	padEnd := endOfFullBlocks + bs
@@ -95,10 +103,10 @@ func (ke *keepassEncoder) Close() error {
 	padContent := padEnd - bufLen
 	// Apply padding
 	for i := 0; i < padContent; i++ {
-		ke.buffer[bufLen+i] = padContent
+		ke.buffer[bufLen+i] = byte(padContent)
 	}
 
-	if ke.buffer[padEnd-1] != padContent {
+	if ke.buffer[padEnd-1] != byte(padContent) {
 		panic("keepassEncoder.Close: failed to apply PCKS7 padding")
 	}
 
@@ -130,4 +138,97 @@ func (ke *keepassEncoder) flushFullBuffer() (int, error) {
 		}
 	}
 	return offset, nil
-}
\ No newline at end of file
+}
+
+// ErrBadPadding is returned by the decoder when the PCKS#7 padding at the end
+// of the stream is invalid. This usually means that the key is wrong.
+var ErrBadPadding = errors.New("kpcrypto: invalid PCKS#7 padding")
+
+// keepassDecoder removes PCKS#7 padding from the output of a cipher.BlockMode.
+type keepassDecoder struct {
+	reader   io.Reader
+	blocker  cipher.BlockMode
+	buffer   []byte
+	plain    []byte
+	eof      bool
+	stickErr error
+}
+
+func NewAES256_CBC_PCKS7_Decoder(r io.Reader, key *[32]byte, iv *[16]byte) (io.Reader, error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCBC_PCKS7_Decoder(r, block, iv[:]), nil
+}
+
+// NewCBC_PCKS7_Decoder decrypts data encrypted by a NewCBC_PCKS7_Encoder.
+func NewCBC_PCKS7_Decoder(r io.Reader, block cipher.Block, iv []byte) io.Reader {
+	blockMode := cipher.NewCBCDecrypter(block, iv)
+
+	return &keepassDecoder{reader: r, blocker: blockMode, buffer: make([]byte, 0, 2048+blockMode.BlockSize())}
+}
+
+func (kd *keepassDecoder) Read(p []byte) (n int, err error) {
+	for len(kd.plain) == 0 {
+		if kd.stickErr != nil {
+			return 0, kd.stickErr
+		}
+		kd.stickErr = kd.fill()
+	}
+
+	n = copy(p, kd.plain)
+	kd.plain = kd.plain[n:]
+	return n, nil
+}
+
+// fill decrypts the next chunk of the stream into kd.plain.
+// The last block read is always held back until the end of the stream is
+// seen, so that the padding can be removed.
+func (kd *keepassDecoder) fill() error {
+	if kd.eof {
+		return io.EOF
+	}
+
+	bs := kd.blocker.BlockSize()
+	bufLen := len(kd.buffer)
+	if bufLen == cap(kd.buffer) {
+		// Move the held-back block to the front
+		copy(kd.buffer[0:bs], kd.buffer[bufLen-bs:bufLen])
+		bufLen = bs
+	}
+
+	kd.buffer = kd.buffer[:cap(kd.buffer)]
+	n, err := io.ReadFull(kd.reader, kd.buffer[bufLen:])
+	bufLen += n
+	kd.buffer = kd.buffer[:bufLen]
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		kd.eof = true
+	} else if err != nil {
+		return err
+	}
+
+	if !kd.eof {
+		kd.blocker.CryptBlocks(kd.buffer[:bufLen-bs], kd.buffer[:bufLen-bs])
+		kd.plain = kd.buffer[:bufLen-bs]
+		return nil
+	}
+
+	// End of stream: decrypt the rest, then strip the padding.
+	if bufLen == 0 || bufLen%bs != 0 {
+		return io.ErrUnexpectedEOF
+	}
+	kd.blocker.CryptBlocks(kd.buffer, kd.buffer)
+	padContent := int(kd.buffer[bufLen-1])
+	if padContent == 0 || padContent > bs {
+		return ErrBadPadding
+	}
+	for i := bufLen - padContent; i < bufLen; i++ {
+		if int(kd.buffer[i]) != padContent {
+			return ErrBadPadding
+		}
+	}
+	kd.plain = kd.buffer[:bufLen-padContent]
+	return nil
+}
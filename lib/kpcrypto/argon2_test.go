@@ -0,0 +1,35 @@
+package kpcrypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Test vectors from RFC 9106, section 5, and for version 1.0 from the
+// reference implementation.
+func TestArgon2KeyVectors(t *testing.T) {
+	password := bytes.Repeat([]byte{0x01}, 32)
+	salt := bytes.Repeat([]byte{0x02}, 16)
+	secret := bytes.Repeat([]byte{0x03}, 8)
+	data := bytes.Repeat([]byte{0x04}, 12)
+
+	tests := []struct {
+		name    string
+		mode    Argon2Type
+		version uint32
+		want    string
+	}{
+		{"Argon2d", Argon2d, Argon2Version13, "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb"},
+		{"Argon2i", Argon2i, Argon2Version13, "c814d9d1dc7f37aa13f0d77f2494bda1c8de6b016dd388d29952a4c4672b6ce8"},
+		{"Argon2id", Argon2id, Argon2Version13, "0d640df58d78766c08c037a34a8b53c9d01ef0452d75b65eb52520e96b01e659"},
+		{"Argon2d v1.0", Argon2d, Argon2Version10, "96a9d4e5a1734092c85e29f410a45914a5dd1f5cbf08b2670da68a0285abf32b"},
+		{"Argon2i v1.0", Argon2i, Argon2Version10, "87aeedd6517ab830cd9765cd8231abb2e647a5dee08f7c05e02fcb763335d0fd"},
+	}
+	for _, tt := range tests {
+		got := Argon2Key(tt.mode, tt.version, password, salt, secret, data, 3, 32, 4, 32)
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("%s: got %x, want %s", tt.name, got, tt.want)
+		}
+	}
+}
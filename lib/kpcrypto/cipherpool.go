@@ -0,0 +1,54 @@
+package kpcrypto
+
+import (
+	"io"
+
+	"github.com/satori/go.uuid"
+)
+
+// CipherEngine is a cipher that can be used to encrypt the database payload.
+//
+// file: KeePassLib/Cryptography/Cipher/ICipherEngine.cs
+// ICipherEngine, ICipherEngine2
+type CipherEngine interface {
+	// UUID returns the identifier of the cipher, as stored in the file header.
+	UUID() uuid.UUID
+	DisplayName() string
+	// KeyLength and IVLength return the sizes in bytes of the key and the
+	// initialization vector.
+	KeyLength() int
+	IVLength() int
+
+	// EncryptStream returns a writer that encrypts the written data to w.
+	// Closing it finishes the encryption but does not close w.
+	EncryptStream(w io.Writer, key, iv []byte) (io.WriteCloser, error)
+	// DecryptStream returns a reader that decrypts the data read from r.
+	DecryptStream(r io.Reader, key, iv []byte) (io.Reader, error)
+}
+
+// file: KeePassLib/Cryptography/Cipher/CipherPool.cs
+var cipherPool = make(map[uuid.UUID]CipherEngine)
+
+func init() {
+	RegisterCipher(aesEngine{})
+	RegisterCipher(chaCha20Engine{})
+	RegisterCipher(twofishEngine{})
+}
+
+// RegisterCipher makes a cipher available to LookupCipher, replacing any
+// cipher with the same UUID. It is not safe to call concurrently with
+// LookupCipher.
+//
+// file: KeePassLib/Cryptography/Cipher/CipherPool.cs
+// AddCipher()
+func RegisterCipher(ce CipherEngine) {
+	cipherPool[ce.UUID()] = ce
+}
+
+// LookupCipher returns the registered cipher with the given UUID, or nil.
+//
+// file: KeePassLib/Cryptography/Cipher/CipherPool.cs
+// GetCipher()
+func LookupCipher(id uuid.UUID) CipherEngine {
+	return cipherPool[id]
+}
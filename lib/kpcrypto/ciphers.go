@@ -0,0 +1,136 @@
+package kpcrypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"io"
+
+	"github.com/satori/go.uuid"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/twofish"
+)
+
+// UUIDs of the built-in ciphers.
+//
+// file: KeePassLib/Cryptography/Cipher/StandardAesEngine.cs, ChaCha20Engine.cs
+// g_uuidAes, g_uuidChaCha20; TwofishCipher plugin
+var (
+	CipherUUIDAes      = uuid.FromBytesOrNil([]byte{0x31, 0xC1, 0xF2, 0xE6, 0xBF, 0x71, 0x43, 0x50, 0xBE, 0x58, 0x05, 0x21, 0x6A, 0xFC, 0x5A, 0xFF})
+	CipherUUIDChaCha20 = uuid.FromBytesOrNil([]byte{0xD6, 0x03, 0x8A, 0x2B, 0x8B, 0x6F, 0x4C, 0xB5, 0xA5, 0x24, 0x33, 0x9A, 0x31, 0xDB, 0xB5, 0x9A})
+	CipherUUIDTwofish  = uuid.FromBytesOrNil([]byte{0xAD, 0x68, 0xF2, 0x9F, 0x57, 0x6F, 0x4B, 0xB9, 0xA3, 0x6A, 0xD4, 0x7A, 0xF9, 0x65, 0x34, 0x6C})
+)
+
+// ErrBadKeyLength is returned by a CipherEngine when the key or IV has the
+// wrong size.
+var ErrBadKeyLength = errors.New("kpcrypto: invalid key or IV length")
+
+// aesEngine is AES-256 in CBC mode with PCKS#7 padding.
+//
+// file: KeePassLib/Cryptography/Cipher/StandardAesEngine.cs
+type aesEngine struct{}
+
+func (aesEngine) UUID() uuid.UUID     { return CipherUUIDAes }
+func (aesEngine) DisplayName() string { return "AES/Rijndael (256-Bit Key)" }
+func (aesEngine) KeyLength() int      { return 32 }
+func (aesEngine) IVLength() int       { return aes.BlockSize }
+
+func (e aesEngine) EncryptStream(w io.Writer, key, iv []byte) (io.WriteCloser, error) {
+	if len(key) != e.KeyLength() || len(iv) != e.IVLength() {
+		return nil, ErrBadKeyLength
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return NewCBC_PCKS7_Encoder(w, block, iv), nil
+}
+
+func (e aesEngine) DecryptStream(r io.Reader, key, iv []byte) (io.Reader, error) {
+	if len(key) != e.KeyLength() || len(iv) != e.IVLength() {
+		return nil, ErrBadKeyLength
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return NewCBC_PCKS7_Decoder(r, block, iv), nil
+}
+
+// chaCha20Engine is the ChaCha20 stream cipher with a 96-bit nonce. It can
+// only be used in KDBX 4 files.
+//
+// file: KeePassLib/Cryptography/Cipher/ChaCha20Engine.cs
+type chaCha20Engine struct{}
+
+func (chaCha20Engine) UUID() uuid.UUID     { return CipherUUIDChaCha20 }
+func (chaCha20Engine) DisplayName() string { return "ChaCha20 (256-Bit Key, RFC 7539)" }
+func (chaCha20Engine) KeyLength() int      { return chacha20.KeySize }
+func (chaCha20Engine) IVLength() int       { return chacha20.NonceSize }
+
+func (e chaCha20Engine) EncryptStream(w io.Writer, key, iv []byte) (io.WriteCloser, error) {
+	if len(key) != e.KeyLength() || len(iv) != e.IVLength() {
+		return nil, ErrBadKeyLength
+	}
+	c, err := chacha20.NewUnauthenticatedCipher(key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &streamEncoder{cipher.StreamWriter{S: c, W: w}}, nil
+}
+
+func (e chaCha20Engine) DecryptStream(r io.Reader, key, iv []byte) (io.Reader, error) {
+	if len(key) != e.KeyLength() || len(iv) != e.IVLength() {
+		return nil, ErrBadKeyLength
+	}
+	c, err := chacha20.NewUnauthenticatedCipher(key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &cipher.StreamReader{S: c, R: r}, nil
+}
+
+// streamEncoder is a cipher.StreamWriter whose Close does not close the
+// underlying writer.
+type streamEncoder struct {
+	sw cipher.StreamWriter
+}
+
+func (se *streamEncoder) Write(p []byte) (n int, err error) {
+	return se.sw.Write(p)
+}
+
+func (se *streamEncoder) Close() error {
+	return nil
+}
+
+// twofishEngine is Twofish in CBC mode with PCKS#7 padding, as written by
+// the TwofishCipher plugin for KeePass.
+type twofishEngine struct{}
+
+func (twofishEngine) UUID() uuid.UUID     { return CipherUUIDTwofish }
+func (twofishEngine) DisplayName() string { return "Twofish (256-Bit Key)" }
+func (twofishEngine) KeyLength() int      { return 32 }
+func (twofishEngine) IVLength() int       { return twofish.BlockSize }
+
+func (e twofishEngine) EncryptStream(w io.Writer, key, iv []byte) (io.WriteCloser, error) {
+	if len(key) != e.KeyLength() || len(iv) != e.IVLength() {
+		return nil, ErrBadKeyLength
+	}
+	block, err := twofish.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return NewCBC_PCKS7_Encoder(w, block, iv), nil
+}
+
+func (e twofishEngine) DecryptStream(r io.Reader, key, iv []byte) (io.Reader, error) {
+	if len(key) != e.KeyLength() || len(iv) != e.IVLength() {
+		return nil, ErrBadKeyLength
+	}
+	block, err := twofish.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return NewCBC_PCKS7_Decoder(r, block, iv), nil
+}
@@ -0,0 +1,281 @@
+package kpcrypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/satori/go.uuid"
+	"golang.org/x/crypto/twofish"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func xored(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	xorBytes(out, a, b)
+	return out
+}
+
+func encryptAll(t *testing.T, ce CipherEngine, key, iv, plain []byte) []byte {
+	var buf bytes.Buffer
+	w, err := ce.EncryptStream(&buf, key, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decryptAll(ce CipherEngine, key, iv, ciphertext []byte) ([]byte, error) {
+	r, err := ce.DecryptStream(bytes.NewReader(ciphertext), key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestLookupCipher(t *testing.T) {
+	for _, id := range []uuid.UUID{CipherUUIDAes, CipherUUIDChaCha20, CipherUUIDTwofish} {
+		ce := LookupCipher(id)
+		if ce == nil {
+			t.Errorf("LookupCipher(%v) = nil", id)
+			continue
+		}
+		if ce.UUID() != id {
+			t.Errorf("LookupCipher(%v).UUID() = %v", id, ce.UUID())
+		}
+		if _, err := ce.EncryptStream(ioutil.Discard, make([]byte, ce.KeyLength()-1), make([]byte, ce.IVLength())); err != ErrBadKeyLength {
+			t.Errorf("%s: short key: got %v, want ErrBadKeyLength", ce.DisplayName(), err)
+		}
+		if _, err := ce.DecryptStream(bytes.NewReader(nil), make([]byte, ce.KeyLength()), make([]byte, ce.IVLength()+1)); err != ErrBadKeyLength {
+			t.Errorf("%s: long IV: got %v, want ErrBadKeyLength", ce.DisplayName(), err)
+		}
+	}
+	if ce := LookupCipher(uuid.Nil); ce != nil {
+		t.Errorf("LookupCipher(Nil) = %v, want nil", ce)
+	}
+}
+
+// CBC-AES256 vector from NIST SP 800-38A, section F.2.5. The padding block
+// appended by the encoder is not part of the vector.
+func TestAesEngineVector(t *testing.T) {
+	key := mustHex(t, "603deb1015ca71be2b73aef0857d77811f352c073b6108d72d9810a30914dff4")
+	iv := mustHex(t, "000102030405060708090a0b0c0d0e0f")
+	plain := mustHex(t, "6bc1bee22e409f96e93d7e117393172a"+
+		"ae2d8a571e03ac9c9eb76fac45af8e51"+
+		"30c81c46a35ce411e5fbc1191a0a52ef"+
+		"f69f2445df4f9b17ad2b417be66c3710")
+	want := mustHex(t, "f58c4c04d6e5f1ba779eabfb5f7bfbd6"+
+		"9cfc4e967edb808d679f777bc6702c7d"+
+		"39f23369a9d9bacfa530e26304231461"+
+		"b2eb05e2c39be9fcda6c19078c6a9d1b")
+
+	ce := LookupCipher(CipherUUIDAes)
+	got := encryptAll(t, ce, key, iv, plain)
+	if len(got) != len(plain)+aes.BlockSize {
+		t.Fatalf("got %d bytes, want %d", len(got), len(plain)+aes.BlockSize)
+	}
+	if !bytes.Equal(got[:len(want)], want) {
+		t.Errorf("got %x, want %x", got[:len(want)], want)
+	}
+	dec, err := decryptAll(ce, key, iv, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dec, plain) {
+		t.Errorf("decrypted %x, want %x", dec, plain)
+	}
+}
+
+// Twofish vector from LibTom (also used by golang.org/x/crypto/twofish).
+// The plaintext is arranged so that each CBC block enters the cipher as the
+// vector's plaintext, so every ciphertext block must equal the vector's
+// ciphertext.
+func TestTwofishEngineVector(t *testing.T) {
+	key := mustHex(t, "d43bb7556ea32e46f2a282b7d45b4e0d57ff739d4dc92c1bd7fc01700cc8216f")
+	block := mustHex(t, "90afe91bb288544f2c32dc239b2635e6")
+	want := mustHex(t, "6cb4561c40bf0a9705931cb6d408e7fa")
+	iv := mustHex(t, "000102030405060708090a0b0c0d0e0f")
+
+	var plain []byte
+	plain = append(plain, xored(block, iv)...)
+	plain = append(plain, xored(block, want)...)
+	plain = append(plain, xored(block, want)...)
+
+	ce := LookupCipher(CipherUUIDTwofish)
+	got := encryptAll(t, ce, key, iv, plain)
+	if len(got) != len(plain)+twofish.BlockSize {
+		t.Fatalf("got %d bytes, want %d", len(got), len(plain)+twofish.BlockSize)
+	}
+	for i := 0; i < len(plain); i += twofish.BlockSize {
+		if !bytes.Equal(got[i:i+twofish.BlockSize], want) {
+			t.Errorf("block %d: got %x, want %x", i/twofish.BlockSize, got[i:i+twofish.BlockSize], want)
+		}
+	}
+	dec, err := decryptAll(ce, key, iv, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dec, plain) {
+		t.Errorf("decrypted %x, want %x", dec, plain)
+	}
+}
+
+// ChaCha20 vectors from RFC 8439. The engine starts at block counter 0, so
+// the section 2.4.2 vector (which starts at counter 1) is preceded by one
+// block of zeros.
+func TestChaCha20EngineVectors(t *testing.T) {
+	const sunscreen = "Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it."
+
+	tests := []struct {
+		name   string
+		key    string
+		nonce  string
+		plain  []byte
+		offset int
+		want   string
+	}{
+		{
+			"A.2 #1",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"000000000000000000000000",
+			make([]byte, 64),
+			0,
+			"76b8e0ada0f13d90405d6ae55386bd28bdd219b8a08ded1aa836efcc8b770dc7" +
+				"da41597c5157488d7724e03fb8d84a376a43b8f41518a11cc387b669b2ee6586",
+		},
+		{
+			"2.4.2",
+			"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+			"000000000000004a00000000",
+			append(make([]byte, 64), sunscreen...),
+			64,
+			"6e2e359a2568f98041ba0728dd0d6981e97e7aec1d4360c20a27afccfd9fae0b" +
+				"f91b65c5524733ab8f593dabcd62b3571639d624e65152ab8f530c359f0861d8" +
+				"07ca0dbf500d6a6156a38e088a22b65e52bc514d16ccf806818ce91ab7793736" +
+				"5af90bbf74a35be6b40b8eedf2785e42874d",
+		},
+	}
+
+	ce := LookupCipher(CipherUUIDChaCha20)
+	for _, tt := range tests {
+		key, nonce, want := mustHex(t, tt.key), mustHex(t, tt.nonce), mustHex(t, tt.want)
+		got := encryptAll(t, ce, key, nonce, tt.plain)
+		if len(got) != len(tt.plain) {
+			t.Errorf("%s: got %d bytes, want %d", tt.name, len(got), len(tt.plain))
+			continue
+		}
+		if !bytes.Equal(got[tt.offset:], want) {
+			t.Errorf("%s: got %x, want %x", tt.name, got[tt.offset:], want)
+		}
+		dec, err := decryptAll(ce, key, nonce, got)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !bytes.Equal(dec, tt.plain) {
+			t.Errorf("%s: decrypted %x, want %x", tt.name, dec, tt.plain)
+		}
+	}
+}
+
+// The encoder must always add 1 to 16 bytes of padding, including a full
+// block when the input is already aligned.
+func TestPCKS7EncoderPadding(t *testing.T) {
+	key := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []int{0, 1, 15, 16, 17, 31, 32, 2047, 2048, 2049, 5000} {
+		plain := bytes.Repeat([]byte{0xA5}, n)
+		var buf bytes.Buffer
+		w := NewCBC_PCKS7_Encoder(&buf, block, iv)
+		// Write in uneven pieces to exercise the internal buffering
+		for i := 0; i < n; i += 7 {
+			end := i + 7
+			if end > n {
+				end = n
+			}
+			if _, err := w.Write(plain[i:end]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		out := buf.Bytes()
+		pad := aes.BlockSize - n%aes.BlockSize
+		if len(out) != n+pad {
+			t.Errorf("n=%d: got %d bytes, want %d", n, len(out), n+pad)
+			continue
+		}
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, out)
+		if !bytes.Equal(out[:n], plain) {
+			t.Errorf("n=%d: plaintext mismatch", n)
+		}
+		if want := bytes.Repeat([]byte{byte(pad)}, pad); !bytes.Equal(out[n:], want) {
+			t.Errorf("n=%d: padding %x, want %x", n, out[n:], want)
+		}
+	}
+}
+
+func TestPCKS7DecoderPadding(t *testing.T) {
+	key := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypt := func(plain []byte) []byte {
+		out := make([]byte, len(plain))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, plain)
+		return out
+	}
+	padded := func(data []byte, tail ...byte) []byte {
+		return append(append([]byte{}, data...), tail...)
+	}
+	data := bytes.Repeat([]byte{0x5A}, 2048)
+
+	tests := []struct {
+		name       string
+		ciphertext []byte
+		want       []byte
+		err        error
+	}{
+		{"one byte", encrypt(padded(data[:15], 0x01)), data[:15], nil},
+		{"full block", encrypt(padded(data, bytes.Repeat([]byte{0x10}, 16)...)), data, nil},
+		{"zero pad", encrypt(padded(data[:15], 0x00)), nil, ErrBadPadding},
+		{"pad too long", encrypt(padded(data[:15], 0x11)), nil, ErrBadPadding},
+		{"inconsistent pad", encrypt(padded(data[:12], 0x04, 0x04, 0x03, 0x04)), nil, ErrBadPadding},
+		{"empty", nil, nil, io.ErrUnexpectedEOF},
+		{"partial block", encrypt(padded(data[:15], 0x01))[:15], nil, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		got, err := ioutil.ReadAll(NewCBC_PCKS7_Decoder(bytes.NewReader(tt.ciphertext), block, iv))
+		if err != tt.err {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil && !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %d bytes, want %d", tt.name, len(got), len(tt.want))
+		}
+	}
+}
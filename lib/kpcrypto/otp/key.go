@@ -0,0 +1,184 @@
+package otp
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type selects between counter-based and time-based one-time passwords.
+type Type int
+
+const (
+	TypeTOTP Type = iota
+	TypeHOTP
+)
+
+// Key holds the secret and parameters of a one-time password generator, as
+// stored in an otpauth URI.
+type Key struct {
+	Type      Type
+	Secret    []byte
+	Algorithm Algorithm
+	Digits    int
+	// Period is the time step of a TOTP in seconds.
+	Period int
+	// Counter is the next counter value of a HOTP.
+	Counter uint64
+	// Steam selects Steam Guard codes. The Algorithm, Digits and Period
+	// are ignored.
+	Steam bool
+
+	Issuer  string
+	Account string
+}
+
+// NewTOTPKey returns a TOTP key for secret with the default parameters.
+func NewTOTPKey(secret []byte) *Key {
+	return &Key{
+		Type:      TypeTOTP,
+		Secret:    secret,
+		Algorithm: AlgorithmSHA1,
+		Digits:    DefaultDigits,
+		Period:    DefaultPeriod,
+	}
+}
+
+// NewHOTPKey returns a HOTP key for secret with the default parameters.
+func NewHOTPKey(secret []byte, counter uint64) *Key {
+	return &Key{
+		Type:      TypeHOTP,
+		Secret:    secret,
+		Algorithm: AlgorithmSHA1,
+		Digits:    DefaultDigits,
+		Counter:   counter,
+	}
+}
+
+// Code returns the one-time password at t. For a HOTP, t is ignored and the
+// code for Counter is returned.
+func (k *Key) Code(t time.Time) (string, error) {
+	switch {
+	case k.Steam:
+		return Steam(k.Secret, t)
+	case k.Type == TypeHOTP:
+		return HOTP(k.Secret, k.Counter, k.Digits, k.Algorithm)
+	}
+	return TOTP(k.Secret, t, k.Period, k.Digits, k.Algorithm)
+}
+
+// Remaining returns how long the TOTP code at t stays valid. It returns 0
+// for a HOTP.
+func (k *Key) Remaining(t time.Time) time.Duration {
+	if k.Type == TypeHOTP || k.Period <= 0 {
+		return 0
+	}
+	period := time.Duration(k.Period) * time.Second
+	elapsed := time.Duration(t.Unix()%int64(k.Period))*time.Second + time.Duration(t.Nanosecond())
+	if elapsed < 0 {
+		elapsed += period
+	}
+	return period - elapsed
+}
+
+// ParseURI parses an otpauth URI, such as
+//
+//	otpauth://totp/Example:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Example
+//
+// The "encoder=steam" parameter used by KeePassXC selects Steam Guard codes.
+func ParseURI(s string) (*Key, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || !strings.EqualFold(u.Scheme, "otpauth") {
+		return nil, ErrInvalidURI
+	}
+	var k *Key
+	switch strings.ToLower(u.Host) {
+	case "totp":
+		k = NewTOTPKey(nil)
+	case "hotp":
+		k = NewHOTPKey(nil, 0)
+	default:
+		return nil, ErrInvalidURI
+	}
+
+	label := strings.TrimPrefix(u.Path, "/")
+	if i := strings.IndexByte(label, ':'); i >= 0 {
+		k.Issuer = strings.TrimSpace(label[:i])
+		label = label[i+1:]
+	}
+	k.Account = strings.TrimSpace(label)
+
+	q := u.Query()
+	if k.Secret, err = DecodeBase32(q.Get("secret")); err != nil {
+		return nil, ErrInvalidURI
+	}
+	if len(k.Secret) == 0 {
+		return nil, ErrNoSecret
+	}
+	if issuer := q.Get("issuer"); issuer != "" {
+		k.Issuer = issuer
+	}
+	if alg := q.Get("algorithm"); alg != "" {
+		if k.Algorithm, err = ParseAlgorithm(alg); err != nil {
+			return nil, err
+		}
+	}
+	if digits := q.Get("digits"); digits != "" {
+		if k.Digits, err = strconv.Atoi(digits); err != nil || k.Digits < 1 || k.Digits > 10 {
+			return nil, ErrInvalidDigits
+		}
+	}
+	if period := q.Get("period"); period != "" {
+		if k.Period, err = strconv.Atoi(period); err != nil || k.Period <= 0 {
+			return nil, ErrInvalidPeriod
+		}
+	}
+	if counter := q.Get("counter"); counter != "" {
+		if k.Counter, err = strconv.ParseUint(counter, 10, 64); err != nil {
+			return nil, ErrInvalidURI
+		}
+	}
+	if strings.EqualFold(q.Get("encoder"), "steam") {
+		k.Steam = true
+	}
+	return k, nil
+}
+
+// URI returns the otpauth URI for k. Parameters that have their default
+// value are omitted.
+func (k *Key) URI() string {
+	u := url.URL{Scheme: "otpauth", Host: "totp"}
+	if k.Type == TypeHOTP {
+		u.Host = "hotp"
+	}
+	label := k.Account
+	if k.Issuer != "" {
+		label = k.Issuer + ":" + label
+	}
+	u.Path = "/" + label
+
+	q := url.Values{}
+	q.Set("secret", EncodeBase32(k.Secret))
+	if k.Issuer != "" {
+		q.Set("issuer", k.Issuer)
+	}
+	if k.Steam {
+		q.Set("encoder", "steam")
+	} else {
+		if k.Algorithm != AlgorithmSHA1 {
+			q.Set("algorithm", k.Algorithm.String())
+		}
+		if k.Digits != DefaultDigits {
+			q.Set("digits", strconv.Itoa(k.Digits))
+		}
+		if k.Type == TypeTOTP && k.Period != DefaultPeriod {
+			q.Set("period", strconv.Itoa(k.Period))
+		}
+	}
+	if k.Type == TypeHOTP {
+		q.Set("counter", strconv.FormatUint(k.Counter, 10))
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,195 @@
+// Package otp generates HMAC-based and time-based one-time passwords, as
+// used for two-factor authentication.
+package otp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"strings"
+	"time"
+)
+
+// Default parameters of a one-time password, as used by most services.
+const (
+	DefaultDigits = 6
+	DefaultPeriod = 30
+)
+
+// Steam Guard codes are five characters from steamAlphabet.
+const (
+	steamDigits   = 5
+	steamAlphabet = "23456789BCDFGHJKMNPQRTVWXY"
+)
+
+// Errors returned for invalid one-time password parameters.
+var (
+	ErrNoSecret         = errors.New("otp: the secret is empty")
+	ErrInvalidDigits    = errors.New("otp: the number of digits must be between 1 and 10")
+	ErrInvalidPeriod    = errors.New("otp: the period must be positive")
+	ErrUnknownAlgorithm = errors.New("otp: unknown hash algorithm")
+	ErrInvalidURI       = errors.New("otp: not a valid otpauth URI")
+)
+
+// Algorithm is the hash function used in the HMAC of a one-time password.
+//
+// File: KeePassLib/Cryptography/HmacOtp.cs
+// TimeOtp algorithms
+type Algorithm int
+
+const (
+	AlgorithmSHA1 Algorithm = iota
+	AlgorithmSHA256
+	AlgorithmSHA512
+)
+
+// String returns the name of the algorithm as used in otpauth URIs.
+func (a Algorithm) String() string {
+	switch a {
+	case AlgorithmSHA1:
+		return "SHA1"
+	case AlgorithmSHA256:
+		return "SHA256"
+	case AlgorithmSHA512:
+		return "SHA512"
+	}
+	return "unknown"
+}
+
+func (a Algorithm) newHash() (func() hash.Hash, error) {
+	switch a {
+	case AlgorithmSHA1:
+		return sha1.New, nil
+	case AlgorithmSHA256:
+		return sha256.New, nil
+	case AlgorithmSHA512:
+		return sha512.New, nil
+	}
+	return nil, ErrUnknownAlgorithm
+}
+
+// ParseAlgorithm parses an algorithm name, either as used in otpauth URIs
+// ("SHA256") or in the KeePass TimeOtp-Algorithm field ("HMAC-SHA-256").
+// The empty string is SHA-1.
+func ParseAlgorithm(s string) (Algorithm, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimPrefix(s, "HMAC-")
+	switch strings.Replace(s, "-", "", -1) {
+	case "", "SHA1":
+		return AlgorithmSHA1, nil
+	case "SHA256":
+		return AlgorithmSHA256, nil
+	case "SHA512":
+		return AlgorithmSHA512, nil
+	}
+	return 0, ErrUnknownAlgorithm
+}
+
+// DecodeBase32 decodes a base32 secret. Case, spaces, dashes and padding
+// are ignored, as they commonly vary between services.
+func DecodeBase32(s string) ([]byte, error) {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n', '-', '=':
+			return -1
+		}
+		return r
+	}, strings.ToUpper(s))
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
+}
+
+// EncodeBase32 encodes a secret in base32 without padding, as in otpauth
+// URIs.
+func EncodeBase32(secret []byte) string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+}
+
+// truncate computes the HMAC of counter and returns its dynamically
+// truncated 31-bit value.
+//
+// File: KeePassLib/Cryptography/HmacOtp.cs
+// Generate()
+func truncate(secret []byte, counter uint64, alg Algorithm) (uint32, error) {
+	if len(secret) == 0 {
+		return 0, ErrNoSecret
+	}
+	newHash, err := alg.newHash()
+	if err != nil {
+		return 0, err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(newHash, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0F
+	return binary.BigEndian.Uint32(sum[offset:]) & 0x7FFFFFFF, nil
+}
+
+// HOTP returns the HMAC-based one-time password for counter, as described
+// in RFC 4226.
+//
+// File: KeePassLib/Cryptography/HmacOtp.cs
+// Generate()
+func HOTP(secret []byte, counter uint64, digits int, alg Algorithm) (string, error) {
+	if digits < 1 || digits > 10 {
+		return "", ErrInvalidDigits
+	}
+	v, err := truncate(secret, counter, alg)
+	if err != nil {
+		return "", err
+	}
+	code := uint64(v)
+	buf := make([]byte, digits)
+	for i := digits - 1; i >= 0; i-- {
+		buf[i] = byte('0' + code%10)
+		code /= 10
+	}
+	return string(buf), nil
+}
+
+// timeCounter returns the number of periods since the Unix epoch at t.
+func timeCounter(t time.Time, period int) (uint64, error) {
+	if period <= 0 {
+		return 0, ErrInvalidPeriod
+	}
+	return uint64(t.Unix()) / uint64(period), nil
+}
+
+// TOTP returns the time-based one-time password at t, as described in
+// RFC 6238. period is in seconds.
+//
+// File: KeePassLib/Cryptography/HmacOtp.cs
+// GenerateTimeOtp()
+func TOTP(secret []byte, t time.Time, period, digits int, alg Algorithm) (string, error) {
+	counter, err := timeCounter(t, period)
+	if err != nil {
+		return "", err
+	}
+	return HOTP(secret, counter, digits, alg)
+}
+
+// Steam returns the Steam Guard code at t. Steam Guard is a TOTP with a
+// 30 second period and SHA-1, written as five letters and digits.
+func Steam(secret []byte, t time.Time) (string, error) {
+	counter, err := timeCounter(t, DefaultPeriod)
+	if err != nil {
+		return "", err
+	}
+	v, err := truncate(secret, counter, AlgorithmSHA1)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, steamDigits)
+	for i := range buf {
+		buf[i] = steamAlphabet[v%uint32(len(steamAlphabet))]
+		v /= uint32(len(steamAlphabet))
+	}
+	return string(buf), nil
+}
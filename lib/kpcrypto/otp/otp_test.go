@@ -0,0 +1,208 @@
+package otp
+
+import (
+	"testing"
+	"time"
+)
+
+var (
+	rfcSecretSHA1   = []byte("12345678901234567890")
+	rfcSecretSHA256 = []byte("12345678901234567890123456789012")
+	rfcSecretSHA512 = []byte("1234567890123456789012345678901234567890123456789012345678901234")
+)
+
+// Test vectors from RFC 4226, appendix D.
+func TestHOTP(t *testing.T) {
+	want := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+	for counter, w := range want {
+		got, err := HOTP(rfcSecretSHA1, uint64(counter), 6, AlgorithmSHA1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != w {
+			t.Errorf("counter %d: got %s, want %s", counter, got, w)
+		}
+	}
+}
+
+// Test vectors from RFC 6238, appendix B.
+func TestTOTP(t *testing.T) {
+	tests := []struct {
+		unix                 int64
+		sha1, sha256, sha512 string
+	}{
+		{59, "94287082", "46119246", "90693936"},
+		{1111111109, "07081804", "68084774", "25091201"},
+		{1111111111, "14050471", "67062674", "99943326"},
+		{1234567890, "89005924", "91819424", "93441116"},
+		{2000000000, "69279037", "90698825", "38618901"},
+		{20000000000, "65353130", "77737706", "47863826"},
+	}
+	for _, tt := range tests {
+		tm := time.Unix(tt.unix, 0)
+		for _, c := range []struct {
+			secret []byte
+			alg    Algorithm
+			want   string
+		}{
+			{rfcSecretSHA1, AlgorithmSHA1, tt.sha1},
+			{rfcSecretSHA256, AlgorithmSHA256, tt.sha256},
+			{rfcSecretSHA512, AlgorithmSHA512, tt.sha512},
+		} {
+			got, err := TOTP(c.secret, tm, 30, 8, c.alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("%d %v: got %s, want %s", tt.unix, c.alg, got, c.want)
+			}
+		}
+	}
+}
+
+func TestInvalidParameters(t *testing.T) {
+	if _, err := HOTP(nil, 0, 6, AlgorithmSHA1); err != ErrNoSecret {
+		t.Errorf("empty secret: got %v", err)
+	}
+	if _, err := HOTP(rfcSecretSHA1, 0, 11, AlgorithmSHA1); err != ErrInvalidDigits {
+		t.Errorf("11 digits: got %v", err)
+	}
+	if _, err := TOTP(rfcSecretSHA1, time.Now(), 0, 6, AlgorithmSHA1); err != ErrInvalidPeriod {
+		t.Errorf("zero period: got %v", err)
+	}
+	if _, err := HOTP(rfcSecretSHA1, 0, 6, Algorithm(99)); err != ErrUnknownAlgorithm {
+		t.Errorf("unknown algorithm: got %v", err)
+	}
+}
+
+// The expected codes were computed independently, with the algorithm of the
+// ValvePython steam library's generate_twofactor_code_for_time.
+func TestSteam(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "PV9M4"},
+		{1111111109, "PY4YB"},
+		{1234567890, "VHHQY"},
+		{2000000000, "9N776"},
+	}
+	for _, tt := range tests {
+		got, err := Steam(rfcSecretSHA1, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%d: got %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Algorithm
+	}{
+		{"", AlgorithmSHA1},
+		{"SHA1", AlgorithmSHA1},
+		{"sha256", AlgorithmSHA256},
+		{"HMAC-SHA-256", AlgorithmSHA256},
+		{"HMAC-SHA-512", AlgorithmSHA512},
+	}
+	for _, tt := range tests {
+		got, err := ParseAlgorithm(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("%q: got %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := ParseAlgorithm("MD5"); err != ErrUnknownAlgorithm {
+		t.Errorf("MD5: got %v", err)
+	}
+}
+
+func TestDecodeBase32(t *testing.T) {
+	got, err := DecodeBase32("gezd gnbv-gy3t qojq GEZDGNBVGY3TQOJQ==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(rfcSecretSHA1) {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestParseURI(t *testing.T) {
+	k, err := ParseURI("otpauth://totp/ACME%20Co:john@example.com?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&issuer=ACME%20Co&algorithm=SHA256&digits=8&period=60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Type != TypeTOTP || k.Issuer != "ACME Co" || k.Account != "john@example.com" ||
+		k.Algorithm != AlgorithmSHA256 || k.Digits != 8 || k.Period != 60 || string(k.Secret) != string(rfcSecretSHA1) {
+		t.Errorf("got %+v", k)
+	}
+
+	k, err = ParseURI("otpauth://hotp/alice?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&counter=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := k.Code(time.Now()); k.Type != TypeHOTP || k.Account != "alice" || code != "359152" {
+		t.Errorf("got %+v, code %s", k, code)
+	}
+
+	for _, s := range []string{
+		"https://example.com/?secret=GEZDGNBV",
+		"otpauth://motp/x?secret=GEZDGNBV",
+		"otpauth://totp/x?secret=not*base32",
+		"otpauth://totp/x",
+		"otpauth://totp/x?secret=GEZDGNBV&digits=12",
+		"otpauth://totp/x?secret=GEZDGNBV&period=0",
+	} {
+		if _, err := ParseURI(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+func TestURIRoundTrip(t *testing.T) {
+	keys := []*Key{
+		NewTOTPKey(rfcSecretSHA1),
+		{Type: TypeTOTP, Secret: rfcSecretSHA512, Algorithm: AlgorithmSHA512, Digits: 8, Period: 60, Issuer: "ACME Co", Account: "john@example.com"},
+		NewHOTPKey(rfcSecretSHA1, 42),
+		{Type: TypeTOTP, Secret: rfcSecretSHA1, Steam: true, Issuer: "Steam", Account: "me"},
+	}
+	for _, k := range keys {
+		got, err := ParseURI(k.URI())
+		if err != nil {
+			t.Fatalf("%s: %v", k.URI(), err)
+		}
+		if !k.Steam && (got.Algorithm != k.Algorithm || got.Digits != k.Digits || got.Period != k.Period) ||
+			got.Type != k.Type || got.Counter != k.Counter || got.Steam != k.Steam ||
+			got.Issuer != k.Issuer || got.Account != k.Account || string(got.Secret) != string(k.Secret) {
+			t.Errorf("%s: got %+v, want %+v", k.URI(), got, k)
+		}
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	k := NewTOTPKey(rfcSecretSHA1)
+	tests := []struct {
+		t    time.Time
+		want time.Duration
+	}{
+		{time.Unix(0, 0), 30 * time.Second},
+		{time.Unix(59, 0), 1 * time.Second},
+		{time.Unix(60, 500*int64(time.Millisecond)), 29500 * time.Millisecond},
+		{time.Unix(-1, 0), 1 * time.Second},
+		{time.Unix(-31, 0), 1 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := k.Remaining(tt.t); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.t.Unix(), got, tt.want)
+		}
+	}
+	if got := NewHOTPKey(rfcSecretSHA1, 0).Remaining(time.Unix(59, 0)); got != 0 {
+		t.Errorf("HOTP: got %v, want 0", got)
+	}
+}
@@ -1,25 +1,162 @@
 package kpcrypto
 
-import "io"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"io"
+	"runtime"
+	"sync/atomic"
+
+	"golang.org/x/crypto/chacha20"
+)
 
 // ProtectedBuffer is a container for data that is held encrypted in memory.
-// TODO implement
-type ProtectedBuffer []byte
+//
+// The data is xored with a ChaCha20 key stream, using a random key generated
+// once per process and a nonce unique to the buffer, so the plaintext is only
+// in memory while it is being used. On Linux, the encrypted data is held in
+// pages that are locked into memory.
+//
+// A ProtectedBuffer cannot be modified after it is created. A nil
+// *ProtectedBuffer is an empty buffer.
+//
+// file: KeePassLib/Security/ProtectedBinary.cs
+// file: KeePassLib/Security/XorredBuffer.cs
+type ProtectedBuffer struct {
+	data   []byte
+	nonce  [chacha20.NonceSize]byte
+	locked bool
+}
+
+// processKey is the key that all ProtectedBuffers are encrypted with.
+//
+// file: KeePassLib/Security/ProtectedBinary.cs
+// g_pbKey32
+var processKey []byte
+
+// bufferCounter provides the nonces of the ProtectedBuffers.
+//
+// file: KeePassLib/Security/ProtectedBinary.cs
+// g_lCurID
+var bufferCounter uint64
+
+func init() {
+	var ok bool
+	processKey, ok = allocLocked(chacha20.KeySize)
+	if !ok {
+		processKey = make([]byte, chacha20.KeySize)
+	}
+	_, err := rand.Read(processKey)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// NewProtectedBuffer creates a ProtectedBuffer holding a copy of plain.
+// The caller should zero plain after use.
+func NewProtectedBuffer(plain []byte) *ProtectedBuffer {
+	pb := &ProtectedBuffer{}
+	binary.LittleEndian.PutUint64(pb.nonce[:], atomic.AddUint64(&bufferCounter, 1))
+
+	pb.data, pb.locked = allocLocked(len(plain))
+	if pb.locked {
+		runtime.SetFinalizer(pb, (*ProtectedBuffer).Clear)
+	} else {
+		pb.data = make([]byte, len(plain))
+	}
+	pb.xorKeyStream(pb.data, plain)
+	return pb
+}
+
+func (pb *ProtectedBuffer) xorKeyStream(dst, src []byte) {
+	c, err := chacha20.NewUnauthenticatedCipher(processKey, pb.nonce[:])
+	if err != nil {
+		panic(err)
+	}
+	c.XORKeyStream(dst, src)
+}
+
+// Len returns the length of the unencrypted contents.
+func (pb *ProtectedBuffer) Len() int {
+	if pb == nil {
+		return 0
+	}
+	return len(pb.data)
+}
+
+// Reveal returns a copy of the unencrypted contents. The caller should zero
+// it after use.
+//
+// file: KeePassLib/Security/ProtectedBinary.cs
+// ReadData()
+func (pb *ProtectedBuffer) Reveal() []byte {
+	if pb == nil {
+		return nil
+	}
+	plain := make([]byte, len(pb.data))
+	pb.xorKeyStream(plain, pb.data)
+	// Locked memory is outside of the Go heap, so pb.data does not keep pb
+	// alive; its finalizer must not free the memory while it is being read
+	runtime.KeepAlive(pb)
+	return plain
+}
+
+// RevealString returns the unencrypted contents as a string. The string
+// cannot be zeroed, so Reveal should be preferred.
+func (pb *ProtectedBuffer) RevealString() string {
+	plain := pb.Reveal()
+	s := string(plain)
+	wipe(plain)
+	return s
+}
 
 // WriteTo writes the unencrypted contents of the buffer to the provided writer.
-func (pb *ProtectedBuffer) WriteTo(w io.Writer) (n uint64, err error) {
-	// TODO
-	return 0, nil
+func (pb *ProtectedBuffer) WriteTo(w io.Writer) (n int64, err error) {
+	plain := pb.Reveal()
+	nn, err := w.Write(plain)
+	wipe(plain)
+	return int64(nn), err
 }
 
-// String writes the unencrypted contents of the buffer into a string.
+// String returns a placeholder, so that formatting a ProtectedBuffer does not
+// reveal its contents. Use RevealString to get the contents.
 func (pb *ProtectedBuffer) String() string {
-	return ""
+	return "[protected]"
 }
 
-// Clear zeroes out the buffer.
+// Equal reports whether both buffers have the same contents, in constant
+// time for buffers of the same length.
+//
+// file: KeePassLib/Security/ProtectedBinary.cs
+// Equals()
+func (pb *ProtectedBuffer) Equal(other *ProtectedBuffer) bool {
+	a := pb.Reveal()
+	b := other.Reveal()
+	equal := len(a) == len(b) && subtle.ConstantTimeCompare(a, b) == 1
+	wipe(a)
+	wipe(b)
+	return equal
+}
+
+// Clear zeroes out the buffer and releases its memory. The buffer is empty
+// afterwards.
 func (pb *ProtectedBuffer) Clear() {
-	for i, _ := range pb {
-		pb[i] = 0
+	if pb == nil {
+		return
 	}
-}
\ No newline at end of file
+	wipe(pb.data)
+	wipe(pb.nonce[:])
+	if pb.locked {
+		freeLocked(pb.data)
+		pb.locked = false
+		runtime.SetFinalizer(pb, nil)
+	}
+	pb.data = nil
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
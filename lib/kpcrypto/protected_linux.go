@@ -0,0 +1,96 @@
+package kpcrypto
+
+import (
+	"sync"
+	"syscall"
+)
+
+// Small allocations are carved out of shared locked arenas, so that a
+// database with many protected strings does not need a mapping per string.
+// Larger allocations get their own mapping.
+const (
+	lockedArenaSize = 64 * 1024
+	lockedMinClass  = 16
+	lockedMaxClass  = 4096
+)
+
+// lockedPool holds the free slots of the locked arenas, by size class.
+var lockedPool struct {
+	sync.Mutex
+	free map[int][][]byte
+	// failed is set once an arena could not be locked, usually because
+	// RLIMIT_MEMLOCK was reached; no more arenas are allocated after that.
+	failed bool
+}
+
+// lockedClass returns the size class of an allocation of n bytes.
+func lockedClass(n int) int {
+	class := lockedMinClass
+	for class < n {
+		class *= 2
+	}
+	return class
+}
+
+// mmapLocked maps n bytes and locks them into memory.
+func mmapLocked(n int) ([]byte, bool) {
+	b, err := syscall.Mmap(-1, 0, n, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return nil, false
+	}
+	if err := syscall.Mlock(b); err != nil {
+		syscall.Munmap(b)
+		return nil, false
+	}
+	return b, true
+}
+
+// allocLocked allocates n bytes that are locked into memory, so that they
+// are not written to swap. ok is false when no locked memory is available,
+// and the caller should use the Go heap instead.
+func allocLocked(n int) (b []byte, ok bool) {
+	if n == 0 {
+		return nil, false
+	}
+	if n > lockedMaxClass {
+		return mmapLocked(n)
+	}
+
+	class := lockedClass(n)
+	lockedPool.Lock()
+	defer lockedPool.Unlock()
+	if lockedPool.free == nil {
+		lockedPool.free = make(map[int][][]byte)
+	}
+	free := lockedPool.free[class]
+	if len(free) == 0 {
+		if lockedPool.failed {
+			return nil, false
+		}
+		arena, ok := mmapLocked(lockedArenaSize)
+		if !ok {
+			lockedPool.failed = true
+			return nil, false
+		}
+		for i := 0; i < lockedArenaSize; i += class {
+			free = append(free, arena[i:i+class:i+class])
+		}
+	}
+	slot := free[len(free)-1]
+	lockedPool.free[class] = free[:len(free)-1]
+	return slot[:n], true
+}
+
+// freeLocked releases memory returned by allocLocked.
+func freeLocked(b []byte) {
+	if cap(b) > lockedMaxClass {
+		syscall.Munlock(b)
+		syscall.Munmap(b)
+		return
+	}
+	slot := b[:cap(b)]
+	wipe(slot)
+	lockedPool.Lock()
+	lockedPool.free[cap(slot)] = append(lockedPool.free[cap(slot)], slot)
+	lockedPool.Unlock()
+}
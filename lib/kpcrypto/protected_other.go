@@ -0,0 +1,11 @@
+//go:build !linux
+
+package kpcrypto
+
+// allocLocked is only implemented on Linux; elsewhere the Go heap is used.
+func allocLocked(n int) (b []byte, ok bool) {
+	return nil, false
+}
+
+func freeLocked(b []byte) {
+}
@@ -0,0 +1,178 @@
+package kpcrypto
+
+import (
+	"crypto/subtle"
+	"io"
+)
+
+// protectedBinaryChunkSize is the size of the ProtectedBuffers that hold the
+// data of a ProtectedBinary read from a stream. Only one chunk is in
+// plaintext at a time while the data is streamed.
+const protectedBinaryChunkSize = 64 * 1024
+
+// ProtectedBinary is binary data with a flag saying whether it should be
+// protected. The data is always held in ProtectedBuffers; the flag controls
+// whether it is encrypted with the inner random stream in the file.
+//
+// A ProtectedBinary cannot be modified after it is created. A nil
+// *ProtectedBinary is empty and unprotected.
+//
+// file: KeePassLib/Security/ProtectedBinary.cs
+type ProtectedBinary struct {
+	protected bool
+	chunks    []*ProtectedBuffer
+	length    int64
+}
+
+// NewProtectedBinary creates a ProtectedBinary holding a copy of data.
+func NewProtectedBinary(protected bool, data []byte) *ProtectedBinary {
+	pb := &ProtectedBinary{protected: protected, length: int64(len(data))}
+	if len(data) > 0 {
+		pb.chunks = []*ProtectedBuffer{NewProtectedBuffer(data)}
+	}
+	return pb
+}
+
+// ReadProtectedBinary creates a ProtectedBinary holding the data read from r
+// until EOF. The data is encrypted in memory as it is read, so large data
+// never needs to be held in plaintext.
+func ReadProtectedBinary(protected bool, r io.Reader) (*ProtectedBinary, error) {
+	pb := &ProtectedBinary{protected: protected}
+	buf := make([]byte, protectedBinaryChunkSize)
+	defer wipe(buf)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			pb.chunks = append(pb.chunks, NewProtectedBuffer(buf[:n]))
+			pb.length += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return pb, nil
+		} else if err != nil {
+			pb.clear()
+			return nil, err
+		}
+	}
+}
+
+// IsProtected reports whether the data should be protected.
+func (pb *ProtectedBinary) IsProtected() bool {
+	return pb != nil && pb.protected
+}
+
+// Len returns the length of the data.
+func (pb *ProtectedBinary) Len() int64 {
+	if pb == nil {
+		return 0
+	}
+	return pb.length
+}
+
+// Reveal returns a copy of the data. The caller should zero it after use.
+// WriteTo should be preferred for large data.
+//
+// file: KeePassLib/Security/ProtectedBinary.cs
+// ReadData()
+func (pb *ProtectedBinary) Reveal() []byte {
+	if pb == nil {
+		return []byte{}
+	}
+	plain := make([]byte, 0, pb.length)
+	for _, c := range pb.chunks {
+		chunk := c.Reveal()
+		plain = append(plain, chunk...)
+		wipe(chunk)
+	}
+	return plain
+}
+
+// WriteTo writes the data to w, revealing one chunk at a time.
+func (pb *ProtectedBinary) WriteTo(w io.Writer) (n int64, err error) {
+	if pb == nil {
+		return 0, nil
+	}
+	for _, c := range pb.chunks {
+		nn, err := c.WriteTo(w)
+		n += nn
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Equal reports whether both binaries have the same data and protection flag.
+//
+// file: KeePassLib/Security/ProtectedBinary.cs
+// Equals()
+func (pb *ProtectedBinary) Equal(other *ProtectedBinary) bool {
+	if pb.IsProtected() != other.IsProtected() || pb.Len() != other.Len() {
+		return false
+	}
+	if pb.Len() == 0 {
+		return true
+	}
+	if len(pb.chunks) == 1 && len(other.chunks) == 1 {
+		return pb.chunks[0].Equal(other.chunks[0])
+	}
+
+	// The chunks may have different boundaries, so compare the data through
+	// a fixed-size window.
+	a := &chunkReader{chunks: pb.chunks}
+	b := &chunkReader{chunks: other.chunks}
+	defer a.close()
+	defer b.close()
+	bufA := make([]byte, protectedBinaryChunkSize)
+	bufB := make([]byte, protectedBinaryChunkSize)
+	defer wipe(bufA)
+	defer wipe(bufB)
+	equal := 1
+	for {
+		n, _ := io.ReadFull(a, bufA)
+		io.ReadFull(b, bufB[:n])
+		equal &= subtle.ConstantTimeCompare(bufA[:n], bufB[:n])
+		if n < len(bufA) {
+			return equal == 1
+		}
+	}
+}
+
+// clear zeroes out the data and releases its memory.
+func (pb *ProtectedBinary) clear() {
+	if pb == nil {
+		return
+	}
+	for _, c := range pb.chunks {
+		c.Clear()
+	}
+	pb.chunks = nil
+	pb.length = 0
+}
+
+// chunkReader reads the plaintext of a list of ProtectedBuffers, revealing
+// one at a time.
+type chunkReader struct {
+	chunks []*ProtectedBuffer
+	plain  []byte
+	off    int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for r.off == len(r.plain) {
+		if len(r.chunks) == 0 {
+			return 0, io.EOF
+		}
+		r.close()
+		r.plain = r.chunks[0].Reveal()
+		r.chunks = r.chunks[1:]
+	}
+	n := copy(p, r.plain[r.off:])
+	r.off += n
+	return n, nil
+}
+
+func (r *chunkReader) close() {
+	wipe(r.plain)
+	r.plain = nil
+	r.off = 0
+}
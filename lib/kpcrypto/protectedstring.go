@@ -0,0 +1,128 @@
+package kpcrypto
+
+import "crypto/subtle"
+
+// ProtectedString is a string value with a flag saying whether it should be
+// protected. Protected values are held in a ProtectedBuffer, and are
+// encrypted with the inner random stream when a database is written.
+//
+// A ProtectedString cannot be modified after it is created. A nil
+// *ProtectedString is an empty, unprotected string.
+//
+// file: KeePassLib/Security/ProtectedString.cs
+type ProtectedString struct {
+	protected bool
+	plain     string
+	pb        *ProtectedBuffer
+}
+
+// NewProtectedString creates a ProtectedString holding value.
+func NewProtectedString(protected bool, value string) *ProtectedString {
+	if !protected {
+		return &ProtectedString{plain: value}
+	}
+	return &ProtectedString{protected: true, pb: NewProtectedBuffer([]byte(value))}
+}
+
+// NewProtectedStringBytes creates a ProtectedString holding the UTF-8 encoded
+// value. The caller should zero value after use.
+func NewProtectedStringBytes(protected bool, value []byte) *ProtectedString {
+	if !protected {
+		return &ProtectedString{plain: string(value)}
+	}
+	return &ProtectedString{protected: true, pb: NewProtectedBuffer(value)}
+}
+
+// IsProtected reports whether the value should be protected.
+func (ps *ProtectedString) IsProtected() bool {
+	return ps != nil && ps.protected
+}
+
+// IsEmpty reports whether the value is the empty string.
+func (ps *ProtectedString) IsEmpty() bool {
+	return ps.Len() == 0
+}
+
+// Len returns the length of the value in bytes.
+func (ps *ProtectedString) Len() int {
+	if ps == nil {
+		return 0
+	}
+	if ps.protected {
+		return ps.pb.Len()
+	}
+	return len(ps.plain)
+}
+
+// Reveal returns the value.
+//
+// file: KeePassLib/Security/ProtectedString.cs
+// ReadString()
+func (ps *ProtectedString) Reveal() string {
+	if ps == nil {
+		return ""
+	}
+	if ps.protected {
+		return ps.pb.RevealString()
+	}
+	return ps.plain
+}
+
+// RevealBytes returns the value encoded as UTF-8. The caller should zero it
+// after use.
+//
+// file: KeePassLib/Security/ProtectedString.cs
+// ReadUtf8()
+func (ps *ProtectedString) RevealBytes() []byte {
+	if ps == nil {
+		return []byte{}
+	}
+	if ps.protected {
+		return ps.pb.Reveal()
+	}
+	return []byte(ps.plain)
+}
+
+// WithProtection returns a ProtectedString with the same value and the given
+// protection flag.
+//
+// file: KeePassLib/Security/ProtectedString.cs
+// WithProtection()
+func (ps *ProtectedString) WithProtection(protected bool) *ProtectedString {
+	if ps != nil && ps.protected == protected {
+		return ps
+	}
+	value := ps.RevealBytes()
+	out := NewProtectedStringBytes(protected, value)
+	wipe(value)
+	return out
+}
+
+// Equal reports whether both strings have the same value, and the same
+// protection flag if checkProtection is set.
+//
+// file: KeePassLib/Security/ProtectedString.cs
+// Equals()
+func (ps *ProtectedString) Equal(other *ProtectedString, checkProtection bool) bool {
+	if checkProtection && ps.IsProtected() != other.IsProtected() {
+		return false
+	}
+	if !ps.IsProtected() && !other.IsProtected() {
+		return ps.Reveal() == other.Reveal()
+	}
+	a := ps.RevealBytes()
+	b := other.RevealBytes()
+	equal := len(a) == len(b) && subtle.ConstantTimeCompare(a, b) == 1
+	wipe(a)
+	wipe(b)
+	return equal
+}
+
+// String returns the value if it is not protected, and a placeholder
+// otherwise. Use Reveal to get a protected value.
+func (ps *ProtectedString) String() string {
+	if ps.IsProtected() {
+		return "[protected]"
+	}
+	return ps.Reveal()
+}
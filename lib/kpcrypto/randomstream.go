@@ -0,0 +1,83 @@
+package kpcrypto
+
+import (
+	"crypto/sha512"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+// RandomStream is a predictable byte-stream, used to protect values in the
+// XML document of a database.
+//
+// file: KeePassLib/Cryptography/CryptoRandomStream.cs
+type RandomStream interface {
+	// Read fills p with the next bytes of the stream. It never fails.
+	Read(p []byte) (n int, err error)
+	// Cipher retrieves the next bytes of the stream. If xor is true, they are
+	// xored into p, otherwise they are copied into p.
+	Cipher(p []byte, xor bool)
+}
+
+// ChaChaRandomStream is a RandomStream backed by ChaCha20, used by KDBX 4.
+//
+// file: KeePassLib/Cryptography/CryptoRandomStream.cs
+// CrsAlgorithm.ChaCha20
+type ChaChaRandomStream struct {
+	c *chacha20.Cipher
+}
+
+// NewChaChaRandomStream sets up a ChaChaRandomStream using the given key,
+// which is 64 bytes long in files written by KeePass.
+//
+// file: KeePassLib/Cryptography/CryptoRandomStream.cs
+// constructor
+func NewChaChaRandomStream(key []byte) *ChaChaRandomStream {
+	h := sha512.Sum512(key)
+	c, err := chacha20.NewUnauthenticatedCipher(h[0:32], h[32:32+chacha20.NonceSize])
+	for i := range h {
+		h[i] = 0
+	}
+	if err != nil {
+		panic(err)
+	}
+	return &ChaChaRandomStream{c: c}
+}
+
+// Read gets deterministic bytes from the backing ChaCha20 cipher.
+// This method always completes a full read and never errors.
+func (rs *ChaChaRandomStream) Read(p []byte) (nn int, err error) {
+	rs.Cipher(p, false)
+	return len(p), nil
+}
+
+// Cipher retrieves bytes from the deterministic cipher.
+// If xor is true, it will xor the stream bytes into the provided slice.
+// If xor is false, it will copy the stream bytes into the provided slice.
+func (rs *ChaChaRandomStream) Cipher(p []byte, xor bool) {
+	if !xor {
+		for i := range p {
+			p[i] = 0
+		}
+	}
+	rs.c.XORKeyStream(p, p)
+}
+
+// NullRandomStream is a RandomStream of zero bytes, used when a database
+// does not protect its values.
+//
+// file: KeePassLib/Cryptography/CryptoRandomStream.cs
+// CrsAlgorithm.Null
+type NullRandomStream struct{}
+
+func (NullRandomStream) Read(p []byte) (nn int, err error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func (NullRandomStream) Cipher(p []byte, xor bool) {
+	if !xor {
+		NullRandomStream{}.Read(p)
+	}
+}
@@ -0,0 +1,55 @@
+package kpcrypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The stream is keyed with SHA-512 of the key: the first 32 bytes of the
+// hash are the ChaCha20 key and the next 12 bytes are the nonce. For the key
+// 00 01 ... 3f that gives the ChaCha20 key
+// ee4320ebaf3fdb4f2c832b137200c08e235e0fa7bbd0eb1740c7063ba8a0d151 and the
+// nonce da77e003398e1714a955d475, and the expected output is the keystream
+// for those.
+func TestChaChaRandomStreamVector(t *testing.T) {
+	key := make([]byte, 64)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	want := mustHex(t, "8ce8bc610ac05ff2e3dd88b49a1404c2844f148037027476b83d58f5609adf65"+
+		"f8fa87d8b5f5287f6cfdbdb69adea2f942e4acf5a9ad9e860623477a73a24c4e")
+
+	got := make([]byte, len(want))
+	n, err := NewChaChaRandomStream(key).Read(got)
+	if n != len(got) || err != nil {
+		t.Fatalf("Read = %d, %v", n, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+
+	// The stream continues across calls, and Cipher xors when asked to.
+	rs := NewChaChaRandomStream(key)
+	first := make([]byte, 10)
+	rs.Cipher(first, false)
+	rest := bytes.Repeat([]byte{0xFF}, len(want)-10)
+	rs.Cipher(rest, true)
+	for i := range rest {
+		rest[i] ^= 0xFF
+	}
+	if got := append(first, rest...); !bytes.Equal(got, want) {
+		t.Errorf("split reads: got %x, want %x", got, want)
+	}
+}
+
+func TestNullRandomStream(t *testing.T) {
+	p := []byte{1, 2, 3}
+	NullRandomStream{}.Cipher(p, true)
+	if !bytes.Equal(p, []byte{1, 2, 3}) {
+		t.Errorf("xor: got %v", p)
+	}
+	NullRandomStream{}.Cipher(p, false)
+	if !bytes.Equal(p, []byte{0, 0, 0}) {
+		t.Errorf("copy: got %v", p)
+	}
+}
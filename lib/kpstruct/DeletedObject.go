@@ -0,0 +1,22 @@
+package kpstruct
+
+import (
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// DeletedObject records that the entry or group with the given UUID was
+// deleted, so that synchronizing with an older copy of the database does not
+// bring it back.
+//
+// File: KeePassLib/PwDeletedObject.cs
+type DeletedObject struct {
+	UUID         uuid.UUID
+	DeletionTime time.Time
+}
+
+// NewDeletedObject returns a DeletedObject for id, deleted now.
+func NewDeletedObject(id uuid.UUID) DeletedObject {
+	return DeletedObject{UUID: id, DeletionTime: TimeNow()}
+}
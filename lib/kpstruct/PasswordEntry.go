@@ -0,0 +1,329 @@
+package kpstruct
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/satori/go.uuid"
+)
+
+// ErrNoSuchHistoryEntry is returned when restoring an entry from a history
+// index that does not exist.
+var ErrNoSuchHistoryEntry = errors.New("kpstruct: no history entry at that index")
+
+// IconID identifies one of the standard KeePass icons.
+//
+// File: KeePassLib/PwEnums.cs
+// PwIcon
+type IconID uint32
+
+const (
+	IconKey        IconID = 0
+	IconTrashBin   IconID = 43
+	IconFolder     IconID = 48
+	IconFolderOpen IconID = 49
+)
+
+// PasswordEntry is an entry in the database.
+//
+// File: KeePassLib/PwEntry.cs
+type PasswordEntry struct {
+	UUID uuid.UUID
+
+	// Strings holds the standard fields, such as TitleField and
+	// PasswordField, and the custom string fields.
+	Strings ProtectedStringDictionary
+	// Binaries holds the attachments.
+	Binaries ProtectedBinaryDictionary
+
+	IconID         IconID
+	CustomIconUUID uuid.UUID
+
+	// ForegroundColor and BackgroundColor are HTML colors such as "#FF0000",
+	// or empty for the default color.
+	ForegroundColor string
+	BackgroundColor string
+	// OverrideURL is a command line used to open the URL of the entry.
+	OverrideURL string
+	Tags        []string
+
+	Times    Times
+	AutoType AutoTypeConfig
+
+	// History holds previous versions of the entry, oldest first.
+	History []*PasswordEntry
+
+	parentGroup *PasswordGroup
+}
+
+// NewPasswordEntry creates an entry with a new UUID and the current time.
+//
+// File: KeePassLib/PwEntry.cs
+// constructor
+func NewPasswordEntry() *PasswordEntry {
+	return &PasswordEntry{
+		UUID:     uuid.NewV4(),
+		IconID:   IconKey,
+		Times:    NewTimes(),
+		AutoType: AutoTypeConfig{Enabled: true},
+	}
+}
+
+// ParentGroup returns the group containing the entry, or nil.
+//
+// File: KeePassLib/PwEntry.cs
+// ParentGroup
+func (pe *PasswordEntry) ParentGroup() *PasswordGroup {
+	return pe.parentGroup
+}
+
+// MoveTo moves the entry to the end of newParent, and updates its location
+// changed time.
+func (pe *PasswordEntry) MoveTo(newParent *PasswordGroup) {
+	newParent.AddEntry(pe)
+}
+
+// CloneDeep returns a copy of the entry, including its history. The copy
+// does not belong to a group.
+//
+// File: KeePassLib/PwEntry.cs
+// CloneDeep()
+func (pe *PasswordEntry) CloneDeep() *PasswordEntry {
+	out := *pe
+	out.parentGroup = nil
+	out.Strings = pe.Strings.Clone()
+	out.Binaries = pe.Binaries.Clone()
+	out.Tags = append([]string(nil), pe.Tags...)
+	out.AutoType = pe.AutoType.Clone()
+	out.History = make([]*PasswordEntry, len(pe.History))
+	for i, h := range pe.History {
+		out.History[i] = h.CloneDeep()
+	}
+	return &out
+}
+
+// AssignProperties copies the data of src to pe, including its UUID. If
+// onlyIfNewer is set, nothing is copied unless src was modified after pe.
+// The history is copied if includeHistory is set, and the location changed
+// time if assignLocationChanged is set.
+//
+// File: KeePassLib/PwEntry.cs
+// AssignProperties()
+func (pe *PasswordEntry) AssignProperties(src *PasswordEntry, onlyIfNewer, includeHistory, assignLocationChanged bool) {
+	if onlyIfNewer && !src.Times.LastModificationTime.After(pe.Times.LastModificationTime) {
+		return
+	}
+	clone := src.CloneDeep()
+	clone.parentGroup = pe.parentGroup
+	if !includeHistory {
+		clone.History = pe.History
+	}
+	if !assignLocationChanged {
+		clone.Times.LocationChanged = pe.Times.LocationChanged
+	}
+	*pe = *clone
+}
+
+// HasSameData reports whether pe and other have the same strings, binaries,
+// icons, colors, tags, expiry and auto-type settings. The UUID, history,
+// group and other times are not compared.
+//
+// File: KeePassLib/PwEntry.cs
+// EqualsEntry()
+func (pe *PasswordEntry) HasSameData(other *PasswordEntry) bool {
+	if !pe.Strings.Equal(&other.Strings) || !pe.Binaries.Equal(&other.Binaries) {
+		return false
+	}
+	if pe.IconID != other.IconID || !uuid.Equal(pe.CustomIconUUID, other.CustomIconUUID) ||
+		pe.ForegroundColor != other.ForegroundColor || pe.BackgroundColor != other.BackgroundColor ||
+		pe.OverrideURL != other.OverrideURL || pe.TagsString() != other.TagsString() {
+		return false
+	}
+	if pe.Times.Expires != other.Times.Expires || !pe.Times.ExpiryTime.Equal(other.Times.ExpiryTime) {
+		return false
+	}
+	return pe.AutoType.Equal(&other.AutoType)
+}
+
+// CreateBackup adds a copy of the current state of the entry, without its
+// history, to the end of the history.
+//
+// File: KeePassLib/PwEntry.cs
+// CreateBackup()
+func (pe *PasswordEntry) CreateBackup() {
+	backup := pe.CloneDeep()
+	backup.History = nil
+	pe.History = append(pe.History, backup)
+}
+
+// Edit saves the current state of the entry in its history, calls fn to
+// change the entry, and updates its modification time.
+func (pe *PasswordEntry) Edit(fn func(pe *PasswordEntry)) {
+	pe.CreateBackup()
+	fn(pe)
+	pe.Times.Touch(true)
+}
+
+// RestoreFromBackup saves the current state of the entry in its history, and
+// replaces it with the history entry at index i.
+//
+// File: KeePassLib/PwEntry.cs
+// RestoreFromBackup()
+func (pe *PasswordEntry) RestoreFromBackup(i int) error {
+	if i < 0 || i >= len(pe.History) {
+		return ErrNoSuchHistoryEntry
+	}
+	backup := pe.History[i]
+	pe.CreateBackup()
+	pe.AssignProperties(backup, false, false, false)
+	return nil
+}
+
+// MaintainBackups removes the oldest history entries until there are at
+// most maxItems of them, and their total Size is at most maxSize. A negative
+// limit is not applied. It reports whether any history entry was removed.
+//
+// File: KeePassLib/PwEntry.cs
+// MaintainBackups()
+func (pe *PasswordEntry) MaintainBackups(maxItems int, maxSize int64) bool {
+	removed := false
+	if maxItems >= 0 {
+		for len(pe.History) > maxItems {
+			pe.removeOldestBackup()
+			removed = true
+		}
+	}
+	if maxSize >= 0 {
+		for {
+			var size uint64
+			for _, h := range pe.History {
+				size += h.Size()
+			}
+			if size <= uint64(maxSize) {
+				break
+			}
+			pe.removeOldestBackup()
+			removed = true
+		}
+	}
+	return removed
+}
+
+// removeOldestBackup removes the history entry with the earliest
+// modification time.
+//
+// File: KeePassLib/PwEntry.cs
+// RemoveOldestBackup()
+func (pe *PasswordEntry) removeOldestBackup() {
+	oldest := 0
+	for i, h := range pe.History {
+		if h.Times.LastModificationTime.Before(pe.History[oldest].Times.LastModificationTime) {
+			oldest = i
+		}
+	}
+	pe.History = append(pe.History[:oldest], pe.History[oldest+1:]...)
+}
+
+// Size returns an estimate of the size of the entry and its history, in
+// bytes, as used for the history size limit.
+//
+// File: KeePassLib/PwEntry.cs
+// GetSize()
+func (pe *PasswordEntry) Size() uint64 {
+	size := uint64(128) // UUID, times and other fixed-size fields
+	for _, name := range pe.Strings.Keys() {
+		size += uint64(len(name)) + uint64(pe.Strings.Get(name).Len())
+	}
+	for _, name := range pe.Binaries.Keys() {
+		size += uint64(len(name)) + uint64(pe.Binaries.Get(name).Len())
+	}
+	size += uint64(len(pe.ForegroundColor) + len(pe.BackgroundColor) + len(pe.OverrideURL))
+	size += uint64(len(pe.TagsString()))
+	size += uint64(len(pe.AutoType.DefaultSequence))
+	for _, a := range pe.AutoType.Associations {
+		size += uint64(len(a.Window) + len(a.Sequence))
+	}
+	for _, h := range pe.History {
+		size += h.Size()
+	}
+	return size
+}
+
+// TagsString returns the tags joined by semicolons, as stored in the file.
+//
+// File: KeePassLib/Utility/StrUtil.cs
+// TagsToString()
+func (pe *PasswordEntry) TagsString() string {
+	return strings.Join(pe.Tags, ";")
+}
+
+// SetTagsString sets the tags from a list separated by semicolons or commas.
+//
+// File: KeePassLib/Utility/StrUtil.cs
+// StringToTags()
+func (pe *PasswordEntry) SetTagsString(s string) {
+	pe.Tags = nil
+	for _, tag := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == ',' }) {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			pe.Tags = append(pe.Tags, tag)
+		}
+	}
+}
+
+// AutoTypeObfuscation selects how auto-type hides the typed text.
+//
+// File: KeePassLib/PwEnums.cs
+// AutoTypeObfuscationOptions
+type AutoTypeObfuscation uint32
+
+const (
+	AutoTypeObfuscationNone AutoTypeObfuscation = iota
+	AutoTypeObfuscationUseClipboard
+)
+
+// AutoTypeConfig holds the auto-type settings of an entry.
+//
+// File: KeePassLib/Collections/AutoTypeConfig.cs
+type AutoTypeConfig struct {
+	Enabled     bool
+	Obfuscation AutoTypeObfuscation
+	// DefaultSequence is the keystroke sequence, or empty to inherit the
+	// sequence of the group.
+	DefaultSequence string
+	Associations    []AutoTypeAssociation
+}
+
+// AutoTypeAssociation is a keystroke sequence used for windows whose title
+// matches Window.
+//
+// File: KeePassLib/Collections/AutoTypeConfig.cs
+// AutoTypeAssociation
+type AutoTypeAssociation struct {
+	Window   string
+	Sequence string
+}
+
+// Clone returns a copy of the configuration.
+func (c AutoTypeConfig) Clone() AutoTypeConfig {
+	c.Associations = append([]AutoTypeAssociation(nil), c.Associations...)
+	return c
+}
+
+// Equal reports whether both configurations have the same settings and
+// associations.
+//
+// File: KeePassLib/Collections/AutoTypeConfig.cs
+// Equals()
+func (c *AutoTypeConfig) Equal(other *AutoTypeConfig) bool {
+	if c.Enabled != other.Enabled || c.Obfuscation != other.Obfuscation ||
+		c.DefaultSequence != other.DefaultSequence || len(c.Associations) != len(other.Associations) {
+		return false
+	}
+	for i := range c.Associations {
+		if c.Associations[i] != other.Associations[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package kpstruct
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/riking/go-keepass2/lib/kpcrypto/otp"
+)
+
+// ErrNoOTP is returned when an entry has no one-time password settings.
+var ErrNoOTP = errors.New("kpstruct: the entry has no one-time password")
+
+// Names of the string fields that hold one-time password settings. KeePass
+// stores the secret in one of the TimeOtp-Secret or HmacOtp-Secret fields,
+// in the encoding given by the suffix; KeePassXC stores an otpauth URI in
+// OTPURIField.
+const (
+	HmacOtpSecretField       = "HmacOtp-Secret"
+	HmacOtpSecretHexField    = "HmacOtp-Secret-Hex"
+	HmacOtpSecretBase32Field = "HmacOtp-Secret-Base32"
+	HmacOtpSecretBase64Field = "HmacOtp-Secret-Base64"
+	HmacOtpCounterField      = "HmacOtp-Counter"
+
+	TimeOtpSecretField       = "TimeOtp-Secret"
+	TimeOtpSecretHexField    = "TimeOtp-Secret-Hex"
+	TimeOtpSecretBase32Field = "TimeOtp-Secret-Base32"
+	TimeOtpSecretBase64Field = "TimeOtp-Secret-Base64"
+	TimeOtpLengthField       = "TimeOtp-Length"
+	TimeOtpPeriodField       = "TimeOtp-Period"
+	TimeOtpAlgorithmField    = "TimeOtp-Algorithm"
+
+	OTPURIField = "otp"
+)
+
+// OTPKey returns the one-time password settings of the entry. The KeePass
+// TimeOtp fields are used first, then the HmacOtp fields, then an otpauth
+// URI in OTPURIField. It returns ErrNoOTP if none of them is set.
+func (pe *PasswordEntry) OTPKey() (*otp.Key, error) {
+	if secret, ok, err := pe.otpSecret(timeOtpSecretFields); ok {
+		if err != nil {
+			return nil, err
+		}
+		k := otp.NewTOTPKey(secret)
+		if s := pe.Strings.GetSafe(TimeOtpLengthField); s != "" {
+			if k.Digits, err = strconv.Atoi(s); err != nil {
+				return nil, otp.ErrInvalidDigits
+			}
+		}
+		if s := pe.Strings.GetSafe(TimeOtpPeriodField); s != "" {
+			if k.Period, err = strconv.Atoi(s); err != nil {
+				return nil, otp.ErrInvalidPeriod
+			}
+		}
+		if k.Algorithm, err = otp.ParseAlgorithm(pe.Strings.GetSafe(TimeOtpAlgorithmField)); err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+
+	if secret, ok, err := pe.otpSecret(hmacOtpSecretFields); ok {
+		if err != nil {
+			return nil, err
+		}
+		k := otp.NewHOTPKey(secret, 0)
+		if s := pe.Strings.GetSafe(HmacOtpCounterField); s != "" {
+			if k.Counter, err = strconv.ParseUint(s, 10, 64); err != nil {
+				return nil, err
+			}
+		}
+		return k, nil
+	}
+
+	if s := pe.Strings.GetSafe(OTPURIField); s != "" {
+		return otp.ParseURI(s)
+	}
+	return nil, ErrNoOTP
+}
+
+// otpSecretFields names the fields that may hold a one-time password
+// secret, in each of the encodings.
+type otpSecretFields struct {
+	raw, hex, base32, base64 string
+}
+
+var (
+	timeOtpSecretFields = otpSecretFields{
+		raw:    TimeOtpSecretField,
+		hex:    TimeOtpSecretHexField,
+		base32: TimeOtpSecretBase32Field,
+		base64: TimeOtpSecretBase64Field,
+	}
+	hmacOtpSecretFields = otpSecretFields{
+		raw:    HmacOtpSecretField,
+		hex:    HmacOtpSecretHexField,
+		base32: HmacOtpSecretBase32Field,
+		base64: HmacOtpSecretBase64Field,
+	}
+)
+
+// otpSecret decodes the first of the secret fields that is set. It reports
+// whether one was set.
+func (pe *PasswordEntry) otpSecret(fields otpSecretFields) ([]byte, bool, error) {
+	if s := pe.Strings.GetSafe(fields.raw); s != "" {
+		return []byte(s), true, nil
+	}
+	if s := pe.Strings.GetSafe(fields.hex); s != "" {
+		b, err := hex.DecodeString(s)
+		return b, true, err
+	}
+	if s := pe.Strings.GetSafe(fields.base32); s != "" {
+		b, err := otp.DecodeBase32(s)
+		return b, true, err
+	}
+	if s := pe.Strings.GetSafe(fields.base64); s != "" {
+		b, err := base64.StdEncoding.DecodeString(s)
+		return b, true, err
+	}
+	return nil, false, nil
+}
+
+// OTPCode returns the one-time password of the entry at t. For a HOTP, it
+// returns the code for the stored counter without advancing it.
+func (pe *PasswordEntry) OTPCode(t time.Time) (string, error) {
+	k, err := pe.OTPKey()
+	if err != nil {
+		return "", err
+	}
+	return k.Code(t)
+}
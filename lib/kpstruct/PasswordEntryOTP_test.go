@@ -0,0 +1,103 @@
+package kpstruct
+
+import (
+	"testing"
+	"time"
+
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+	"github.com/riking/go-keepass2/lib/kpcrypto/otp"
+)
+
+// The RFC 4226 and RFC 6238 test secrets, in the encodings of the KeePass
+// secret fields.
+const (
+	otpSecretSHA1       = "12345678901234567890"
+	otpSecretSHA1Hex    = "3132333435363738393031323334353637383930"
+	otpSecretSHA1Base32 = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	otpSecretSHA1Base64 = "MTIzNDU2Nzg5MDEyMzQ1Njc4OTA="
+	otpSecretSHA256     = "12345678901234567890123456789012"
+)
+
+func otpEntry(fields map[string]string) *PasswordEntry {
+	pe := NewPasswordEntry()
+	for k, v := range fields {
+		pe.Strings.Set(k, kpcrypto.NewProtectedString(true, v))
+	}
+	return pe
+}
+
+func TestOTPCode(t *testing.T) {
+	tm := time.Unix(59, 0)
+	tests := []struct {
+		name   string
+		fields map[string]string
+		want   string
+	}{
+		{"TimeOtp raw", map[string]string{TimeOtpSecretField: otpSecretSHA1}, "287082"},
+		{"TimeOtp hex", map[string]string{TimeOtpSecretHexField: otpSecretSHA1Hex}, "287082"},
+		{"TimeOtp base32", map[string]string{TimeOtpSecretBase32Field: otpSecretSHA1Base32}, "287082"},
+		{"TimeOtp base64", map[string]string{TimeOtpSecretBase64Field: otpSecretSHA1Base64}, "287082"},
+		{"TimeOtp length", map[string]string{
+			TimeOtpSecretField: otpSecretSHA1,
+			TimeOtpLengthField: "8",
+		}, "94287082"},
+		{"TimeOtp period", map[string]string{
+			TimeOtpSecretField: otpSecretSHA1,
+			TimeOtpPeriodField: "60",
+		}, "755224"},
+		{"TimeOtp algorithm", map[string]string{
+			TimeOtpSecretField:    otpSecretSHA256,
+			TimeOtpLengthField:    "8",
+			TimeOtpAlgorithmField: "HMAC-SHA-256",
+		}, "46119246"},
+		{"HmacOtp without counter", map[string]string{HmacOtpSecretField: otpSecretSHA1}, "755224"},
+		{"HmacOtp counter", map[string]string{
+			HmacOtpSecretBase32Field: otpSecretSHA1Base32,
+			HmacOtpCounterField:      "2",
+		}, "359152"},
+		{"HmacOtp hex", map[string]string{
+			HmacOtpSecretHexField: otpSecretSHA1Hex,
+			HmacOtpCounterField:   "1",
+		}, "287082"},
+		{"HmacOtp base64", map[string]string{HmacOtpSecretBase64Field: otpSecretSHA1Base64}, "755224"},
+		{"otpauth", map[string]string{
+			OTPURIField: "otpauth://totp/ACME:john?secret=" + otpSecretSHA1Base32 + "&digits=8",
+		}, "94287082"},
+		{"TimeOtp before otpauth", map[string]string{
+			TimeOtpSecretField: otpSecretSHA1,
+			OTPURIField:        "otpauth://hotp/x?secret=" + otpSecretSHA1Base32 + "&counter=2",
+		}, "287082"},
+	}
+	for _, tt := range tests {
+		got, err := otpEntry(tt.fields).OTPCode(tm)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOTPKeyErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]string
+		want   error
+	}{
+		{"no fields", nil, ErrNoOTP},
+		{"other fields", map[string]string{TitleField: "x", "TimeOtp-Length": "6"}, ErrNoOTP},
+		{"bad length", map[string]string{TimeOtpSecretField: otpSecretSHA1, TimeOtpLengthField: "six"}, otp.ErrInvalidDigits},
+		{"bad period", map[string]string{TimeOtpSecretField: otpSecretSHA1, TimeOtpPeriodField: "x"}, otp.ErrInvalidPeriod},
+		{"bad algorithm", map[string]string{TimeOtpSecretField: otpSecretSHA1, TimeOtpAlgorithmField: "HMAC-MD5"}, otp.ErrUnknownAlgorithm},
+		{"bad uri", map[string]string{OTPURIField: "https://example.com"}, otp.ErrInvalidURI},
+	}
+	for _, tt := range tests {
+		if _, err := otpEntry(tt.fields).OTPKey(); err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	if _, err := otpEntry(map[string]string{TimeOtpSecretHexField: "zz"}).OTPKey(); err == nil {
+		t.Error("bad hex secret: expected an error")
+	}
+}
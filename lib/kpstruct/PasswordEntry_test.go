@@ -0,0 +1,94 @@
+package kpstruct
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+)
+
+func entryWithPassword(password string) *PasswordEntry {
+	pe := NewPasswordEntry()
+	pe.Strings.Set(PasswordField, kpcrypto.NewProtectedString(true, password))
+	return pe
+}
+
+func TestEntryHistory(t *testing.T) {
+	pe := entryWithPassword("one")
+	pe.Times.LastModificationTime = pe.Times.LastModificationTime.Add(-time.Hour)
+	pe.Edit(func(pe *PasswordEntry) {
+		pe.Strings.Set(PasswordField, kpcrypto.NewProtectedString(true, "two"))
+	})
+
+	if len(pe.History) != 1 || pe.History[0].Strings.GetSafe(PasswordField) != "one" {
+		t.Fatalf("history after Edit: %d entries", len(pe.History))
+	}
+	if pe.History[0].UUID != pe.UUID || pe.History[0].History != nil {
+		t.Errorf("backup has UUID %v and %d history entries", pe.History[0].UUID, len(pe.History[0].History))
+	}
+	if !pe.Times.LastModificationTime.After(pe.History[0].Times.LastModificationTime) {
+		t.Errorf("Edit did not update the modification time")
+	}
+
+	if err := pe.RestoreFromBackup(0); err != nil {
+		t.Fatal(err)
+	}
+	if got := pe.Strings.GetSafe(PasswordField); got != "one" {
+		t.Errorf("restored password %q, want %q", got, "one")
+	}
+	if len(pe.History) != 2 || pe.History[1].Strings.GetSafe(PasswordField) != "two" {
+		t.Errorf("restoring did not save the current version")
+	}
+
+	for _, i := range []int{-1, 2} {
+		if err := pe.RestoreFromBackup(i); err != ErrNoSuchHistoryEntry {
+			t.Errorf("RestoreFromBackup(%d) = %v, want ErrNoSuchHistoryEntry", i, err)
+		}
+	}
+}
+
+func TestMaintainBackups(t *testing.T) {
+	now := TimeNow()
+	// newEntry returns an entry with four history entries of the same size,
+	// not in modification order. The letters are the order.
+	newEntry := func() *PasswordEntry {
+		pe := entryWithPassword("current")
+		for _, c := range "cadb" {
+			h := entryWithPassword(strings.Repeat(string(c), 100))
+			h.UUID = pe.UUID
+			h.Times.LastModificationTime = now.Add(time.Duration(c-'a') * time.Hour)
+			pe.History = append(pe.History, h)
+		}
+		return pe
+	}
+	historySize := int64(newEntry().History[0].Size())
+
+	tests := []struct {
+		name     string
+		maxItems int
+		maxSize  int64
+		want     string
+	}{
+		{"no limits", -1, -1, "cadb"},
+		{"items", 2, -1, "cd"},
+		{"zero items", 0, -1, ""},
+		{"size", -1, 3 * historySize, "cdb"},
+		{"size just below", -1, 3*historySize - 1, "cd"},
+		{"both", 3, historySize, "d"},
+	}
+	for _, tt := range tests {
+		pe := newEntry()
+		removed := pe.MaintainBackups(tt.maxItems, tt.maxSize)
+		var got string
+		for _, h := range pe.History {
+			got += h.Strings.GetSafe(PasswordField)[:1]
+		}
+		if got != tt.want {
+			t.Errorf("%s: history %q, want %q", tt.name, got, tt.want)
+		}
+		if removed != (len(tt.want) < 4) {
+			t.Errorf("%s: MaintainBackups returned %v", tt.name, removed)
+		}
+	}
+}
@@ -1,30 +1,447 @@
 package kpstruct
 
+import (
+	"errors"
+
+	"github.com/satori/go.uuid"
+)
+
+// ErrGroupCycle is returned when moving a group into itself or one of its
+// subgroups.
+var ErrGroupCycle = errors.New("kpstruct: cannot move a group into itself or one of its subgroups")
+
+// ErrIndexOutOfRange is returned when inserting a group or entry at an index
+// past the end of the subgroups or entries.
+var ErrIndexOutOfRange = errors.New("kpstruct: index out of range")
+
+// TriState is a setting that can be turned on or off, or inherited from the
+// parent group.
+type TriState int8
+
+const (
+	TriStateInherit TriState = iota
+	TriStateTrue
+	TriStateFalse
+)
+
+// PasswordGroup is a group of entries and subgroups. The groups of a
+// database form a tree, and each group and entry knows its parent.
+//
+// File: KeePassLib/PwGroup.cs
 type PasswordGroup struct {
-	// TODO
+	UUID           uuid.UUID
+	Name           string
+	Notes          string
+	IconID         IconID
+	CustomIconUUID uuid.UUID
+
+	Times      Times
+	IsExpanded bool
+
+	// DefaultAutoTypeSequence is the auto-type sequence of the entries in
+	// the group, or empty to inherit the sequence of the parent group.
+	DefaultAutoTypeSequence string
+	EnableAutoType          TriState
+	EnableSearching         TriState
+	LastTopVisibleEntry     uuid.UUID
+
+	parent  *PasswordGroup
+	groups  []*PasswordGroup
+	entries []*PasswordEntry
+}
+
+// NewPasswordGroup creates an empty group with a new UUID and the current
+// time.
+//
+// File: KeePassLib/PwGroup.cs
+// constructor
+func NewPasswordGroup(name string) *PasswordGroup {
+	return &PasswordGroup{
+		UUID:       uuid.NewV4(),
+		Name:       name,
+		IconID:     IconFolder,
+		Times:      NewTimes(),
+		IsExpanded: true,
+	}
+}
+
+// AssignProperties copies the properties of src to pg, but not its UUID or
+// contents. If onlyIfNewer is set, nothing is copied unless src was modified
+// after pg. The location changed time is copied if assignLocationChanged is
+// set.
+//
+// File: KeePassLib/PwGroup.cs
+// AssignProperties()
+func (pg *PasswordGroup) AssignProperties(src *PasswordGroup, onlyIfNewer, assignLocationChanged bool) {
+	if onlyIfNewer && !src.Times.LastModificationTime.After(pg.Times.LastModificationTime) {
+		return
+	}
+	pg.Name = src.Name
+	pg.Notes = src.Notes
+	pg.IconID = src.IconID
+	pg.CustomIconUUID = src.CustomIconUUID
+
+	locationChanged := pg.Times.LocationChanged
+	pg.Times = src.Times
+	if !assignLocationChanged {
+		pg.Times.LocationChanged = locationChanged
+	}
+
+	pg.IsExpanded = src.IsExpanded
+	pg.DefaultAutoTypeSequence = src.DefaultAutoTypeSequence
+	pg.EnableAutoType = src.EnableAutoType
+	pg.EnableSearching = src.EnableSearching
+	pg.LastTopVisibleEntry = src.LastTopVisibleEntry
+}
+
+// Parent returns the group containing pg, or nil for the root group.
+//
+// File: KeePassLib/PwGroup.cs
+// ParentGroup
+func (pg *PasswordGroup) Parent() *PasswordGroup {
+	return pg.parent
+}
+
+// Groups returns the subgroups of pg, in order. The slice must not be
+// modified.
+func (pg *PasswordGroup) Groups() []*PasswordGroup {
+	return pg.groups
+}
+
+// Entries returns the entries of pg, in order. The slice must not be
+// modified.
+func (pg *PasswordGroup) Entries() []*PasswordEntry {
+	return pg.entries
+}
+
+// IsContainedIn reports whether pg is a subgroup, at any depth, of other.
+//
+// File: KeePassLib/PwGroup.cs
+// IsContainedIn()
+func (pg *PasswordGroup) IsContainedIn(other *PasswordGroup) bool {
+	for p := pg.parent; p != nil; p = p.parent {
+		if p == other {
+			return true
+		}
+	}
+	return false
+}
+
+// AddGroup adds child to the end of the subgroups of pg. If child already
+// belongs to a group, it is moved and its location changed time is updated.
+func (pg *PasswordGroup) AddGroup(child *PasswordGroup) error {
+	return pg.InsertGroup(len(pg.groups), child)
+}
+
+// InsertGroup adds child to the subgroups of pg at index i. If child already
+// belongs to a group, it is moved and its location changed time is updated.
+// Inserting a subgroup of pg changes its position. i must be between 0 and
+// the number of subgroups.
+//
+// File: KeePassLib/PwGroup.cs
+// AddGroup()
+func (pg *PasswordGroup) InsertGroup(i int, child *PasswordGroup) error {
+	if child == pg || pg.IsContainedIn(child) {
+		return ErrGroupCycle
+	}
+	if i < 0 || i > len(pg.groups) {
+		return ErrIndexOutOfRange
+	}
+	if child.parent != nil {
+		old := child.parent
+		if old != pg {
+			child.Times.LocationChanged = TimeNow()
+		} else if indexOfGroup(pg.groups, child) < i {
+			i--
+		}
+		old.RemoveGroup(child)
+	}
+	pg.groups = append(pg.groups, nil)
+	copy(pg.groups[i+1:], pg.groups[i:])
+	pg.groups[i] = child
+	child.parent = pg
+	return nil
+}
+
+// RemoveGroup removes child from the subgroups of pg, and reports whether it
+// was there. The subgroups and entries of child are kept.
+func (pg *PasswordGroup) RemoveGroup(child *PasswordGroup) bool {
+	i := indexOfGroup(pg.groups, child)
+	if i < 0 {
+		return false
+	}
+	pg.groups = append(pg.groups[:i], pg.groups[i+1:]...)
+	child.parent = nil
+	return true
 }
 
-type PasswordEntry struct {
-	// TODO
+// MoveTo moves pg to the end of the subgroups of newParent.
+func (pg *PasswordGroup) MoveTo(newParent *PasswordGroup) error {
+	return newParent.AddGroup(pg)
 }
 
-type GroupHandler func(pg PasswordGroup)
-type EntryHandler func(pe PasswordEntry)
+// AddEntry adds pe to the end of the entries of pg. If pe already belongs to
+// a group, it is moved and its location changed time is updated.
+func (pg *PasswordGroup) AddEntry(pe *PasswordEntry) {
+	// The index is always valid
+	pg.InsertEntry(len(pg.entries), pe)
+}
+
+// InsertEntry adds pe to the entries of pg at index i. If pe already belongs
+// to a group, it is moved and its location changed time is updated.
+// Inserting an entry of pg changes its position. i must be between 0 and
+// the number of entries.
+//
+// File: KeePassLib/PwGroup.cs
+// AddEntry()
+func (pg *PasswordGroup) InsertEntry(i int, pe *PasswordEntry) error {
+	if i < 0 || i > len(pg.entries) {
+		return ErrIndexOutOfRange
+	}
+	if pe.parentGroup != nil {
+		old := pe.parentGroup
+		if old != pg {
+			pe.Times.LocationChanged = TimeNow()
+		} else if indexOfEntry(pg.entries, pe) < i {
+			i--
+		}
+		old.RemoveEntry(pe)
+	}
+	pg.entries = append(pg.entries, nil)
+	copy(pg.entries[i+1:], pg.entries[i:])
+	pg.entries[i] = pe
+	pe.parentGroup = pg
+	return nil
+}
+
+// RemoveEntry removes pe from the entries of pg, and reports whether it was
+// there.
+func (pg *PasswordGroup) RemoveEntry(pe *PasswordEntry) bool {
+	i := indexOfEntry(pg.entries, pe)
+	if i < 0 {
+		return false
+	}
+	pg.entries = append(pg.entries[:i], pg.entries[i+1:]...)
+	pe.parentGroup = nil
+	return true
+}
+
+// FindGroup returns the group with the given UUID, which may be pg itself,
+// or nil.
+//
+// File: KeePassLib/PwGroup.cs
+// FindGroup()
+func (pg *PasswordGroup) FindGroup(id uuid.UUID, recursive bool) *PasswordGroup {
+	if uuid.Equal(pg.UUID, id) {
+		return pg
+	}
+	for _, g := range pg.groups {
+		if uuid.Equal(g.UUID, id) {
+			return g
+		}
+		if recursive {
+			if found := g.FindGroup(id, true); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+// FindEntry returns the entry with the given UUID, or nil.
+//
+// File: KeePassLib/PwGroup.cs
+// FindEntry()
+func (pg *PasswordGroup) FindEntry(id uuid.UUID, recursive bool) *PasswordEntry {
+	for _, pe := range pg.entries {
+		if uuid.Equal(pe.UUID, id) {
+			return pe
+		}
+	}
+	if recursive {
+		for _, g := range pg.groups {
+			if found := g.FindEntry(id, true); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+func indexOfGroup(list []*PasswordGroup, pg *PasswordGroup) int {
+	for i, g := range list {
+		if g == pg {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexOfEntry(list []*PasswordEntry, pe *PasswordEntry) int {
+	for i, e := range list {
+		if e == pe {
+			return i
+		}
+	}
+	return -1
+}
 
+// GetCounts returns the number of subgroups and entries in pg. If recursive
+// is set, the subgroups and entries of the subgroups are counted too.
+//
+// File: KeePassLib/PwGroup.cs
+// GetCounts()
 func (pg *PasswordGroup) GetCounts(recursive bool) (groups, entries uint32) {
-	// TODO
-	return 0, 0
+	groups = uint32(len(pg.groups))
+	entries = uint32(len(pg.entries))
+	if recursive {
+		for _, g := range pg.groups {
+			subGroups, subEntries := g.GetCounts(true)
+			groups += subGroups
+			entries += subEntries
+		}
+	}
+	return groups, entries
 }
 
 const (
 	GetCountsNonRecursive = false
-	GetCountsRecursive = true
+	GetCountsRecursive    = true
 )
 
-func (pg *PasswordGroup) TraverseTree(traversalMethod int, gh GroupHandler, eh EntryHandler) {
+// TraverseAction tells TraverseTree how to continue after a handler returns.
+type TraverseAction int
 
-}
+const (
+	// TraverseContinue continues the traversal.
+	TraverseContinue TraverseAction = iota
+	// TraverseSkipSubtree continues the traversal, but does not visit the
+	// entries and subgroups of the group just visited. It has the same
+	// effect as TraverseContinue when returned from an EntryHandler, or in a
+	// post-order traversal.
+	TraverseSkipSubtree
+	// TraverseStop ends the traversal.
+	TraverseStop
+)
+
+// GroupHandler is called for each group visited by TraverseTree.
+type GroupHandler func(pg *PasswordGroup) TraverseAction
+
+// EntryHandler is called for each entry visited by TraverseTree.
+type EntryHandler func(pe *PasswordEntry) TraverseAction
+
+// TraversalMethod is the order in which TraverseTree visits the tree.
+type TraversalMethod int
 
 const (
-	TraversalMethodPreOrder = iota
-)
\ No newline at end of file
+	// TraversalMethodPreOrder visits a group before its contents. The
+	// entries of a group are visited before its subgroups.
+	TraversalMethodPreOrder TraversalMethod = iota
+	// TraversalMethodPostOrder visits a group after its contents. The
+	// entries of a group are visited before its subgroups.
+	TraversalMethodPostOrder
+	// TraversalMethodBreadthFirst visits the tree level by level. The
+	// entries of a group are visited before its subgroups.
+	TraversalMethodBreadthFirst
+)
+
+// TraverseTree calls gh for each subgroup and eh for each entry below pg,
+// at any depth. pg itself is not passed to gh. Either handler may be nil.
+// It returns false if a handler stopped the traversal.
+//
+// The handlers may move or remove the entries and subgroups of the group
+// that is being visited.
+//
+// File: KeePassLib/PwGroup.cs
+// TraverseTree()
+func (pg *PasswordGroup) TraverseTree(method TraversalMethod, gh GroupHandler, eh EntryHandler) bool {
+	if gh == nil {
+		gh = func(*PasswordGroup) TraverseAction { return TraverseContinue }
+	}
+	if eh == nil {
+		eh = func(*PasswordEntry) TraverseAction { return TraverseContinue }
+	}
+	switch method {
+	case TraversalMethodPreOrder:
+		return pg.preOrderTraverseTree(gh, eh)
+	case TraversalMethodPostOrder:
+		return pg.postOrderTraverseTree(gh, eh)
+	case TraversalMethodBreadthFirst:
+		return pg.breadthFirstTraverseTree(gh, eh)
+	default:
+		panic("kpstruct.PasswordGroup.TraverseTree: bad TraversalMethod")
+	}
+}
+
+// File: KeePassLib/PwGroup.cs
+// PreOrderTraverseTree()
+func (pg *PasswordGroup) preOrderTraverseTree(gh GroupHandler, eh EntryHandler) bool {
+	if !pg.visitEntries(eh) {
+		return false
+	}
+	for _, child := range pg.childGroups() {
+		switch gh(child) {
+		case TraverseStop:
+			return false
+		case TraverseSkipSubtree:
+			continue
+		}
+		if !child.preOrderTraverseTree(gh, eh) {
+			return false
+		}
+	}
+	return true
+}
+
+func (pg *PasswordGroup) postOrderTraverseTree(gh GroupHandler, eh EntryHandler) bool {
+	if !pg.visitEntries(eh) {
+		return false
+	}
+	for _, child := range pg.childGroups() {
+		if !child.postOrderTraverseTree(gh, eh) {
+			return false
+		}
+		if gh(child) == TraverseStop {
+			return false
+		}
+	}
+	return true
+}
+
+func (pg *PasswordGroup) breadthFirstTraverseTree(gh GroupHandler, eh EntryHandler) bool {
+	queue := []*PasswordGroup{pg}
+	for len(queue) > 0 {
+		g := queue[0]
+		queue = queue[1:]
+		if !g.visitEntries(eh) {
+			return false
+		}
+		for _, child := range g.childGroups() {
+			switch gh(child) {
+			case TraverseStop:
+				return false
+			case TraverseSkipSubtree:
+				continue
+			}
+			queue = append(queue, child)
+		}
+	}
+	return true
+}
+
+// visitEntries calls eh for each entry of pg, and returns false if it
+// stopped the traversal.
+func (pg *PasswordGroup) visitEntries(eh EntryHandler) bool {
+	for _, pe := range append([]*PasswordEntry(nil), pg.entries...) {
+		if eh(pe) == TraverseStop {
+			return false
+		}
+	}
+	return true
+}
+
+// childGroups returns a copy of the subgroups of pg, so that handlers can
+// change them during a traversal.
+func (pg *PasswordGroup) childGroups() []*PasswordGroup {
+	return append([]*PasswordGroup(nil), pg.groups...)
+}
@@ -0,0 +1,31 @@
+//go:build go1.23
+
+package kpstruct
+
+import "iter"
+
+// AllGroups returns an iterator over the subgroups of pg at any depth, in
+// pre-order. pg itself is not included.
+func (pg *PasswordGroup) AllGroups() iter.Seq[*PasswordGroup] {
+	return func(yield func(*PasswordGroup) bool) {
+		pg.TraverseTree(TraversalMethodPreOrder, func(g *PasswordGroup) TraverseAction {
+			if !yield(g) {
+				return TraverseStop
+			}
+			return TraverseContinue
+		}, nil)
+	}
+}
+
+// AllEntries returns an iterator over the entries of pg and its subgroups at
+// any depth, in pre-order.
+func (pg *PasswordGroup) AllEntries() iter.Seq[*PasswordEntry] {
+	return func(yield func(*PasswordEntry) bool) {
+		pg.TraverseTree(TraversalMethodPreOrder, nil, func(pe *PasswordEntry) TraverseAction {
+			if !yield(pe) {
+				return TraverseStop
+			}
+			return TraverseContinue
+		})
+	}
+}
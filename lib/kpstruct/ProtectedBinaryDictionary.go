@@ -0,0 +1,111 @@
+package kpstruct
+
+import (
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+)
+
+// ErrBinaryNotFound is returned when a ProtectedBinaryDictionary has no
+// binary with the requested name.
+var ErrBinaryNotFound = errors.New("kpstruct: no attachment with that name")
+
+// ProtectedBinaryDictionary maps attachment names to their data.
+// The zero value is an empty dictionary.
+//
+// File: KeePassLib/Collections/ProtectedBinaryDictionary.cs
+type ProtectedBinaryDictionary struct {
+	m map[string]*kpcrypto.ProtectedBinary
+}
+
+// Len returns the number of binaries in the dictionary.
+func (d *ProtectedBinaryDictionary) Len() int {
+	return len(d.m)
+}
+
+// Get returns the binary stored under name, or nil.
+func (d *ProtectedBinaryDictionary) Get(name string) *kpcrypto.ProtectedBinary {
+	return d.m[name]
+}
+
+// Set stores value under name.
+func (d *ProtectedBinaryDictionary) Set(name string, value *kpcrypto.ProtectedBinary) {
+	if value == nil {
+		panic("kpstruct.ProtectedBinaryDictionary.Set: nil value")
+	}
+	if d.m == nil {
+		d.m = make(map[string]*kpcrypto.ProtectedBinary)
+	}
+	d.m[name] = value
+}
+
+// SetFromReader stores the data read from r until EOF under name. The data
+// is encrypted in memory as it is read, so it is never held in plaintext as
+// a whole.
+func (d *ProtectedBinaryDictionary) SetFromReader(name string, protected bool, r io.Reader) error {
+	value, err := kpcrypto.ReadProtectedBinary(protected, r)
+	if err != nil {
+		return err
+	}
+	d.Set(name, value)
+	return nil
+}
+
+// Extract writes the data stored under name to w, without revealing all of
+// it in memory at once.
+func (d *ProtectedBinaryDictionary) Extract(name string, w io.Writer) (int64, error) {
+	value, ok := d.m[name]
+	if !ok {
+		return 0, ErrBinaryNotFound
+	}
+	return value.WriteTo(w)
+}
+
+// Remove deletes the binary stored under name, and reports whether there was one.
+func (d *ProtectedBinaryDictionary) Remove(name string) bool {
+	_, ok := d.m[name]
+	delete(d.m, name)
+	return ok
+}
+
+// Keys returns the names in the dictionary, in ordinal order.
+func (d *ProtectedBinaryDictionary) Keys() []string {
+	keys := make([]string, 0, len(d.m))
+	for k := range d.m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Clone returns a copy of the dictionary. The values are shared, as a
+// ProtectedBinary cannot be modified.
+//
+// File: KeePassLib/Collections/ProtectedBinaryDictionary.cs
+// CloneDeep()
+func (d *ProtectedBinaryDictionary) Clone() ProtectedBinaryDictionary {
+	var out ProtectedBinaryDictionary
+	for k, v := range d.m {
+		out.Set(k, v)
+	}
+	return out
+}
+
+// Equal reports whether both dictionaries hold the same binaries.
+//
+// File: KeePassLib/Collections/ProtectedBinaryDictionary.cs
+// EqualsDictionary()
+func (d *ProtectedBinaryDictionary) Equal(other *ProtectedBinaryDictionary) bool {
+	if len(d.m) != len(other.m) {
+		return false
+	}
+	for k, v := range d.m {
+		ov, ok := other.m[k]
+		if !ok || !v.Equal(ov) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package kpstruct
+
+import (
+	"sort"
+
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+)
+
+// Names of the standard entry strings.
+//
+// File: KeePassLib/PwDefs.cs
+// TitleField and friends
+const (
+	TitleField    = "Title"
+	UserNameField = "UserName"
+	PasswordField = "Password"
+	URLField      = "URL"
+	NotesField    = "Notes"
+)
+
+// IsStandardField reports whether name is one of the standard entry strings.
+//
+// File: KeePassLib/PwDefs.cs
+// IsStandardField()
+func IsStandardField(name string) bool {
+	switch name {
+	case TitleField, UserNameField, PasswordField, URLField, NotesField:
+		return true
+	}
+	return false
+}
+
+// ProtectedStringDictionary maps string field names to their values.
+// The zero value is an empty dictionary.
+//
+// File: KeePassLib/Collections/ProtectedStringDictionary.cs
+type ProtectedStringDictionary struct {
+	m map[string]*kpcrypto.ProtectedString
+}
+
+// Len returns the number of strings in the dictionary.
+func (d *ProtectedStringDictionary) Len() int {
+	return len(d.m)
+}
+
+// Get returns the value stored under name, or nil.
+func (d *ProtectedStringDictionary) Get(name string) *kpcrypto.ProtectedString {
+	return d.m[name]
+}
+
+// GetSafe returns the value stored under name, or an empty string.
+//
+// File: KeePassLib/Collections/ProtectedStringDictionary.cs
+// ReadSafe()
+func (d *ProtectedStringDictionary) GetSafe(name string) string {
+	return d.m[name].Reveal()
+}
+
+// Exists reports whether a value is stored under name.
+func (d *ProtectedStringDictionary) Exists(name string) bool {
+	_, ok := d.m[name]
+	return ok
+}
+
+// Set stores value under name.
+func (d *ProtectedStringDictionary) Set(name string, value *kpcrypto.ProtectedString) {
+	if value == nil {
+		panic("kpstruct.ProtectedStringDictionary.Set: nil value")
+	}
+	if d.m == nil {
+		d.m = make(map[string]*kpcrypto.ProtectedString)
+	}
+	d.m[name] = value
+}
+
+// Remove deletes the value stored under name, and reports whether there was one.
+func (d *ProtectedStringDictionary) Remove(name string) bool {
+	_, ok := d.m[name]
+	delete(d.m, name)
+	return ok
+}
+
+// Keys returns the names in the dictionary, in ordinal order.
+func (d *ProtectedStringDictionary) Keys() []string {
+	keys := make([]string, 0, len(d.m))
+	for k := range d.m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Clone returns a copy of the dictionary. The values are shared, as a
+// ProtectedString cannot be modified.
+//
+// File: KeePassLib/Collections/ProtectedStringDictionary.cs
+// CloneDeep()
+func (d *ProtectedStringDictionary) Clone() ProtectedStringDictionary {
+	var out ProtectedStringDictionary
+	for k, v := range d.m {
+		out.Set(k, v)
+	}
+	return out
+}
+
+// Equal reports whether both dictionaries hold the same strings with the
+// same protection flags.
+//
+// File: KeePassLib/Collections/ProtectedStringDictionary.cs
+// EqualsDictionary()
+func (d *ProtectedStringDictionary) Equal(other *ProtectedStringDictionary) bool {
+	if len(d.m) != len(other.m) {
+		return false
+	}
+	for k, v := range d.m {
+		ov, ok := other.m[k]
+		if !ok || !v.Equal(ov, true) {
+			return false
+		}
+	}
+	return true
+}
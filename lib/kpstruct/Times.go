@@ -0,0 +1,54 @@
+package kpstruct
+
+import "time"
+
+// Times holds the timestamps of an entry or group.
+//
+// File: KeePassLib/Interfaces/ITimeLogger.cs
+type Times struct {
+	CreationTime         time.Time
+	LastModificationTime time.Time
+	LastAccessTime       time.Time
+	ExpiryTime           time.Time
+	Expires              bool
+	UsageCount           uint64
+	// LocationChanged is the time the object was last moved to another group.
+	LocationChanged time.Time
+}
+
+// NewTimes returns Times with all timestamps set to now.
+func NewTimes() Times {
+	now := TimeNow()
+	return Times{
+		CreationTime:         now,
+		LastModificationTime: now,
+		LastAccessTime:       now,
+		ExpiryTime:           now,
+		LocationChanged:      now,
+	}
+}
+
+// Touch updates the last access time, and the last modification time if
+// modified is set, and increases the usage count.
+//
+// File: KeePassLib/PwEntry.cs
+// Touch()
+func (t *Times) Touch(modified bool) {
+	now := TimeNow()
+	t.LastAccessTime = now
+	t.UsageCount++
+	if modified {
+		t.LastModificationTime = now
+	}
+}
+
+// IsExpired reports whether the object expires and its expiry time has passed.
+func (t *Times) IsExpired(now time.Time) bool {
+	return t.Expires && !now.Before(t.ExpiryTime)
+}
+
+// TimeNow returns the current time in UTC, with the precision stored in the
+// file.
+func TimeNow() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
@@ -0,0 +1,434 @@
+package spr
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/riking/go-keepass2/lib/database"
+	"github.com/riking/go-keepass2/lib/kpstruct"
+)
+
+// Encoding selects how values inserted for placeholders are escaped.
+//
+// File: KeePass/Util/Spr/SprContext.cs
+// EncodeForCommandLine, EncodeAsAutoTypeSequence
+type Encoding int
+
+const (
+	// EncodingNone inserts values unchanged.
+	EncodingNone Encoding = iota
+	// EncodingShell quotes each value as a single POSIX shell word.
+	EncodingShell
+	// EncodingURL percent-encodes each value as an RFC 3986 data string,
+	// for use as a URL component.
+	EncodingURL
+)
+
+func (e Encoding) encode(s string) string {
+	switch e {
+	case EncodingShell:
+		return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+	case EncodingURL:
+		return escapeDataString(s)
+	}
+	return s
+}
+
+// escapeDataString percent-encodes all bytes of s except the RFC 3986
+// unreserved characters.
+//
+// File: System.Uri
+// EscapeDataString()
+func escapeDataString(s string) string {
+	const upperhex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(upperhex[c>>4])
+		b.WriteByte(upperhex[c&15])
+	}
+	return b.String()
+}
+
+// Context holds the entry and database that placeholders are expanded
+// against.
+//
+// File: KeePass/Util/Spr/SprContext.cs
+type Context struct {
+	Entry    *kpstruct.PasswordEntry
+	Database *database.Database
+	Encoding Encoding
+	// MaxDepth limits how deeply placeholders inside inserted values are
+	// expanded. If it is zero, MaxRecursionDepth is used.
+	MaxDepth int
+	// Now is the time used for the {DT_*} placeholders. If it is zero, the
+	// current time is used.
+	Now time.Time
+}
+
+// NewContext creates a Context for pe in db with the default depth limit.
+// Either may be nil, in which case the placeholders that need it are left
+// unchanged.
+func NewContext(db *database.Database, pe *kpstruct.PasswordEntry) *Context {
+	return &Context{Entry: pe, Database: db, MaxDepth: MaxRecursionDepth}
+}
+
+// Compile expands the placeholders in text.
+//
+// The supported placeholders, which are not case-sensitive, are:
+//
+//	{TITLE} {USERNAME} {PASSWORD} {URL} {NOTES} {S:<field name>}
+//	{URL:RMVSCM} {URL:SCM} {URL:HOST} {URL:PORT} {URL:PATH} {URL:QUERY}
+//	{URL:USERINFO} {URL:USERNAME} {URL:PASSWORD}
+//	{REF:<Wanted>@<SearchIn>:<Text>}
+//	{DB_NAME} {GROUP} {GROUP_PATH} {GROUP_NOTES}
+//	{DT_SIMPLE} {DT_YEAR} {DT_MONTH} {DT_DAY} {DT_HOUR} {DT_MINUTE}
+//	{DT_SECOND} and the same with DT_UTC_
+//	{ENV:<variable>} {ENV_DIRSEP}
+//	{T-REPLACE-RX:/<text>/<regexp>/<replacement>/}
+//	{T-CONV:/<text>/<U|L|Base64|Hex|Uri|Uri-Dec|Raw>/}
+//
+// The transformations may use any character in place of '/'. Their text is
+// expanded before it is transformed. Values inserted from the entry, the
+// database and the environment are escaped with ctx.Encoding; the Raw
+// conversion inserts its text without escaping. Unknown placeholders are
+// left unchanged.
+//
+// File: KeePass/Util/Spr/SprEngine.cs
+// Compile()
+func Compile(text string, ctx *Context) (string, error) {
+	c := compiler{now: ctx.Now}
+	if c.now.IsZero() {
+		c.now = time.Now()
+	}
+	if ctx.Database != nil {
+		c.refs = NewRefResolver(ctx.Database)
+	}
+	return c.compile(text, *ctx, 0, nil)
+}
+
+type compiler struct {
+	now  time.Time
+	refs *RefResolver
+}
+
+// compile expands the placeholders in text. stack holds the entry fields
+// being expanded, for detecting reference cycles.
+func (c *compiler) compile(text string, ctx Context, depth int, stack []refKey) (string, error) {
+	if !strings.Contains(text, "{") {
+		return text, nil
+	}
+	if depth >= depthLimit(ctx.MaxDepth) {
+		return text, ErrRecursionLimit
+	}
+
+	var out strings.Builder
+	for {
+		i := strings.IndexByte(text, '{')
+		if i < 0 {
+			break
+		}
+		out.WriteString(text[:i])
+		text = text[i:]
+
+		value, n, err := c.placeholder(text, ctx, depth, stack)
+		if err != nil {
+			return "", err
+		}
+		if n == 0 {
+			out.WriteByte('{')
+			text = text[1:]
+			continue
+		}
+		out.WriteString(value)
+		text = text[n:]
+	}
+	out.WriteString(text)
+	return out.String(), nil
+}
+
+// placeholder expands the placeholder at the start of text. It returns the
+// length of the placeholder, or 0 if it is not a known placeholder.
+func (c *compiler) placeholder(text string, ctx Context, depth int, stack []refKey) (string, int, error) {
+	raw := ctx
+	raw.Encoding = EncodingNone
+
+	if args, n := transformArgs(text, "{T-REPLACE-RX:", 3); n > 0 {
+		re, err := regexp.Compile(args[1])
+		if err != nil {
+			return "", 0, nil
+		}
+		s, err := c.compile(args[0], raw, depth+1, stack)
+		if err != nil {
+			return "", 0, err
+		}
+		return ctx.Encoding.encode(re.ReplaceAllString(s, args[2])), n, nil
+	}
+	if args, n := transformArgs(text, "{T-CONV:", 2); n > 0 {
+		s, err := c.compile(args[0], raw, depth+1, stack)
+		if err != nil {
+			return "", 0, err
+		}
+		s, ok, encode := convert(s, args[1])
+		if !ok {
+			return "", 0, nil
+		}
+		if encode {
+			s = ctx.Encoding.encode(s)
+		}
+		return s, n, nil
+	}
+
+	end := strings.IndexByte(text, '}')
+	if end < 0 {
+		return "", 0, nil
+	}
+	n := end + 1
+	name := text[1:end]
+	upperName := upperASCII(name)
+	pe := ctx.Entry
+
+	switch {
+	case upperName == "TITLE" && pe != nil:
+		return c.field(pe, kpstruct.TitleField, ctx, depth, n, stack)
+	case upperName == "USERNAME" && pe != nil:
+		return c.field(pe, kpstruct.UserNameField, ctx, depth, n, stack)
+	case upperName == "PASSWORD" && pe != nil:
+		return c.field(pe, kpstruct.PasswordField, ctx, depth, n, stack)
+	case upperName == "URL" && pe != nil:
+		return c.field(pe, kpstruct.URLField, ctx, depth, n, stack)
+	case upperName == "NOTES" && pe != nil:
+		return c.field(pe, kpstruct.NotesField, ctx, depth, n, stack)
+	case strings.HasPrefix(upperName, "S:") && pe != nil:
+		return c.field(pe, name[2:], ctx, depth, n, stack)
+
+	case strings.HasPrefix(upperName, "URL:") && pe != nil:
+		s, err := c.compile(pe.Strings.GetSafe(kpstruct.URLField), raw, depth+1, stack)
+		if err != nil {
+			return "", 0, err
+		}
+		s, ok := urlPart(s, upperName[len("URL:"):])
+		if !ok {
+			return "", 0, nil
+		}
+		return ctx.Encoding.encode(s), n, nil
+
+	case strings.HasPrefix(upperName, "REF:") && c.refs != nil:
+		ref, ok := parseReference(text[:n])
+		if !ok {
+			return "", 0, nil
+		}
+		target := c.refs.FindEntry(ref)
+		if target == nil {
+			return "", 0, nil
+		}
+		if ref.Wanted == 'I' {
+			return uuidHex(target.UUID), n, nil
+		}
+		key := refKey{target.UUID, ref.Wanted}
+		for _, k := range stack {
+			if k == key {
+				return "", 0, ErrReferenceCycle
+			}
+		}
+		return c.field(target, refFieldName(ref.Wanted), ctx, depth, n, stack)
+
+	case upperName == "DB_NAME" && ctx.Database != nil:
+		return ctx.Encoding.encode(ctx.Database.Name), n, nil
+
+	case upperName == "GROUP" && pe != nil && pe.ParentGroup() != nil:
+		return ctx.Encoding.encode(pe.ParentGroup().Name), n, nil
+	case upperName == "GROUP_PATH" && pe != nil && pe.ParentGroup() != nil:
+		return ctx.Encoding.encode(groupPath(pe.ParentGroup())), n, nil
+	case upperName == "GROUP_NOTES" && pe != nil && pe.ParentGroup() != nil:
+		return ctx.Encoding.encode(pe.ParentGroup().Notes), n, nil
+
+	case strings.HasPrefix(upperName, "DT_UTC_"):
+		s, ok := dateTime(c.now.UTC(), upperName[len("DT_UTC_"):])
+		if !ok {
+			return "", 0, nil
+		}
+		return s, n, nil
+	case strings.HasPrefix(upperName, "DT_"):
+		s, ok := dateTime(c.now.Local(), upperName[len("DT_"):])
+		if !ok {
+			return "", 0, nil
+		}
+		return s, n, nil
+
+	case strings.HasPrefix(upperName, "ENV:"):
+		return ctx.Encoding.encode(os.Getenv(name[len("ENV:"):])), n, nil
+	case upperName == "ENV_DIRSEP":
+		return ctx.Encoding.encode(string(os.PathSeparator)), n, nil
+	}
+	return "", 0, nil
+}
+
+// field expands the value of a string field of pe in the context of pe, and
+// escapes the result.
+func (c *compiler) field(pe *kpstruct.PasswordEntry, field string, ctx Context, depth, n int, stack []refKey) (string, int, error) {
+	if letter := refFieldLetter(field); letter != 0 {
+		stack = append(stack[:len(stack):len(stack)], refKey{pe.UUID, letter})
+	}
+	ctx.Entry = pe
+	encoding := ctx.Encoding
+	ctx.Encoding = EncodingNone
+	s, err := c.compile(pe.Strings.GetSafe(field), ctx, depth+1, stack)
+	if err != nil {
+		return "", 0, err
+	}
+	return encoding.encode(s), n, nil
+}
+
+// transformArgs parses a transformation placeholder with the given prefix
+// and number of arguments, such as {T-CONV:/text/type/}. The first character
+// after the prefix separates the arguments. It returns the arguments and
+// the length of the placeholder, or 0 if text does not start with one.
+func transformArgs(text, prefix string, count int) ([]string, int) {
+	if len(text) <= len(prefix) || !hasPrefixFold(text, prefix) {
+		return nil, 0
+	}
+	sep := text[len(prefix)]
+	pos := len(prefix) + 1
+	args := make([]string, 0, count)
+	for len(args) < count {
+		i := strings.IndexByte(text[pos:], sep)
+		if i < 0 {
+			return nil, 0
+		}
+		args = append(args, text[pos:pos+i])
+		pos += i + 1
+	}
+	if pos >= len(text) || text[pos] != '}' {
+		return nil, 0
+	}
+	return args, pos + 1
+}
+
+// convert applies a {T-CONV} conversion. It reports whether the conversion
+// is known, and whether its result should be escaped.
+func convert(s, kind string) (result string, ok, encode bool) {
+	switch strings.ToUpper(kind) {
+	case "U", "UPPER":
+		return strings.ToUpper(s), true, true
+	case "L", "LOWER":
+		return strings.ToLower(s), true, true
+	case "BASE64":
+		return base64.StdEncoding.EncodeToString([]byte(s)), true, true
+	case "HEX":
+		return strings.ToUpper(hex.EncodeToString([]byte(s))), true, true
+	case "URI":
+		return escapeDataString(s), true, true
+	case "URI-DEC":
+		dec, err := url.PathUnescape(s)
+		if err != nil {
+			return "", false, false
+		}
+		return dec, true, true
+	case "RAW":
+		return s, true, false
+	}
+	return "", false, false
+}
+
+// urlPart returns a part of rawURL for a {URL:<part>} placeholder.
+func urlPart(rawURL, part string) (string, bool) {
+	if part == "RMVSCM" {
+		if i := strings.Index(rawURL, "://"); i >= 0 {
+			return rawURL[i+3:], true
+		}
+		if i := strings.IndexByte(rawURL, ':'); i >= 0 {
+			return rawURL[i+1:], true
+		}
+		return rawURL, true
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", true
+	}
+	switch part {
+	case "SCM":
+		return u.Scheme, true
+	case "HOST":
+		return u.Hostname(), true
+	case "PORT":
+		if port := u.Port(); port != "" {
+			return port, true
+		}
+		return defaultPorts[strings.ToLower(u.Scheme)], true
+	case "PATH":
+		return u.EscapedPath(), true
+	case "QUERY":
+		if u.RawQuery == "" {
+			return "", true
+		}
+		return "?" + u.RawQuery, true
+	case "USERINFO":
+		if u.User == nil {
+			return "", true
+		}
+		return u.User.String(), true
+	case "USERNAME":
+		if u.User == nil {
+			return "", true
+		}
+		return u.User.Username(), true
+	case "PASSWORD":
+		if u.User == nil {
+			return "", true
+		}
+		pw, _ := u.User.Password()
+		return pw, true
+	}
+	return "", false
+}
+
+// defaultPorts are the ports returned by {URL:PORT} when the URL has none.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ftp":   "21",
+	"ssh":   "22",
+}
+
+// dateTime formats t for a {DT_<part>} placeholder.
+func dateTime(t time.Time, part string) (string, bool) {
+	layout, ok := map[string]string{
+		"SIMPLE": "20060102150405",
+		"YEAR":   "2006",
+		"MONTH":  "01",
+		"DAY":    "02",
+		"HOUR":   "15",
+		"MINUTE": "04",
+		"SECOND": "05",
+	}[part]
+	if !ok {
+		return "", false
+	}
+	return t.Format(layout), true
+}
+
+// groupPath returns the names of pg and its parents, separated by dots. The
+// root group is not included.
+//
+// File: KeePassLib/PwGroup.cs
+// GetFullPath()
+func groupPath(pg *kpstruct.PasswordGroup) string {
+	var names []string
+	for ; pg != nil && pg.Parent() != nil; pg = pg.Parent() {
+		names = append([]string{pg.Name}, names...)
+	}
+	return strings.Join(names, ".")
+}
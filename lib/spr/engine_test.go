@@ -0,0 +1,183 @@
+package spr
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/riking/go-keepass2/lib/database"
+	"github.com/riking/go-keepass2/lib/kpstruct"
+)
+
+// newCompileContext returns a context for an entry in the group Internet/Mail.
+func newCompileContext() *Context {
+	db := database.New()
+	db.Name = "My Database"
+	internet := kpstruct.NewPasswordGroup("Internet")
+	mail := kpstruct.NewPasswordGroup("Mail")
+	mail.Notes = "mail notes"
+	db.Root.AddGroup(internet)
+	internet.AddGroup(mail)
+	pe := newEntry(mail,
+		kpstruct.TitleField, "Example",
+		kpstruct.UserNameField, "bob",
+		kpstruct.PasswordField, "it's secret",
+		kpstruct.URLField, "https://user:pw@www.example.com:8443/path/to?x=1&y=2",
+		kpstruct.NotesField, "notes",
+		"Custom Field", "custom value",
+		"Nested", "{USERNAME}@{TITLE}")
+	newEntry(db.Root, kpstruct.TitleField, "Other", kpstruct.UserNameField, "carol")
+
+	ctx := NewContext(db, pe)
+	ctx.Now = time.Date(2020, time.June, 15, 12, 34, 56, 0, time.UTC)
+	return ctx
+}
+
+func TestCompile(t *testing.T) {
+	os.Setenv("SPR_TEST_VAR", "env value")
+	defer os.Unsetenv("SPR_TEST_VAR")
+
+	tests := []struct {
+		in, want string
+	}{
+		{"{TITLE}", "Example"},
+		{"{title} / {UserName}", "Example / bob"},
+		{"{PASSWORD}", "it's secret"},
+		{"{NOTES}", "notes"},
+		{"{S:Custom Field}", "custom value"},
+		{"{s:Custom Field}", "custom value"},
+		{"{S:Nested}", "bob@Example"},
+		{"{S:Missing}", ""},
+
+		{"{URL}", "https://user:pw@www.example.com:8443/path/to?x=1&y=2"},
+		{"{URL:RMVSCM}", "user:pw@www.example.com:8443/path/to?x=1&y=2"},
+		{"{URL:SCM}", "https"},
+		{"{URL:HOST}", "www.example.com"},
+		{"{URL:PORT}", "8443"},
+		{"{URL:PATH}", "/path/to"},
+		{"{URL:QUERY}", "?x=1&y=2"},
+		{"{URL:USERINFO}", "user:pw"},
+		{"{URL:USERNAME}", "user"},
+		{"{URL:PASSWORD}", "pw"},
+		{"{url:host}", "www.example.com"},
+		{"{URL:NOPE}", "{URL:NOPE}"},
+
+		{"{REF:U@T:other}", "carol"},
+		{"{DB_NAME}", "My Database"},
+		{"{GROUP}", "Mail"},
+		{"{GROUP_PATH}", "Internet.Mail"},
+		{"{GROUP_NOTES}", "mail notes"},
+
+		{"{DT_UTC_SIMPLE}", "20200615123456"},
+		{"{DT_UTC_YEAR}-{DT_UTC_MONTH}-{DT_UTC_DAY}", "2020-06-15"},
+		{"{DT_UTC_HOUR}:{DT_UTC_MINUTE}:{DT_UTC_SECOND}", "12:34:56"},
+		{"{DT_YEAR}", "2020"},
+		{"{DT_SIMPLE}", time.Date(2020, time.June, 15, 12, 34, 56, 0, time.UTC).Local().Format("20060102150405")},
+		{"{DT_UTC_WEEK}", "{DT_UTC_WEEK}"},
+
+		{"{ENV:SPR_TEST_VAR}", "env value"},
+		{"{ENV_DIRSEP}", string(os.PathSeparator)},
+
+		{"{T-REPLACE-RX:/abc/(b)/[$1]/}", "a[b]c"},
+		{"{T-REPLACE-RX:|{USERNAME}|o|0|}", "b0b"},
+		{"{T-REPLACE-RX:#a/b/c#/#-#}", "a-b-c"},
+		{"{T-REPLACE-RX:/abc/(/x/}", "{T-REPLACE-RX:/abc/(/x/}"},
+		{"{T-CONV:/{TITLE}/U/}", "EXAMPLE"},
+		{"{T-CONV:|{TITLE}|Lower|}", "example"},
+		{"{T-CONV:/bob/Base64/}", "Ym9i"},
+		{"{T-CONV:,bob,hex,}", "626F62"},
+		{"{T-CONV:#a b/c#Uri#}", "a%20b%2Fc"},
+		{"{T-CONV:/a%20b/Uri-Dec/}", "a b"},
+		{"{T-CONV:/x/Nope/}", "{T-CONV:/x/Nope/}"},
+		{"{T-CONV:/x/U}", "{T-CONV:/x/U}"},
+
+		{"{UNKNOWN} {", "{UNKNOWN} {"},
+		{"{{TITLE}}", "{Example}"},
+		{"no placeholders", "no placeholders"},
+	}
+	for _, tt := range tests {
+		got, err := Compile(tt.in, newCompileContext())
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("Compile(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCompileURLDefaults(t *testing.T) {
+	ctx := newCompileContext()
+	pe := newEntry(ctx.Database.Root, kpstruct.URLField, "http://example.org")
+	ctx.Entry = pe
+
+	tests := []struct {
+		in, want string
+	}{
+		{"{URL:PORT}", "80"},
+		{"{URL:QUERY}", ""},
+		{"{URL:USERINFO}", ""},
+		{"{URL:PATH}", ""},
+		// Entries in the root group have no group path
+		{"{GROUP_PATH}", ""},
+	}
+	for _, tt := range tests {
+		got, err := Compile(tt.in, ctx)
+		if err != nil || got != tt.want {
+			t.Errorf("Compile(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestCompileEncoding(t *testing.T) {
+	tests := []struct {
+		encoding Encoding
+		in, want string
+	}{
+		{EncodingShell, "{PASSWORD}", `'it'\''s secret'`},
+		{EncodingShell, "echo {USERNAME} {PASSWORD}", `echo 'bob' 'it'\''s secret'`},
+		// Nested values are escaped once, as a whole
+		{EncodingShell, "{S:Nested}", `'bob@Example'`},
+		{EncodingShell, "{T-CONV:/{PASSWORD}/U/}", `'IT'\''S SECRET'`},
+		{EncodingShell, "{T-CONV:/{PASSWORD}/Raw/}", `it's secret`},
+		{EncodingShell, "{T-REPLACE-RX:/{PASSWORD}/ /_/}", `'it'\''s_secret'`},
+		{EncodingShell, "{URL:HOST}", `'www.example.com'`},
+		{EncodingShell, "{DT_UTC_YEAR}", `2020`},
+		{EncodingURL, "{PASSWORD}", "it%27s%20secret"},
+		{EncodingURL, "https://host/?u={USERNAME}&p={PASSWORD}", "https://host/?u=bob&p=it%27s%20secret"},
+		{EncodingURL, "{URL:QUERY}", "%3Fx%3D1%26y%3D2"},
+		{EncodingURL, "{GROUP_PATH}", "Internet.Mail"},
+		{EncodingURL, "{DB_NAME}", "My%20Database"},
+		{EncodingURL, "{T-CONV:/{PASSWORD}/Raw/}", "it's secret"},
+	}
+	for _, tt := range tests {
+		ctx := newCompileContext()
+		ctx.Encoding = tt.encoding
+		got, err := Compile(tt.in, ctx)
+		if err != nil {
+			t.Errorf("Compile(%q, %d): %v", tt.in, tt.encoding, err)
+		} else if got != tt.want {
+			t.Errorf("Compile(%q, %d) = %s, want %s", tt.in, tt.encoding, got, tt.want)
+		}
+	}
+}
+
+func TestCompileLimits(t *testing.T) {
+	db := database.New()
+	loop := newEntry(db.Root, kpstruct.TitleField, "{PASSWORD}", kpstruct.PasswordField, "{TITLE}")
+	a := newEntry(db.Root, kpstruct.TitleField, "Cee", kpstruct.PasswordField, "{REF:P@T:Dee}")
+	newEntry(db.Root, kpstruct.TitleField, "Dee", kpstruct.PasswordField, "{REF:P@T:Cee}")
+
+	// A zero Context uses the default depth limit
+	if _, err := Compile("{TITLE}", &Context{Entry: loop, Database: db}); err != ErrRecursionLimit {
+		t.Errorf("placeholder loop: got %v, want ErrRecursionLimit", err)
+	}
+	if _, err := Compile("{PASSWORD}", NewContext(db, a)); err != ErrReferenceCycle {
+		t.Errorf("reference cycle: got %v, want ErrReferenceCycle", err)
+	}
+
+	// Placeholders that need a missing entry or database are left unchanged
+	got, err := Compile("{TITLE} {REF:T@T:Cee} {DB_NAME} {GROUP}", NewContext(nil, nil))
+	if err != nil || got != "{TITLE} {REF:T@T:Cee} {DB_NAME} {GROUP}" {
+		t.Errorf("empty context: got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,349 @@
+// Package spr expands the field references and placeholders that KeePass
+// supports in entry fields.
+package spr
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/riking/go-keepass2/lib/database"
+	"github.com/riking/go-keepass2/lib/kpstruct"
+	"github.com/satori/go.uuid"
+)
+
+// MaxRecursionDepth is the default limit on how deeply references and
+// placeholders are expanded inside the values they are replaced with.
+//
+// File: KeePass/Util/Spr/SprEngine.cs
+// MaxRecursionDepth
+const MaxRecursionDepth = 12
+
+var (
+	// ErrRecursionLimit is returned when expanding a value needs more than
+	// the allowed depth of nested expansions.
+	ErrRecursionLimit = errors.New("spr: recursion limit reached")
+	// ErrReferenceCycle is returned when a field reference refers, directly
+	// or indirectly, to itself.
+	ErrReferenceCycle = errors.New("spr: field reference cycle")
+)
+
+const refPrefix = "{REF:"
+
+// Reference is a parsed field reference of the form
+// {REF:<Wanted>@<SearchIn>:<Text>}.
+//
+// Wanted is one of T (title), U (user name), P (password), A (URL),
+// N (notes) or I (UUID). SearchIn is one of the same letters, or O to search
+// the custom string fields. As in KeePass, O is only valid as SearchIn, as
+// a reference cannot name the custom field it wants. Entries are found by a
+// case-insensitive substring match of Text, except for I, which must be the
+// hex UUID.
+//
+// File: KeePass/Util/Spr/SprEngine.cs
+// FillRefPlaceholders()
+type Reference struct {
+	// Placeholder is the full text of the reference, including the braces.
+	Placeholder string
+	Wanted      byte
+	SearchIn    byte
+	Text        string
+}
+
+// ParseReferences returns the well-formed field references in s, in order.
+func ParseReferences(s string) []Reference {
+	var refs []Reference
+	for {
+		i, j := nextReference(s)
+		if i < 0 {
+			return refs
+		}
+		if ref, ok := parseReference(s[i:j]); ok {
+			refs = append(refs, ref)
+		}
+		s = s[j:]
+	}
+}
+
+// nextReference returns the bounds of the next {REF:...} in s, or -1.
+func nextReference(s string) (start, end int) {
+	start = indexFold(s, refPrefix)
+	if start < 0 {
+		return -1, -1
+	}
+	n := strings.IndexByte(s[start:], '}')
+	if n < 0 {
+		return -1, -1
+	}
+	return start, start + n + 1
+}
+
+func parseReference(placeholder string) (Reference, bool) {
+	if len(placeholder) < len(refPrefix)+1 || !hasPrefixFold(placeholder, refPrefix) {
+		return Reference{}, false
+	}
+	body := placeholder[len(refPrefix) : len(placeholder)-1]
+	if len(body) < 4 || body[1] != '@' || body[3] != ':' {
+		return Reference{}, false
+	}
+	ref := Reference{
+		Placeholder: placeholder,
+		Wanted:      upper(body[0]),
+		SearchIn:    upper(body[2]),
+		Text:        body[4:],
+	}
+	if refFieldName(ref.Wanted) == "" && ref.Wanted != 'I' {
+		return Reference{}, false
+	}
+	if refFieldName(ref.SearchIn) == "" && ref.SearchIn != 'I' && ref.SearchIn != 'O' {
+		return Reference{}, false
+	}
+	return ref, true
+}
+
+func upper(c byte) byte {
+	if 'a' <= c && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
+
+// upperASCII upper-cases the ASCII letters of s. Unlike strings.ToUpper, it
+// keeps byte offsets into s valid.
+func upperASCII(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		b[i] = upper(c)
+	}
+	return string(b)
+}
+
+// hasPrefixFold reports whether s starts with the upper-case ASCII prefix,
+// ignoring the case of ASCII letters.
+func hasPrefixFold(s, prefix string) bool {
+	if len(s) < len(prefix) {
+		return false
+	}
+	for i := 0; i < len(prefix); i++ {
+		if upper(s[i]) != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// indexFold returns the index of the first instance of the upper-case ASCII
+// prefix in s, ignoring the case of ASCII letters, or -1.
+func indexFold(s, prefix string) int {
+	for i := 0; i+len(prefix) <= len(s); i++ {
+		if hasPrefixFold(s[i:], prefix) {
+			return i
+		}
+	}
+	return -1
+}
+
+// refFieldName returns the standard field for a reference field letter.
+func refFieldName(c byte) string {
+	switch c {
+	case 'T':
+		return kpstruct.TitleField
+	case 'U':
+		return kpstruct.UserNameField
+	case 'P':
+		return kpstruct.PasswordField
+	case 'A':
+		return kpstruct.URLField
+	case 'N':
+		return kpstruct.NotesField
+	}
+	return ""
+}
+
+// refFieldLetter is the inverse of refFieldName, returning 0 for custom
+// fields.
+func refFieldLetter(field string) byte {
+	for _, c := range []byte("TUPAN") {
+		if refFieldName(c) == field {
+			return c
+		}
+	}
+	return 0
+}
+
+// uuidHex formats id the way KeePass writes it in references.
+func uuidHex(id uuid.UUID) string {
+	return strings.ToUpper(hex.EncodeToString(id.Bytes()))
+}
+
+// depthLimit returns the recursion limit for a MaxDepth setting.
+func depthLimit(maxDepth int) int {
+	if maxDepth <= 0 {
+		return MaxRecursionDepth
+	}
+	return maxDepth
+}
+
+// RefResolver resolves field references against the entries of a database.
+type RefResolver struct {
+	db *database.Database
+	// MaxDepth limits how deeply references inside referenced values are
+	// resolved. If it is zero, MaxRecursionDepth is used.
+	MaxDepth int
+}
+
+// NewRefResolver creates a RefResolver for db with the default depth limit.
+func NewRefResolver(db *database.Database) *RefResolver {
+	return &RefResolver{db: db, MaxDepth: MaxRecursionDepth}
+}
+
+// refKey identifies a field of an entry during resolution, for cycle
+// detection.
+type refKey struct {
+	entry uuid.UUID
+	field byte
+}
+
+// Resolve replaces the field references in s with the values they refer
+// to. References to missing entries are left unchanged.
+func (r *RefResolver) Resolve(s string) (string, error) {
+	return r.resolve(s, 0, nil)
+}
+
+// ResolveField returns the value of a string field of pe, with its field
+// references resolved.
+func (r *RefResolver) ResolveField(pe *kpstruct.PasswordEntry, field string) (string, error) {
+	var stack []refKey
+	if c := refFieldLetter(field); c != 0 {
+		stack = append(stack, refKey{pe.UUID, c})
+	}
+	return r.resolve(pe.Strings.GetSafe(field), 0, stack)
+}
+
+func (r *RefResolver) resolve(s string, depth int, stack []refKey) (string, error) {
+	if indexFold(s, refPrefix) < 0 {
+		return s, nil
+	}
+	if depth >= depthLimit(r.MaxDepth) {
+		return s, ErrRecursionLimit
+	}
+
+	var out strings.Builder
+	for {
+		i, j := nextReference(s)
+		if i < 0 {
+			break
+		}
+		out.WriteString(s[:i])
+		placeholder := s[i:j]
+		s = s[j:]
+
+		ref, ok := parseReference(placeholder)
+		var target *kpstruct.PasswordEntry
+		if ok {
+			target = r.FindEntry(ref)
+		}
+		if target == nil {
+			out.WriteString(placeholder)
+			continue
+		}
+		if ref.Wanted == 'I' {
+			out.WriteString(uuidHex(target.UUID))
+			continue
+		}
+
+		key := refKey{target.UUID, ref.Wanted}
+		for _, k := range stack {
+			if k == key {
+				return out.String() + placeholder + s, ErrReferenceCycle
+			}
+		}
+		value, err := r.resolve(target.Strings.GetSafe(refFieldName(ref.Wanted)), depth+1, append(stack[:len(stack):len(stack)], key))
+		if err != nil {
+			return out.String() + placeholder + s, err
+		}
+		out.WriteString(value)
+	}
+	out.WriteString(s)
+	return out.String(), nil
+}
+
+// FindEntry returns the first entry, in pre-order, that ref refers to, or
+// nil.
+func (r *RefResolver) FindEntry(ref Reference) *kpstruct.PasswordEntry {
+	if ref.SearchIn == 'I' {
+		b, err := hex.DecodeString(ref.Text)
+		if err != nil {
+			return nil
+		}
+		id, err := uuid.FromBytes(b)
+		if err != nil {
+			return nil
+		}
+		return r.db.Root.FindEntry(id, true)
+	}
+
+	text := strings.ToLower(ref.Text)
+	var found *kpstruct.PasswordEntry
+	r.db.Root.TraverseTree(kpstruct.TraversalMethodPreOrder, nil, func(pe *kpstruct.PasswordEntry) kpstruct.TraverseAction {
+		if entryMatches(pe, ref.SearchIn, text) {
+			found = pe
+			return kpstruct.TraverseStop
+		}
+		return kpstruct.TraverseContinue
+	})
+	return found
+}
+
+func entryMatches(pe *kpstruct.PasswordEntry, searchIn byte, lowerText string) bool {
+	if searchIn != 'O' {
+		value := pe.Strings.GetSafe(refFieldName(searchIn))
+		return strings.Contains(strings.ToLower(value), lowerText)
+	}
+	for _, name := range pe.Strings.Keys() {
+		if kpstruct.IsStandardField(name) {
+			continue
+		}
+		if strings.Contains(strings.ToLower(pe.Strings.GetSafe(name)), lowerText) {
+			return true
+		}
+	}
+	return false
+}
+
+// Link is a field reference from a field of one entry to another entry.
+type Link struct {
+	Source *kpstruct.PasswordEntry
+	Field  string
+	Target *kpstruct.PasswordEntry
+	Ref    Reference
+}
+
+// Report returns the field references of all entries in the database that
+// refer to an existing entry. History entries are not included.
+func (r *RefResolver) Report() []Link {
+	var links []Link
+	r.db.Root.TraverseTree(kpstruct.TraversalMethodPreOrder, nil, func(pe *kpstruct.PasswordEntry) kpstruct.TraverseAction {
+		for _, field := range pe.Strings.Keys() {
+			for _, ref := range ParseReferences(pe.Strings.GetSafe(field)) {
+				if target := r.FindEntry(ref); target != nil {
+					links = append(links, Link{Source: pe, Field: field, Target: target, Ref: ref})
+				}
+			}
+		}
+		return kpstruct.TraverseContinue
+	})
+	return links
+}
+
+// ReferencesTo returns the field references of other entries that refer to
+// target, so that deleting it can be confirmed first.
+func (r *RefResolver) ReferencesTo(target *kpstruct.PasswordEntry) []Link {
+	var links []Link
+	for _, link := range r.Report() {
+		if link.Target == target && link.Source != target {
+			links = append(links, link)
+		}
+	}
+	return links
+}
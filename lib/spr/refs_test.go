@@ -0,0 +1,217 @@
+package spr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/riking/go-keepass2/lib/database"
+	"github.com/riking/go-keepass2/lib/kpcrypto"
+	"github.com/riking/go-keepass2/lib/kpstruct"
+)
+
+// newEntry adds an entry with the given fields, alternating names and
+// values, to pg.
+func newEntry(pg *kpstruct.PasswordGroup, fields ...string) *kpstruct.PasswordEntry {
+	pe := kpstruct.NewPasswordEntry()
+	for i := 0; i+1 < len(fields); i += 2 {
+		pe.Strings.Set(fields[i], kpcrypto.NewProtectedString(false, fields[i+1]))
+	}
+	pg.AddEntry(pe)
+	return pe
+}
+
+// newRefDatabase returns a database where Bravo refers to Alpha.
+func newRefDatabase() (db *database.Database, alpha, bravo *kpstruct.PasswordEntry) {
+	db = database.New()
+	alpha = newEntry(db.Root,
+		kpstruct.TitleField, "Alpha",
+		kpstruct.UserNameField, "alice",
+		kpstruct.PasswordField, "pa55",
+		kpstruct.URLField, "https://alpha.example.com/",
+		kpstruct.NotesField, "alpha notes",
+		"Custom", "needle")
+	group := kpstruct.NewPasswordGroup("Group")
+	db.Root.AddGroup(group)
+	bravo = newEntry(group,
+		kpstruct.TitleField, "Bravo",
+		kpstruct.UserNameField, "{REF:U@T:alpha}",
+		kpstruct.PasswordField, "{ref:p@t:ALPHA}",
+		kpstruct.NotesField, "{REF:T@I:"+uuidHex(alpha.UUID)+"} and {REF:T@T:nothing}")
+	return db, alpha, bravo
+}
+
+func TestResolve(t *testing.T) {
+	db, alpha, _ := newRefDatabase()
+	alphaHex := uuidHex(alpha.UUID)
+	r := NewRefResolver(db)
+
+	tests := []struct {
+		in, want string
+	}{
+		// Each Wanted letter
+		{"{REF:T@T:alp}", "Alpha"},
+		{"{REF:U@T:alp}", "alice"},
+		{"{REF:P@T:alp}", "pa55"},
+		{"{REF:A@T:alp}", "https://alpha.example.com/"},
+		{"{REF:N@T:alp}", "alpha notes"},
+		{"{REF:I@T:alp}", alphaHex},
+		// Each SearchIn letter
+		{"{REF:T@U:ALICE}", "Alpha"},
+		{"{REF:T@P:pa55}", "Alpha"},
+		{"{REF:T@A:alpha.example}", "Alpha"},
+		{"{REF:T@N:NOTES}", "Alpha"},
+		{"{REF:T@O:needle}", "Alpha"},
+		{"{REF:T@I:" + alphaHex + "}", "Alpha"},
+		{"{REF:T@I:" + strings.ToLower(alphaHex) + "}", "Alpha"},
+		// Case of the placeholder
+		{"{ref:t@t:alpha}", "Alpha"},
+		{"{Ref:P@t:Alpha}", "pa55"},
+		// References inside the referenced value
+		{"{REF:U@T:bravo}", "alice"},
+		{"{REF:N@T:bravo}", "Alpha and {REF:T@T:nothing}"},
+		// Missing targets and malformed references are kept
+		{"{REF:T@T:nothing}", "{REF:T@T:nothing}"},
+		{"{REF:T@I:00112233445566778899AABBCCDDEEFF}", "{REF:T@I:00112233445566778899AABBCCDDEEFF}"},
+		{"{REF:T@I:not hex}", "{REF:T@I:not hex}"},
+		{"{REF:O@T:alpha}", "{REF:O@T:alpha}"},
+		{"{REF:X@T:alpha}", "{REF:X@T:alpha}"},
+		{"{REF:T@X:alpha}", "{REF:T@X:alpha}"},
+		{"{REF:T-T:alpha}", "{REF:T-T:alpha}"},
+		{"{REF:T@T:alpha", "{REF:T@T:alpha"},
+		// Surrounding text
+		{"user {REF:U@T:alpha}, {REF:T@T:none} and {REF:P@T:alpha}!", "user alice, {REF:T@T:none} and pa55!"},
+		{"no references", "no references"},
+	}
+	for _, tt := range tests {
+		got, err := r.Resolve(tt.in)
+		if err != nil {
+			t.Errorf("Resolve(%q): %v", tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("Resolve(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestResolveField(t *testing.T) {
+	db, _, bravo := newRefDatabase()
+	r := NewRefResolver(db)
+	got, err := r.ResolveField(bravo, kpstruct.PasswordField)
+	if err != nil || got != "pa55" {
+		t.Errorf("ResolveField = %q, %v, want %q", got, err, "pa55")
+	}
+}
+
+func TestResolveCycles(t *testing.T) {
+	db := database.New()
+	a := newEntry(db.Root, kpstruct.TitleField, "Cee", kpstruct.PasswordField, "{REF:P@T:Dee}")
+	newEntry(db.Root, kpstruct.TitleField, "Dee", kpstruct.PasswordField, "{REF:P@T:Cee}")
+	self := newEntry(db.Root, kpstruct.TitleField, "Self", kpstruct.PasswordField, "x{REF:P@T:Self}")
+	// Different fields of one entry are not a cycle
+	fields := newEntry(db.Root, kpstruct.TitleField, "Fields", kpstruct.UserNameField, "{REF:P@T:Fields}", kpstruct.PasswordField, "{REF:T@T:Fields}")
+	r := NewRefResolver(db)
+
+	if _, err := r.ResolveField(a, kpstruct.PasswordField); err != ErrReferenceCycle {
+		t.Errorf("A <-> B: got %v, want ErrReferenceCycle", err)
+	}
+	if _, err := r.Resolve("{REF:P@T:Cee}"); err != ErrReferenceCycle {
+		t.Errorf("A <-> B from outside: got %v, want ErrReferenceCycle", err)
+	}
+	if _, err := r.ResolveField(self, kpstruct.PasswordField); err != ErrReferenceCycle {
+		t.Errorf("self reference: got %v, want ErrReferenceCycle", err)
+	}
+	if got, err := r.ResolveField(fields, kpstruct.UserNameField); err != nil || got != "Fields" {
+		t.Errorf("fields of one entry: got %q, %v", got, err)
+	}
+}
+
+func TestResolveDepth(t *testing.T) {
+	// A chain of 14 references, link00 -> link01 -> ... -> link14
+	db := database.New()
+	var first *kpstruct.PasswordEntry
+	for i := 0; i < 15; i++ {
+		password := "end"
+		if i < 14 {
+			password = fmt.Sprintf("{REF:P@T:link%02d}", i+1)
+		}
+		pe := newEntry(db.Root, kpstruct.TitleField, fmt.Sprintf("link%02d", i), kpstruct.PasswordField, password)
+		if first == nil {
+			first = pe
+		}
+	}
+
+	tests := []struct {
+		name string
+		r    *RefResolver
+		err  error
+	}{
+		{"default", NewRefResolver(db), ErrRecursionLimit},
+		{"zero", &RefResolver{db: db}, ErrRecursionLimit},
+		{"13", &RefResolver{db: db, MaxDepth: 13}, ErrRecursionLimit},
+		{"14", &RefResolver{db: db, MaxDepth: 14}, nil},
+	}
+	for _, tt := range tests {
+		got, err := tt.r.ResolveField(first, kpstruct.PasswordField)
+		if err != tt.err {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+		} else if err == nil && got != "end" {
+			t.Errorf("%s: got %q, want %q", tt.name, got, "end")
+		}
+	}
+}
+
+func TestParseReferences(t *testing.T) {
+	refs := ParseReferences("{REF:T@T:a} {REF:bad} {ref:i@o:b c}{REF:P@I:0}")
+	want := []Reference{
+		{"{REF:T@T:a}", 'T', 'T', "a"},
+		{"{ref:i@o:b c}", 'I', 'O', "b c"},
+		{"{REF:P@I:0}", 'P', 'I', "0"},
+	}
+	if len(refs) != len(want) {
+		t.Fatalf("got %+v, want %+v", refs, want)
+	}
+	for i := range want {
+		if refs[i] != want[i] {
+			t.Errorf("reference %d: got %+v, want %+v", i, refs[i], want[i])
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	db, alpha, bravo := newRefDatabase()
+	self := newEntry(db.Root, kpstruct.TitleField, "Selfish", kpstruct.NotesField, "{REF:T@T:selfish}")
+	r := NewRefResolver(db)
+
+	var got []string
+	for _, link := range r.Report() {
+		got = append(got, fmt.Sprintf("%s.%s -> %s", link.Source.Strings.GetSafe(kpstruct.TitleField), link.Field,
+			link.Target.Strings.GetSafe(kpstruct.TitleField)))
+	}
+	sort.Strings(got)
+	want := []string{
+		"Bravo.Notes -> Alpha",
+		"Bravo.Password -> Alpha",
+		"Bravo.UserName -> Alpha",
+		"Selfish.Notes -> Selfish",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Report:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	links := r.ReferencesTo(alpha)
+	if len(links) != 3 {
+		t.Errorf("ReferencesTo(alpha): %d links, want 3", len(links))
+	}
+	for _, link := range links {
+		if link.Source != bravo || link.Target != alpha {
+			t.Errorf("ReferencesTo(alpha): link %+v", link)
+		}
+	}
+	if links := r.ReferencesTo(bravo); len(links) != 0 {
+		t.Errorf("ReferencesTo(bravo): %d links, want 0", len(links))
+	}
+	if links := r.ReferencesTo(self); len(links) != 0 {
+		t.Errorf("ReferencesTo(self) includes its own reference")
+	}
+}
@@ -0,0 +1,329 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// VdType identifies the type of a value in a VariantDictionary.
+//
+// File: KeePassLib/Collections/VariantDictionary.cs
+// enum VdType
+type VdType byte
+
+const (
+	VdTypeNone      VdType = 0
+	VdTypeUInt32    VdType = 0x04
+	VdTypeUInt64    VdType = 0x05
+	VdTypeBool      VdType = 0x08
+	VdTypeInt32     VdType = 0x0C
+	VdTypeInt64     VdType = 0x0D
+	VdTypeString    VdType = 0x18
+	VdTypeByteArray VdType = 0x42
+)
+
+// File: KeePassLib/Collections/VariantDictionary.cs
+// VdVersion, VdmCritical
+const (
+	vdVersion   = 0x0100
+	vdmCritical = 0xFF00
+)
+
+// ErrVariantDictionaryCorrupted is returned when a serialized VariantDictionary
+// cannot be parsed.
+var ErrVariantDictionaryCorrupted = errors.New("keepass: variant dictionary is corrupted")
+
+// VariantDictionary is a string-keyed dictionary of typed values, used for
+// the KDBX 4 KDF parameters and public custom data.
+// The insertion order of the keys is preserved when serializing.
+//
+// Values must be one of uint32, uint64, bool, int32, int64, string or []byte.
+//
+// File: KeePassLib/Collections/VariantDictionary.cs
+type VariantDictionary struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// NewVariantDictionary creates an empty VariantDictionary.
+func NewVariantDictionary() *VariantDictionary {
+	return &VariantDictionary{values: make(map[string]interface{})}
+}
+
+// Len returns the number of items in the dictionary.
+func (vd *VariantDictionary) Len() int {
+	return len(vd.keys)
+}
+
+// Keys returns the keys of the dictionary, in insertion order.
+func (vd *VariantDictionary) Keys() []string {
+	return append([]string(nil), vd.keys...)
+}
+
+// Get returns the value stored under key, if any.
+func (vd *VariantDictionary) Get(key string) (value interface{}, ok bool) {
+	value, ok = vd.values[key]
+	return
+}
+
+// Set stores a value under key. It panics if the value is not of a supported type.
+func (vd *VariantDictionary) Set(key string, value interface{}) {
+	if variantType(value) == VdTypeNone {
+		panic(fmt.Errorf("keepass.VariantDictionary.Set: unsupported value type %T", value))
+	}
+	if _, exists := vd.values[key]; !exists {
+		vd.keys = append(vd.keys, key)
+	}
+	vd.values[key] = value
+}
+
+// Remove deletes the value stored under key.
+func (vd *VariantDictionary) Remove(key string) {
+	if _, exists := vd.values[key]; !exists {
+		return
+	}
+	delete(vd.values, key)
+	for i, k := range vd.keys {
+		if k == key {
+			vd.keys = append(vd.keys[:i], vd.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+func (vd *VariantDictionary) GetUInt32(key string, defaultValue uint32) uint32 {
+	if v, ok := vd.values[key].(uint32); ok {
+		return v
+	}
+	return defaultValue
+}
+
+func (vd *VariantDictionary) GetUInt64(key string, defaultValue uint64) uint64 {
+	if v, ok := vd.values[key].(uint64); ok {
+		return v
+	}
+	return defaultValue
+}
+
+func (vd *VariantDictionary) GetBool(key string, defaultValue bool) bool {
+	if v, ok := vd.values[key].(bool); ok {
+		return v
+	}
+	return defaultValue
+}
+
+func (vd *VariantDictionary) GetInt32(key string, defaultValue int32) int32 {
+	if v, ok := vd.values[key].(int32); ok {
+		return v
+	}
+	return defaultValue
+}
+
+func (vd *VariantDictionary) GetInt64(key string, defaultValue int64) int64 {
+	if v, ok := vd.values[key].(int64); ok {
+		return v
+	}
+	return defaultValue
+}
+
+func (vd *VariantDictionary) GetString(key string) string {
+	v, _ := vd.values[key].(string)
+	return v
+}
+
+// GetByteArray returns the byte array stored under key, or nil.
+// The returned slice must not be modified.
+func (vd *VariantDictionary) GetByteArray(key string) []byte {
+	v, _ := vd.values[key].([]byte)
+	return v
+}
+
+// Clone returns a copy of the dictionary. Byte arrays are copied too.
+//
+// File: KeePassLib/Collections/VariantDictionary.cs
+// CloneDeep()
+func (vd *VariantDictionary) Clone() *VariantDictionary {
+	out := NewVariantDictionary()
+	vd.CopyTo(out)
+	return out
+}
+
+// CopyTo copies all items of this dictionary into dest, overwriting items
+// with the same key.
+//
+// File: KeePassLib/Collections/VariantDictionary.cs
+// CopyTo()
+func (vd *VariantDictionary) CopyTo(dest *VariantDictionary) {
+	for _, k := range vd.keys {
+		v := vd.values[k]
+		if b, ok := v.([]byte); ok {
+			v = append([]byte(nil), b...)
+		}
+		dest.Set(k, v)
+	}
+}
+
+func variantType(value interface{}) VdType {
+	switch value.(type) {
+	case uint32:
+		return VdTypeUInt32
+	case uint64:
+		return VdTypeUInt64
+	case bool:
+		return VdTypeBool
+	case int32:
+		return VdTypeInt32
+	case int64:
+		return VdTypeInt64
+	case string:
+		return VdTypeString
+	case []byte:
+		return VdTypeByteArray
+	}
+	return VdTypeNone
+}
+
+// MarshalBinary serializes the dictionary.
+//
+// File: KeePassLib/Collections/VariantDictionary.cs
+// Serialize()
+func (vd *VariantDictionary) MarshalBinary() ([]byte, error) {
+	buf := bytes.Buffer{}
+	var scratch [8]byte
+
+	binary.LittleEndian.PutUint16(scratch[0:2], vdVersion)
+	buf.Write(scratch[0:2])
+
+	for _, k := range vd.keys {
+		v := vd.values[k]
+		var data []byte
+		switch v := v.(type) {
+		case uint32:
+			binary.LittleEndian.PutUint32(scratch[0:4], v)
+			data = scratch[0:4]
+		case uint64:
+			binary.LittleEndian.PutUint64(scratch[0:8], v)
+			data = scratch[0:8]
+		case bool:
+			scratch[0] = 0
+			if v {
+				scratch[0] = 1
+			}
+			data = scratch[0:1]
+		case int32:
+			binary.LittleEndian.PutUint32(scratch[0:4], uint32(v))
+			data = scratch[0:4]
+		case int64:
+			binary.LittleEndian.PutUint64(scratch[0:8], uint64(v))
+			data = scratch[0:8]
+		case string:
+			data = []byte(v)
+		case []byte:
+			data = v
+		}
+
+		var head [5]byte
+		head[0] = byte(variantType(v))
+		binary.LittleEndian.PutUint32(head[1:5], uint32(len(k)))
+		buf.Write(head[:])
+		buf.WriteString(k)
+		binary.LittleEndian.PutUint32(head[1:5], uint32(len(data)))
+		buf.Write(head[1:5])
+		buf.Write(data)
+	}
+
+	buf.WriteByte(byte(VdTypeNone))
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces the contents of the dictionary with the
+// serialized data. Items of unknown types are skipped.
+//
+// File: KeePassLib/Collections/VariantDictionary.cs
+// Deserialize()
+func (vd *VariantDictionary) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	readFull := func(n uint32) ([]byte, error) {
+		if uint64(n) > uint64(r.Len()) {
+			return nil, ErrVariantDictionaryCorrupted
+		}
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		return b, err
+	}
+
+	vd.keys = nil
+	vd.values = make(map[string]interface{})
+
+	var scratch [4]byte
+	if _, err := io.ReadFull(r, scratch[0:2]); err != nil {
+		return ErrVariantDictionaryCorrupted
+	}
+	version := binary.LittleEndian.Uint16(scratch[0:2])
+	if (version & vdmCritical) > (vdVersion & vdmCritical) {
+		return ErrVariantDictionaryCorrupted
+	}
+
+	for {
+		typ, err := r.ReadByte()
+		if err != nil {
+			return ErrVariantDictionaryCorrupted
+		}
+		if VdType(typ) == VdTypeNone {
+			return nil
+		}
+
+		if _, err = io.ReadFull(r, scratch[0:4]); err != nil {
+			return ErrVariantDictionaryCorrupted
+		}
+		name, err := readFull(binary.LittleEndian.Uint32(scratch[0:4]))
+		if err != nil {
+			return ErrVariantDictionaryCorrupted
+		}
+		if _, err = io.ReadFull(r, scratch[0:4]); err != nil {
+			return ErrVariantDictionaryCorrupted
+		}
+		value, err := readFull(binary.LittleEndian.Uint32(scratch[0:4]))
+		if err != nil {
+			return ErrVariantDictionaryCorrupted
+		}
+
+		key := string(name)
+		switch VdType(typ) {
+		case VdTypeUInt32:
+			if len(value) != 4 {
+				return ErrVariantDictionaryCorrupted
+			}
+			vd.Set(key, binary.LittleEndian.Uint32(value))
+		case VdTypeUInt64:
+			if len(value) != 8 {
+				return ErrVariantDictionaryCorrupted
+			}
+			vd.Set(key, binary.LittleEndian.Uint64(value))
+		case VdTypeBool:
+			if len(value) != 1 {
+				return ErrVariantDictionaryCorrupted
+			}
+			vd.Set(key, value[0] != 0)
+		case VdTypeInt32:
+			if len(value) != 4 {
+				return ErrVariantDictionaryCorrupted
+			}
+			vd.Set(key, int32(binary.LittleEndian.Uint32(value)))
+		case VdTypeInt64:
+			if len(value) != 8 {
+				return ErrVariantDictionaryCorrupted
+			}
+			vd.Set(key, int64(binary.LittleEndian.Uint64(value)))
+		case VdTypeString:
+			vd.Set(key, string(value))
+		case VdTypeByteArray:
+			vd.Set(key, value)
+		default:
+			// Unknown types are skipped, like KeePass does.
+		}
+	}
+}